@@ -0,0 +1,109 @@
+package global
+
+import (
+	"log"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+	"github.com/snowie2000/livetv/model"
+)
+
+// scopes of shared state that instances cache
+const (
+	ScopeConfig      = "config"
+	ScopeChannels    = "channels"
+	ScopeWebhooks    = "webhooks"
+	ScopeSubscribers = "subscribers"
+	ScopeAccess      = "access"
+)
+
+var (
+	changeLock     sync.Mutex
+	changeVersions = make(map[string]int64) // scope => last version seen by this instance
+	changeHandlers = make(map[string][]func())
+)
+
+// OnChange registers a function that is called when another instance has changed the scope
+func OnChange(scope string, fn func()) {
+	changeLock.Lock()
+	defer changeLock.Unlock()
+	changeHandlers[scope] = append(changeHandlers[scope], fn)
+}
+
+// NotifyChange tells other instances sharing the database that the scope has changed
+func NotifyChange(scope string) {
+	changeLock.Lock()
+	defer changeLock.Unlock()
+	tx := DB.Begin()
+	res := tx.Model(&model.ChangeVersion{}).Where("scope = ?", scope).UpdateColumn("version", gorm.Expr("version + 1"))
+	err := res.Error
+	if err == nil && res.RowsAffected == 0 {
+		err = tx.Create(&model.ChangeVersion{Scope: scope, Version: 1}).Error
+	}
+	var row model.ChangeVersion
+	if err == nil {
+		err = tx.Where("scope = ?", scope).First(&row).Error
+	}
+	if err == nil {
+		err = tx.Commit().Error
+	} else {
+		tx.Rollback()
+	}
+	if err != nil {
+		log.Println("failed to publish change of", scope+":", err)
+		return
+	}
+	// our own change needs no reload, unless another instance has changed something in the meantime
+	if row.Version == changeVersions[scope]+1 {
+		changeVersions[scope] = row.Version
+	}
+}
+
+// PollChanges checks the change versions and runs the handlers of scopes that have been changed by another instance
+func PollChanges() {
+	var rows []model.ChangeVersion
+	if err := DB.Find(&rows).Error; err != nil {
+		log.Println("failed to poll changes:", err)
+		return
+	}
+	var handlers []func()
+	changeLock.Lock()
+	for _, row := range rows {
+		if changeVersions[row.Scope] != row.Version {
+			log.Println(row.Scope, "has been changed by another instance")
+			changeVersions[row.Scope] = row.Version
+			handlers = append(handlers, changeHandlers[row.Scope]...)
+		}
+	}
+	changeLock.Unlock()
+	for _, fn := range handlers {
+		fn()
+	}
+}
+
+// remember the current versions without running any handler, the caches are empty anyway
+func loadChangeVersions() error {
+	var rows []model.ChangeVersion
+	if err := DB.Find(&rows).Error; err != nil {
+		return err
+	}
+	changeLock.Lock()
+	defer changeLock.Unlock()
+	for _, row := range rows {
+		changeVersions[row.Scope] = row.Version
+	}
+	return nil
+}
+
+// reload every config value from the database after another instance has changed it
+func reloadConfig() {
+	if err := loadConfigCache(); err != nil {
+		log.Println("failed to reload config:", err)
+		return
+	}
+	ClearSecretToken()
+}
+
+func init() {
+	OnChange(ScopeConfig, reloadConfig)
+}
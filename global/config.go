@@ -33,6 +33,11 @@ func GetConfig(key string) (string, error) {
 	}
 }
 
+// short token derived from a secret
+func deriveToken(secret string) string {
+	return string([]rune(base64.URLEncoding.EncodeToString(strongKey(secret)))[1:10])
+}
+
 var strongSecret string = ""
 var strongLiveSecret string = ""
 
@@ -42,8 +47,7 @@ func GetSecretToken() string {
 		if secret == "" {
 			return ""
 		}
-		derived := strongKey(secret)
-		strongSecret = string([]rune(base64.URLEncoding.EncodeToString(derived))[1:10])
+		strongSecret = deriveToken(secret)
 	}
 	return strongSecret
 }
@@ -54,8 +58,7 @@ func GetLiveToken() string {
 		if secret == "" {
 			return ""
 		}
-		derived := strongKey(secret + "_live")
-		strongLiveSecret = string([]rune(base64.URLEncoding.EncodeToString(derived))[1:10])
+		strongLiveSecret = deriveToken(secret + "_live")
 	}
 	return strongLiveSecret
 }
@@ -63,6 +66,8 @@ func GetLiveToken() string {
 func ClearSecretToken() {
 	strongSecret = ""
 	strongLiveSecret = ""
+	clearSignKey()
+	clearRetiredSecret()
 	ChannelCache.Clear()
 }
 
@@ -71,6 +76,7 @@ func SetConfig(key, value string) error {
 	err := DB.Save(&data).Error
 	if err == nil {
 		ConfigCache.Store(key, value)
+		NotifyChange(ScopeConfig)
 	}
 	return err
 }
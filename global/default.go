@@ -11,13 +11,23 @@ import (
 )
 
 var defaultConfigValue = map[string]string{
-	"ytdl_cmd":  "yt-dlp",
-	"ytdl_args": "--extractor-args youtube:skip=dash -f b -g {url}",
-	"base_url":  "http://127.0.0.1:9000",
-	"password":  "password",
-	"apiKey":    "",
+	"ytdl_cmd":            "yt-dlp",
+	"ytdl_args":           "--extractor-args youtube:skip=dash -f b -g {url}",
+	"base_url":            "http://127.0.0.1:9000",
+	"apiKey":              "",
+	"backup_interval":     "0",
+	"backup_keep":         "7",
+	"link_lifetime":       "0",
+	"link_legacy":         "true",
+	"secret_grace":        "0",
+	"oidc_scopes":         "openid profile email",
+	"oidc_groups_claim":   "groups",
+	"dest_trust_channels": "true",
 }
 
+// config keys that hold credentials, they can be left out of backups
+var SecretConfigKeys = []string{"password", "secret", "apiKey", "metrics_token", "secret_prev", "session_key", "oidc_client_secret"}
+
 var (
 	HttpClientTimeout = 10 * time.Second
 	CookieJar, _      = cookiejar.New(nil)
@@ -0,0 +1,378 @@
+package global
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/snowie2000/livetv/metrics"
+	"github.com/snowie2000/livetv/model"
+	"golang.org/x/net/proxy"
+)
+
+// Destination policy of the requests made on behalf of clients (fetch, proxy and ts proxy), so a leaked token
+// can't be used to reach the host itself, cloud metadata services or the local network.
+// Internal addresses are refused unless allowed by dest_allow or they serve a channel an admin configured, dest_deny
+// refuses anything else. The host itself and cloud metadata services are only reached when dest_allow lists them. Both are comma separated lists of networks, addresses and host names, *.example.com
+// matching the subdomains of example.com. Addresses are checked when connecting, after they have been resolved,
+// so a name can't resolve to a public address when checked and to an internal one when used.
+
+var ErrDestinationBlocked = errors.New("destination not allowed")
+
+var blockedDestinations = metrics.NewCounter("livetv_blocked_destinations_total",
+	"Requests made on behalf of clients that were refused by the destination policy, by reason.", "reason")
+
+// networks that aren't reachable from the internet, on top of loopback, private and link-local ones
+var internalNets = parseNets("0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "240.0.0.0/4", "64:ff9b::/96")
+
+// "this network", which reaches the host itself on linux, and metadata services outside the link-local
+// networks, of aws over ipv6 and of alibaba cloud
+var hostOnlyNets = parseNets("0.0.0.0/8", "fd00:ec2::254/128", "100.100.100.200/32")
+
+// how far a host is trusted
+const (
+	trustNone    = iota
+	trustChannel // serves a configured channel, may be on the local network
+	trustAllowed // listed in dest_allow, may be anything
+)
+
+type destinationPolicy struct {
+	allow, deny           string // the config values the policy was parsed from
+	allowNets, denyNets   []*net.IPNet
+	allowHosts, denyHosts []string
+	trustChannels         bool
+}
+
+var (
+	policyLock    sync.Mutex
+	currentPolicy *destinationPolicy
+
+	serversLock    sync.Mutex
+	channelServers map[string]bool // nil when they need to be loaded
+)
+
+func parseNets(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		if _, n, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+// split a list into networks and host names, single addresses become networks of their own
+func parseDestinations(list string) (nets []*net.IPNet, hosts []string) {
+	for _, item := range strings.Split(list, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		if _, n, err := net.ParseCIDR(item); err == nil {
+			nets = append(nets, n)
+		} else if ip := net.ParseIP(strings.Trim(item, "[]")); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		} else {
+			hosts = append(hosts, strings.TrimPrefix(item, "*"))
+		}
+	}
+	return
+}
+
+// CheckDestinationList tells whether every entry of a dest_allow or dest_deny list makes sense
+func CheckDestinationList(list string) error {
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if strings.Contains(item, "/") {
+			if _, _, err := net.ParseCIDR(item); err != nil {
+				return fmt.Errorf("%q isn't a network", item)
+			}
+		} else if strings.ContainsAny(item, " :?#@") && net.ParseIP(strings.Trim(item, "[]")) == nil {
+			return fmt.Errorf("%q isn't an address or a host name", item)
+		}
+	}
+	return nil
+}
+
+// the policy of the current config, parsed again when it has changed
+func destinations() *destinationPolicy {
+	allow, _ := GetConfig("dest_allow")
+	deny, _ := GetConfig("dest_deny")
+	trust, _ := GetConfig("dest_trust_channels")
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	p := currentPolicy
+	if p == nil || p.allow != allow || p.deny != deny || p.trustChannels != (trust != "false") {
+		p = &destinationPolicy{allow: allow, deny: deny, trustChannels: trust != "false"}
+		p.allowNets, p.allowHosts = parseDestinations(allow)
+		p.denyNets, p.denyHosts = parseDestinations(deny)
+		currentPolicy = p
+	}
+	return p
+}
+
+func hostMatches(host string, patterns []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range patterns {
+		if host == pattern || (strings.HasPrefix(pattern, ".") && strings.HasSuffix(host, pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+func netsContain(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// whether an address is one that only the host itself or its local network should reach
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || netsContain(internalNets, ip)
+}
+
+// the port of a url, the default one of its scheme when it has none
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch u.Scheme {
+	case "http", "ws":
+		return "80"
+	case "https", "wss":
+		return "443"
+	}
+	return ""
+}
+
+// whether an address is the host itself or a metadata service, which a channel alone can't make trusted
+func isHostOnlyIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsUnspecified() || netsContain(hostOnlyNets, ip)
+}
+
+// the servers of the channels an admin configured as host:port, host:* when the scheme has no default port.
+// Sub channels come from provider playlists, like the urls channels resolve to, and don't count.
+func channelServerSet(channels []model.Channel) map[string]bool {
+	servers := make(map[string]bool)
+	for _, ch := range channels {
+		if ch.ParentID != ch.ChannelID {
+			continue
+		}
+		for _, rawURL := range []string{ch.URL, ch.TsProxy, ch.ProxyUrl} {
+			u, err := url.Parse(rawURL)
+			if rawURL == "" || err != nil || u.Hostname() == "" {
+				continue
+			}
+			port := urlPort(u)
+			if port == "" {
+				port = "*"
+			}
+			servers[strings.ToLower(u.Hostname())+":"+port] = true
+		}
+	}
+	return servers
+}
+
+// InvalidateChannelServers makes the servers of the channels be read again, after channels have been changed
+func InvalidateChannelServers() {
+	serversLock.Lock()
+	channelServers = nil
+	serversLock.Unlock()
+}
+
+// whether a server is the source or the proxy of a channel an admin configured
+func isChannelServer(host string, port string) bool {
+	serversLock.Lock()
+	defer serversLock.Unlock()
+	if channelServers == nil {
+		var channels []model.Channel
+		if err := DB.Find(&channels).Error; err != nil {
+			log.Println("failed to load the channel servers:", err)
+			return false
+		}
+		// channels in the database are top level ones, named the way loadChannel names them
+		for i := range channels {
+			channels[i].ChannelID = strconv.Itoa(channels[i].ID)
+			channels[i].ParentID = channels[i].ChannelID
+		}
+		channelServers = channelServerSet(channels)
+	}
+	host = strings.ToLower(host)
+	return channelServers[host+":"+port] || channelServers[host+":*"]
+}
+
+func blockDestination(host string, ip net.IP, reason string) error {
+	blockedDestinations.With(reason).Inc()
+	target := host
+	if ip != nil && ip.String() != host {
+		target += " (" + ip.String() + ")"
+	}
+	log.Printf("blocked request to %s: %s\n", target, reason)
+	return fmt.Errorf("%w: %s is %s", ErrDestinationBlocked, target, reason)
+}
+
+// check the host rules, trust tells which addresses the host may resolve to
+func (p *destinationPolicy) checkHost(host string, port string) (trust int, err error) {
+	switch {
+	case hostMatches(host, p.denyHosts):
+		return trustNone, blockDestination(host, nil, "denied")
+	case hostMatches(host, p.allowHosts):
+		return trustAllowed, nil
+	case p.trustChannels && isChannelServer(host, port):
+		return trustChannel, nil
+	}
+	return trustNone, nil
+}
+
+func (p *destinationPolicy) checkIP(host string, ip net.IP, trust int) error {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4 // ipv4 mapped ipv6 addresses are checked as what they are
+	}
+	switch {
+	case netsContain(p.denyNets, ip):
+		return blockDestination(host, ip, "denied")
+	case netsContain(p.allowNets, ip), trust == trustAllowed:
+		return nil
+	case isHostOnlyIP(ip):
+		return blockDestination(host, ip, "local")
+	case trust == trustChannel:
+		return nil
+	case isInternalIP(ip):
+		return blockDestination(host, ip, "internal")
+	}
+	return nil
+}
+
+// resolve a host and return the addresses the policy allows, an error when there are none
+func (p *destinationPolicy) resolve(ctx context.Context, host string, port string) ([]net.IP, error) {
+	trust, err := p.checkHost(host, port)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	var allowed []net.IP
+	for _, ip := range ips {
+		if err = p.checkIP(host, ip, trust); err == nil {
+			allowed = append(allowed, ip)
+		}
+	}
+	if len(allowed) == 0 {
+		if err == nil {
+			err = fmt.Errorf("%s has no address", host)
+		}
+		return nil, err
+	}
+	return allowed, nil
+}
+
+// CheckDestination checks the host of a url against the policy, for requests whose connections
+// aren't made by us, like those through a proxy
+func CheckDestination(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	_, err = destinations().resolve(ctx, u.Hostname(), urlPort(u))
+	return err
+}
+
+// GuardedDialContext connects to the addresses of a host the destination policy allows, the ones that
+// have been checked, so that a second lookup can't return something else
+func GuardedDialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := destinations().resolve(ctx, strings.Trim(host, "[]"), port)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+type guardedDialer struct{}
+
+func (guardedDialer) Dial(network string, addr string) (net.Conn, error) {
+	return GuardedDialContext(context.Background(), network, addr)
+}
+
+func (guardedDialer) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	return GuardedDialContext(ctx, network, addr)
+}
+
+// shares its connections between requests, like DefaultTransport
+var guardedTransport = func() *http.Transport {
+	tr := DefaultTransport.Clone()
+	tr.Dial = nil
+	tr.DialContext = GuardedDialContext
+	return tr
+}()
+
+// checks the target of requests sent through a proxy, which resolves it itself
+type proxiedGuard struct {
+	next http.RoundTripper
+}
+
+func (t proxiedGuard) RoundTrip(req *http.Request) (*http.Response, error) {
+	if _, err := destinations().resolve(req.Context(), req.URL.Hostname(), urlPort(req.URL)); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
+
+// GuardedTransport is UpstreamTransport for requests made on behalf of clients, their destinations
+// are checked against the destination policy. So is the proxy, if any.
+func GuardedTransport(proxyUrl string) http.RoundTripper {
+	if proxyUrl == "" {
+		return upstreamTransport{guardedTransport}
+	}
+	tr := guardedTransport.Clone()
+	tr.DisableKeepAlives = true
+	if u, err := url.Parse(proxyUrl); err == nil {
+		if p, e := proxy.FromURL(u, guardedDialer{}); e == nil {
+			tr.DialContext = nil
+			tr.Dial = p.Dial
+		} else {
+			log.Println("Proxy setup error:", e)
+		}
+	}
+	return upstreamTransport{proxiedGuard{tr}}
+}
+
+func init() {
+	OnChange(ScopeChannels, InvalidateChannelServers)
+}
@@ -0,0 +1,222 @@
+package global
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/snowie2000/livetv/model"
+)
+
+func testPolicy(allow, deny string) *destinationPolicy {
+	p := &destinationPolicy{allow: allow, deny: deny, trustChannels: true}
+	p.allowNets, p.allowHosts = parseDestinations(allow)
+	p.denyNets, p.denyHosts = parseDestinations(deny)
+	return p
+}
+
+func TestHostMatches(t *testing.T) {
+	_, patterns := parseDestinations("cdn.example.com, *.tv.example.org, LOCALHOST")
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"cdn.example.com", true},
+		{"CDN.Example.com", true},
+		{"cdn.example.com.", true},
+		{"localhost", true},
+		{"a.tv.example.org", true},
+		{"a.b.tv.example.org", true},
+		{"tv.example.org", false}, // *. only matches subdomains
+		{"xtv.example.org", false},
+		{"example.com", false},
+		{"a.cdn.example.com", false},
+		{"cdn.example.com.evil.net", false},
+		{"evilcdn.example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := hostMatches(tt.host, patterns); got != tt.want {
+			t.Errorf("hostMatches(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestParseDestinations(t *testing.T) {
+	nets, hosts := parseDestinations(" 10.1.0.0/16 , 192.0.2.7, [2001:db8::1], *.Example.com,, cdn.test ")
+	if len(nets) != 3 || len(hosts) != 2 {
+		t.Fatalf("parseDestinations = %v, %v", nets, hosts)
+	}
+	for _, tt := range []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.200.3", true},
+		{"10.2.0.1", false},
+		{"192.0.2.7", true},
+		{"192.0.2.8", false},
+		{"::ffff:192.0.2.7", true},
+		{"2001:db8::1", true},
+		{"2001:db8::2", false},
+	} {
+		if got := netsContain(nets, net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("%s in %v = %v, want %v", tt.ip, nets, got, tt.want)
+		}
+	}
+	if hosts[0] != ".example.com" || hosts[1] != "cdn.test" {
+		t.Errorf("hosts = %v", hosts)
+	}
+}
+
+func TestCheckIP(t *testing.T) {
+	open := testPolicy("", "")
+	allowing := testPolicy("127.0.0.1, 10.9.0.0/16, 169.254.169.254", "")
+	denying := testPolicy("10.0.0.0/8", "10.9.9.9, 203.0.113.0/24")
+	tests := []struct {
+		name   string
+		policy *destinationPolicy
+		ip     string
+		trust  int
+		reason string // empty when the address is allowed
+	}{
+		{"public", open, "93.184.216.34", trustNone, ""},
+		{"public ipv6", open, "2606:2800:220:1::1", trustNone, ""},
+		{"loopback", open, "127.0.0.1", trustNone, "local"},
+		{"loopback range", open, "127.8.8.8", trustNone, "local"},
+		{"ipv6 loopback", open, "::1", trustNone, "local"},
+		{"unspecified", open, "0.0.0.0", trustNone, "local"},
+		{"this network", open, "0.1.2.3", trustNone, "local"},
+		{"metadata", open, "169.254.169.254", trustNone, "local"},
+		{"aws ipv6 metadata", open, "fd00:ec2::254", trustNone, "local"},
+		{"alibaba metadata", open, "100.100.100.200", trustNone, "local"},
+		{"mapped loopback", open, "::ffff:127.0.0.1", trustNone, "local"},
+		{"mapped metadata", open, "::ffff:169.254.169.254", trustNone, "local"},
+		{"ipv6 link-local", open, "fe80::1", trustNone, "local"},
+		{"private", open, "192.168.1.10", trustNone, "internal"},
+		{"private 172", open, "172.17.0.2", trustNone, "internal"},
+		{"ula", open, "fd12:3456::1", trustNone, "internal"},
+		{"cgnat", open, "100.64.1.1", trustNone, "internal"},
+		{"multicast", open, "239.1.1.1", trustNone, "internal"},
+		{"mapped private", open, "::ffff:10.0.0.1", trustNone, "internal"},
+
+		{"private channel server", open, "192.168.1.10", trustChannel, ""},
+		{"loopback channel server", open, "127.0.0.1", trustChannel, "local"},
+		{"metadata channel server", open, "169.254.169.254", trustChannel, "local"},
+
+		{"allowed loopback", allowing, "127.0.0.1", trustNone, ""},
+		{"allowed network", allowing, "10.9.3.4", trustNone, ""},
+		{"outside allowed network", allowing, "10.8.3.4", trustNone, "internal"},
+		{"allowed metadata", allowing, "169.254.169.254", trustNone, ""},
+		{"other loopback", allowing, "127.0.0.2", trustNone, "local"},
+		{"allowed host", open, "127.0.0.1", trustAllowed, ""},
+
+		{"denied in allowed network", denying, "10.9.9.9", trustNone, "denied"},
+		{"denied public", denying, "203.0.113.5", trustNone, "denied"},
+		{"denied allowed host", denying, "203.0.113.5", trustAllowed, "denied"},
+		{"denied channel server", denying, "203.0.113.5", trustChannel, "denied"},
+		{"mapped denied", denying, "::ffff:203.0.113.5", trustNone, "denied"},
+		{"not denied", denying, "10.9.9.8", trustNone, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.checkIP("host", net.ParseIP(tt.ip), tt.trust)
+			if tt.reason == "" {
+				if err != nil {
+					t.Errorf("checkIP(%s) = %v, want allowed", tt.ip, err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrDestinationBlocked) {
+				t.Fatalf("checkIP(%s) = %v, want blocked as %s", tt.ip, err, tt.reason)
+			}
+			if want := "is " + tt.reason; !strings.HasSuffix(err.Error(), want) {
+				t.Errorf("checkIP(%s) = %v, want blocked as %s", tt.ip, err, tt.reason)
+			}
+		})
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	// top level channels have their own id as parent, the way loadChannel sets them up
+	channels := []model.Channel{
+		{ChannelID: "901", ParentID: "901", URL: "http://192.168.1.20:8080/live.m3u8", ProxyUrl: "socks5://10.0.0.3:1080"},
+		{ChannelID: "902", ParentID: "902", URL: "https://tv.lan/list.m3u", TsProxy: "http://relay.lan/"},
+		{ChannelID: "903", ParentID: "903", URL: "http://198.51.100.9/live.m3u8", ProxyUrl: "socks5://proxy.lan"},
+		{ChannelID: "901-1", ParentID: "901", URL: "http://192.168.1.99/entry.ts"},
+		{ChannelID: "902-1", ParentID: "902", URL: "http://169.254.169.254/latest/meta-data"},
+	}
+	serversLock.Lock()
+	channelServers = channelServerSet(channels)
+	serversLock.Unlock()
+	t.Cleanup(InvalidateChannelServers)
+
+	p := testPolicy("*.allowed.test", "bad.allowed.test")
+	untrusting := testPolicy("", "")
+	untrusting.trustChannels = false
+	tests := []struct {
+		name   string
+		policy *destinationPolicy
+		host   string
+		port   string
+		trust  int
+		denied bool
+	}{
+		{"channel source", p, "192.168.1.20", "8080", trustChannel, false},
+		{"channel source other port", p, "192.168.1.20", "80", trustNone, false},
+		{"channel proxy", p, "10.0.0.3", "1080", trustChannel, false},
+		{"channel by name", p, "TV.lan", "443", trustChannel, false},
+		{"channel by name other scheme", p, "tv.lan", "80", trustNone, false},
+		{"ts proxy", p, "relay.lan", "80", trustChannel, false},
+		{"proxy without default port", p, "proxy.lan", "1080", trustChannel, false},
+		{"sub channel entry", p, "192.168.1.99", "80", trustNone, false},
+		{"sub channel metadata", p, "169.254.169.254", "80", trustNone, false},
+		{"unknown host", p, "example.com", "80", trustNone, false},
+		{"allowed host", p, "cdn.allowed.test", "80", trustAllowed, false},
+		{"denied host", p, "bad.allowed.test", "80", trustNone, true},
+		{"channels not trusted", untrusting, "192.168.1.20", "8080", trustNone, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trust, err := tt.policy.checkHost(tt.host, tt.port)
+			if trust != tt.trust || (err != nil) != tt.denied {
+				t.Errorf("checkHost(%s, %s) = %d, %v, want %d, denied = %v", tt.host, tt.port, trust, err, tt.trust, tt.denied)
+			}
+		})
+	}
+}
+
+func TestCheckDestination(t *testing.T) {
+	settings := map[string]string{"dest_allow": "127.0.0.1", "dest_deny": "198.51.100.0/24", "dest_trust_channels": ""}
+	for k, v := range settings {
+		ConfigCache.Store(k, v)
+	}
+	// no channels are configured
+	serversLock.Lock()
+	channelServers = map[string]bool{}
+	serversLock.Unlock()
+	t.Cleanup(func() {
+		for k := range settings {
+			ConfigCache.Delete(k)
+		}
+		InvalidateChannelServers()
+	})
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"http://93.184.216.34/live.m3u8", true},
+		{"http://127.0.0.1:9000/", true},
+		{"http://[::1]:9000/", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://[::ffff:169.254.169.254]/", false},
+		{"http://10.0.0.1/", false},
+		{"http://198.51.100.20/", false},
+	}
+	for _, tt := range tests {
+		if err := CheckDestination(context.Background(), tt.url); (err == nil) != tt.allowed {
+			t.Errorf("CheckDestination(%s) = %v, want allowed = %v", tt.url, err, tt.allowed)
+		}
+	}
+}
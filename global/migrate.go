@@ -0,0 +1,168 @@
+package global
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/snowie2000/livetv/model"
+)
+
+// Migration is a numbered schema change. Migrations are applied in order, each inside its own transaction
+// (mysql commits schema changes implicitly), and must be safe to run again on data restored from an older backup.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+}
+
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary, please upgrade LiveTV")
+
+var migrations = []Migration{
+	{1, "initial schema", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&model.Config{}, &model.Channel{}).Error
+	}},
+	{2, "rename legacy parsers", func(tx *gorm.DB) error {
+		// update old parsers to their new names
+		return tx.Model(&model.Channel{}).Where("parser IN (?)", []string{"httpRedirect", "direct"}).Update("parser", "http").Error
+	}},
+	{3, "stable sub channel numbers", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&model.SubChannel{}).Error
+	}},
+	{4, "sub channel overrides", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&model.ChannelOverride{}).Error
+	}},
+	{5, "playlist filter rules", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&model.FilterRule{}).Error
+	}},
+	{6, "change versions for shared databases", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&model.ChangeVersion{}).Error
+	}},
+	{7, "api keys", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&model.APIKey{}).Error
+	}},
+	{8, "status history", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&model.StatusEvent{}).Error
+	}},
+	{9, "webhooks", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&model.Webhook{}).Error
+	}},
+	{10, "subscribers", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&model.Subscriber{}).Error
+	}},
+	{11, "admin users", func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&model.User{}).Error; err != nil {
+			return err
+		}
+		return seedOwner(tx)
+	}},
+	{12, "audit log", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&model.AuditEntry{}).Error
+	}},
+	{13, "single sign-on users", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&model.User{}).Error
+	}},
+	{14, "playback access policies", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&model.AccessPolicy{}).Error
+	}},
+	{15, "api key roles", func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&model.APIKey{}).Error; err != nil {
+			return err
+		}
+		// keys of older versions could do everything, they keep doing so until they are replaced
+		return tx.Model(&model.APIKey{}).Where("role = ? OR role IS NULL", "").UpdateColumn("role", model.RoleOwner).Error
+	}},
+	{16, "unique sub channel numbers", func(tx *gorm.DB) error {
+		// numbers handed out twice by instances racing each other, the identity seen first keeps its number
+		// and the others get a new one on the next parse
+		err := tx.Exec("DELETE FROM sub_channels WHERE id NOT IN " +
+			"(SELECT id FROM (SELECT MIN(id) AS id FROM sub_channels GROUP BY parent_id, number) AS kept)").Error
+		if err != nil {
+			return err
+		}
+		return tx.AutoMigrate(&model.SubChannel{}).Error
+	}},
+}
+
+// LatestSchemaVersion is the schema version this binary expects
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version the database is on, 0 for a database without any recorded migration
+func SchemaVersion() (int, error) {
+	if !DB.HasTable(&model.SchemaVersion{}) {
+		return 0, nil
+	}
+	var v model.SchemaVersion
+	err := DB.Order("version desc").First(&v).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return 0, nil
+	}
+	return v.Version, err
+}
+
+// MigrationStatus lists all known migrations and whether they have been applied
+func MigrationStatus() ([]MigrationState, error) {
+	applied := make(map[int]model.SchemaVersion)
+	if DB.HasTable(&model.SchemaVersion{}) {
+		var rows []model.SchemaVersion
+		if err := DB.Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			applied[row.Version] = row
+		}
+	}
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		row, ok := applied[m.Version]
+		states = append(states, MigrationState{m, ok, row.AppliedAt})
+	}
+	return states, nil
+}
+
+// ResetSchemaVersion forgets migrations newer than version so that they run again, e.g. on data restored from an older backup
+func ResetSchemaVersion(version int) error {
+	return DB.Delete(model.SchemaVersion{}, "version > ?", version).Error
+}
+
+// MigrateDB brings the database schema and data up to date
+func MigrateDB() error {
+	if err := DB.AutoMigrate(&model.SchemaVersion{}).Error; err != nil {
+		return err
+	}
+	current, err := SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("%w (database: %d, binary: %d)", ErrSchemaTooNew, current, LatestSchemaVersion())
+	}
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		tx := DB.Begin()
+		if err = m.Up(tx); err == nil {
+			err = tx.Create(&model.SchemaVersion{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if err = tx.Commit().Error; err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		log.Printf("Database migrated to version %d: %s\n", m.Version, m.Name)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package global
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/snowie2000/livetv/model"
+	"golang.org/x/crypto/scrypt"
+)
+
+// scrypt parameters of new password hashes, stored hashes carry their own so they can be raised later
+const (
+	passwordN      = 32768
+	passwordR      = 8
+	passwordP      = 1
+	passwordKeyLen = 32
+)
+
+var ErrBadPasswordHash = errors.New("Malformed password hash")
+
+// HashPassword hashes a password as scrypt$N$r$p$salt$key
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), salt, passwordN, passwordR, passwordP, passwordKeyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("scrypt$%d$%d$%d$%s$%s", passwordN, passwordR, passwordP,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func isPasswordHash(value string) bool {
+	return strings.HasPrefix(value, "scrypt$")
+}
+
+func verifyPasswordHash(password string, hash string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, ErrBadPasswordHash
+	}
+	params := make([]int, 3)
+	for i := range params {
+		value, err := strconv.Atoi(parts[i+1])
+		if err != nil {
+			return false, ErrBadPasswordHash
+		}
+		params[i] = value
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrBadPasswordHash
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, ErrBadPasswordHash
+	}
+	key, err := scrypt.Key([]byte(password), salt, params[0], params[1], params[2], len(want))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, want) == 1, nil
+}
+
+// VerifyPassword checks a password against its stored hash
+func VerifyPassword(password string, hash string) (bool, error) {
+	if !isPasswordHash(hash) {
+		return false, ErrBadPasswordHash
+	}
+	return verifyPasswordHash(password, hash)
+}
+
+// DefaultOwner is the account the single admin password of older versions becomes
+const DefaultOwner = "admin"
+
+// config keys of the single admin account of older versions
+var legacyAccountKeys = []string{"password", "totp_secret", "totp_pending", "totp_last", "totp_recovery"}
+
+// the admin password and second factor of older versions become the first owner
+func seedOwner(tx *gorm.DB) error {
+	var count int
+	if err := tx.Model(&model.User{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		var rows []model.Config
+		if err := tx.Where("name IN (?)", legacyAccountKeys).Find(&rows).Error; err != nil {
+			return err
+		}
+		legacy := map[string]string{"password": "password"}
+		for _, row := range rows {
+			legacy[row.Name] = row.Data
+		}
+		password := legacy["password"]
+		if !isPasswordHash(password) {
+			hash, err := HashPassword(password)
+			if err != nil {
+				return err
+			}
+			password = hash
+		}
+		last, _ := strconv.ParseInt(legacy["totp_last"], 10, 64)
+		owner := model.User{
+			Name:          DefaultOwner,
+			Password:      password,
+			Role:          model.RoleOwner,
+			TOTPSecret:    legacy["totp_secret"],
+			TOTPLast:      last,
+			RecoveryCodes: legacy["totp_recovery"],
+			Created:       time.Now(),
+		}
+		if err := tx.Create(&owner).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Where("name IN (?)", legacyAccountKeys).Delete(model.Config{}).Error
+}
+
+// SessionKey is the key of the login cookies, it is generated once and doesn't change with the password
+func SessionKey() ([]byte, error) {
+	if key, err := GetConfig("session_key"); err == nil && key != "" {
+		return []byte(key), nil
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	// another instance may have created it in the meantime
+	row := model.Config{Name: "session_key"}
+	if err := DB.Where(row).Attrs(model.Config{Data: hex.EncodeToString(buf)}).FirstOrCreate(&row).Error; err != nil {
+		return nil, err
+	}
+	ConfigCache.Store(row.Name, row.Data)
+	return []byte(row.Data), nil
+}
@@ -0,0 +1,85 @@
+package global
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// a hash made with the given parameters, like those stored by older versions
+func testPasswordHash(t *testing.T, password string, n, r, p int) string {
+	t.Helper()
+	salt := []byte("0123456789abcdef")
+	key, err := scrypt.Key([]byte(password), salt, n, r, p, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("scrypt$%d$%d$%d$%s$%s", n, r, p,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, fmt.Sprintf("scrypt$%d$%d$%d$", passwordN, passwordR, passwordP)) {
+		t.Errorf("hash %q doesn't carry its parameters", hash)
+	}
+	if again, _ := HashPassword("correct horse"); again == hash {
+		t.Error("two hashes of a password are the same, the salt isn't random")
+	}
+	for _, tt := range []struct {
+		password string
+		want     bool
+	}{
+		{"correct horse", true},
+		{"correct horse ", false},
+		{"Correct horse", false},
+		{"", false},
+	} {
+		ok, err := VerifyPassword(tt.password, hash)
+		if err != nil || ok != tt.want {
+			t.Errorf("VerifyPassword(%q) = %v, %v, want %v", tt.password, ok, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	weak := testPasswordHash(t, "secret", 1024, 8, 1)
+	parts := strings.Split(weak, "$")
+	tests := []struct {
+		name     string
+		password string
+		hash     string
+		want     bool
+		err      error
+	}{
+		{"own parameters", "secret", weak, true, nil},
+		{"wrong password", "Secret", weak, false, nil},
+		{"plain text", "secret", "secret", false, ErrBadPasswordHash},
+		{"empty", "", "", false, ErrBadPasswordHash},
+		{"missing key", "secret", strings.Join(parts[:5], "$"), false, ErrBadPasswordHash},
+		{"extra field", "secret", weak + "$x", false, ErrBadPasswordHash},
+		{"bad cost", "secret", strings.Replace(weak, "$1024$", "$many$", 1), false, ErrBadPasswordHash},
+		{"bad salt", "secret", strings.Join([]string{parts[0], parts[1], parts[2], parts[3], "!!", parts[5]}, "$"), false, ErrBadPasswordHash},
+		{"bad key", "secret", strings.Join([]string{parts[0], parts[1], parts[2], parts[3], parts[4], "!!"}, "$"), false, ErrBadPasswordHash},
+		{"other salt", "secret", strings.Join([]string{parts[0], parts[1], parts[2], parts[3], "AAAAAAAAAAAAAAAAAAAAAA", parts[5]}, "$"), false, nil},
+		{"cost not a power of two", "secret", strings.Replace(weak, "$1024$", "$1000$", 1), false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := VerifyPassword(tt.password, tt.hash)
+			if ok != tt.want {
+				t.Errorf("VerifyPassword = %v, want %v", ok, tt.want)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("error %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package global
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tokens of the secret that was replaced by a rotation, they keep working until the grace window ends
+type retiredSecret struct {
+	token     string
+	liveToken string
+	signKey   []byte
+	until     time.Time
+}
+
+// RotationStatus tells whether tokens of the previous secret are still accepted and how often they are used
+type RotationStatus struct {
+	Rotating         bool       `json:"rotating"`
+	GraceUntil       *time.Time `json:"graceuntil,omitempty"`
+	OldTokenRequests int64      `json:"oldtokenrequests"`
+	LastOldToken     *time.Time `json:"lastoldtoken,omitempty"`
+}
+
+var (
+	retiredLock      sync.Mutex
+	retired          *retiredSecret
+	retiredLoaded    bool
+	oldTokenRequests atomic.Int64
+	lastOldToken     atomic.Int64 // unix time
+)
+
+// the previous secret while its grace window lasts, nil otherwise
+func retiredSecretNow() *retiredSecret {
+	retiredLock.Lock()
+	defer retiredLock.Unlock()
+	if !retiredLoaded {
+		retired = nil
+		retiredLoaded = true
+		secret, _ := GetConfig("secret_prev")
+		until, _ := GetConfig("secret_prev_until")
+		expiry, err := strconv.ParseInt(until, 10, 64)
+		if secret != "" && err == nil {
+			retired = &retiredSecret{
+				token:     deriveToken(secret),
+				liveToken: deriveToken(secret + "_live"),
+				signKey:   strongKey(secret + "_sign"),
+				until:     time.Unix(expiry, 0),
+			}
+		}
+	}
+	if retired == nil || time.Now().After(retired.until) {
+		return nil
+	}
+	return retired
+}
+
+func clearRetiredSecret() {
+	retiredLock.Lock()
+	retired = nil
+	retiredLoaded = false
+	retiredLock.Unlock()
+}
+
+// CountOldToken records a request let in with a token of the previous secret
+func CountOldToken() {
+	oldTokenRequests.Add(1)
+	lastOldToken.Store(time.Now().Unix())
+}
+
+// SecretGrace is how long tokens of the previous secret keep working after the secret is changed
+func SecretGrace() time.Duration {
+	value, _ := GetConfig("secret_grace")
+	hours, _ := strconv.Atoi(value)
+	if hours <= 0 {
+		return 0
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// SetSecret changes the secret. The previous secret is kept for the grace window, without grace every old token stops working at once.
+func SetSecret(secret string) error {
+	current, _ := GetConfig("secret")
+	if secret == current {
+		return nil
+	}
+	prev, until := "", ""
+	if grace := SecretGrace(); grace > 0 && current != "" {
+		prev, until = current, strconv.FormatInt(time.Now().Add(grace).Unix(), 10)
+	}
+	if err := SetConfig("secret_prev", prev); err != nil {
+		return err
+	}
+	if err := SetConfig("secret_prev_until", until); err != nil {
+		return err
+	}
+	if err := SetConfig("secret", secret); err != nil {
+		return err
+	}
+	oldTokenRequests.Store(0)
+	lastOldToken.Store(0)
+	ClearSecretToken()
+	return nil
+}
+
+// EndRotation stops accepting tokens of the previous secret before the grace window ends
+func EndRotation() error {
+	if err := SetConfig("secret_prev", ""); err != nil {
+		return err
+	}
+	if err := SetConfig("secret_prev_until", ""); err != nil {
+		return err
+	}
+	ClearSecretToken()
+	return nil
+}
+
+// SecretRotation reports the state of the last secret rotation
+func SecretRotation() RotationStatus {
+	status := RotationStatus{OldTokenRequests: oldTokenRequests.Load()}
+	if prev := retiredSecretNow(); prev != nil {
+		status.Rotating = true
+		until := prev.until
+		status.GraceUntil = &until
+	}
+	if last := lastOldToken.Load(); last != 0 {
+		t := time.Unix(last, 0)
+		status.LastOldToken = &t
+	}
+	return status
+}
+
+// CheckSecretToken tells whether token is the playlist token of the secret, or of the previous secret during a rotation
+func CheckSecretToken(token string) bool {
+	if token == GetSecretToken() {
+		return true
+	}
+	if prev := retiredSecretNow(); prev != nil && token == prev.token {
+		CountOldToken()
+		return true
+	}
+	return false
+}
+
+// CheckLiveToken tells whether token is the token of proxied links, or the one of the previous secret during a rotation
+func CheckLiveToken(token string) bool {
+	if token == GetLiveToken() {
+		return true
+	}
+	if prev := retiredSecretNow(); prev != nil && token == prev.liveToken {
+		CountOldToken()
+		return true
+	}
+	return false
+}
+
+// PreviousSecretToken is the playlist token of the previous secret during a rotation, channel tokens derived from it stay valid
+func PreviousSecretToken() (string, bool) {
+	if prev := retiredSecretNow(); prev != nil {
+		return prev.token, true
+	}
+	return "", false
+}
+
+// sign key of the previous secret during a rotation
+func retiredSignKey() []byte {
+	if prev := retiredSecretNow(); prev != nil {
+		return prev.signKey
+	}
+	return nil
+}
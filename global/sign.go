@@ -0,0 +1,145 @@
+package global
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// what signed links can be bound to
+const (
+	BindNone    = ""
+	BindIP      = "ip"      // the client address
+	BindSession = "session" // the client address and user agent
+)
+
+// result of checking the signature of a link
+const (
+	LinkUnsigned = iota
+	LinkValid
+	LinkInvalid
+)
+
+// query parameters covered by the signature, others (e.g. headers added by plugins) are left alone
+var signedParams = []string{"b", "c", "exp", "k", "proxy", "token"}
+
+var (
+	signKeyLock sync.Mutex
+	signKey     []byte
+)
+
+// LinkClient is the client playback links are made for, signed links may be bound to it
+type LinkClient struct {
+	IP        string
+	UserAgent string
+}
+
+func linkSignKey() []byte {
+	signKeyLock.Lock()
+	defer signKeyLock.Unlock()
+	if signKey == nil {
+		secret, _ := GetConfig("secret")
+		if secret == "" {
+			return nil
+		}
+		signKey = strongKey(secret + "_sign")
+	}
+	return signKey
+}
+
+func clearSignKey() {
+	signKeyLock.Lock()
+	signKey = nil
+	signKeyLock.Unlock()
+}
+
+// LinkLifetime is how long signed links are valid, 0 when links aren't signed
+func LinkLifetime() time.Duration {
+	value, _ := GetConfig("link_lifetime")
+	hours, _ := strconv.Atoi(value)
+	if hours <= 0 || linkSignKey() == nil {
+		return 0
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// LinkBinding is what new signed links are bound to
+func LinkBinding() string {
+	binding, _ := GetConfig("link_binding")
+	return binding
+}
+
+// LegacyLinksAllowed tells whether links without signature are still accepted
+func LegacyLinksAllowed() bool {
+	legacy, err := GetConfig("link_legacy")
+	return err != nil || legacy != "false"
+}
+
+func linkSignature(key []byte, path string, query url.Values, client LinkClient) string {
+	var text strings.Builder
+	text.WriteString(strings.TrimPrefix(path, "/"))
+	for _, name := range signedParams {
+		text.WriteString("\n" + name + "=" + query.Get(name))
+	}
+	switch query.Get("b") {
+	case BindIP:
+		text.WriteString("\n" + client.IP)
+	case BindSession:
+		text.WriteString("\n" + client.IP + "\n" + client.UserAgent)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(text.String()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:22]
+}
+
+// SignLink adds an expiry and a signature to the query of a playback link to path when signed links are enabled
+func SignLink(path string, query url.Values, client LinkClient) {
+	lifetime := LinkLifetime()
+	if lifetime == 0 {
+		return
+	}
+	query.Set("exp", strconv.FormatInt(time.Now().Add(lifetime).Unix(), 10))
+	if binding := LinkBinding(); binding != BindNone {
+		query.Set("b", binding)
+	}
+	query.Set("sig", linkSignature(linkSignKey(), path, query, client))
+}
+
+// CheckLink checks the signature and the expiry of a playback link
+func CheckLink(path string, query url.Values, client LinkClient) int {
+	sig := query.Get("sig")
+	if sig == "" {
+		return LinkUnsigned
+	}
+	if linkSignKey() == nil {
+		return LinkInvalid
+	}
+	exp, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return LinkInvalid
+	}
+	if hmac.Equal([]byte(sig), []byte(linkSignature(linkSignKey(), path, query, client))) {
+		return LinkValid
+	}
+	// links signed before the secret was rotated, their token has been counted already
+	if key := retiredSignKey(); key != nil && hmac.Equal([]byte(sig), []byte(linkSignature(key, path, query, client))) {
+		return LinkValid
+	}
+	return LinkInvalid
+}
+
+// LinkAllowed tells whether a playback link may be used, links need a valid signature once legacy links are turned off
+func LinkAllowed(path string, query url.Values, client LinkClient) bool {
+	switch CheckLink(path, query, client) {
+	case LinkValid:
+		return true
+	case LinkUnsigned:
+		return LegacyLinksAllowed() || LinkLifetime() == 0
+	}
+	return false
+}
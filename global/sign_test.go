@@ -0,0 +1,176 @@
+package global
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// put the link settings in the config cache so that no database is needed
+func setLinkConfig(t *testing.T, settings map[string]string) {
+	t.Helper()
+	values := map[string]string{
+		"secret":            "",
+		"secret_prev":       "",
+		"secret_prev_until": "",
+		"link_lifetime":     "",
+		"link_binding":      "",
+		"link_legacy":       "",
+	}
+	for k, v := range settings {
+		values[k] = v
+	}
+	for k, v := range values {
+		ConfigCache.Store(k, v)
+	}
+	clearSignKey()
+	clearRetiredSecret()
+	t.Cleanup(func() {
+		for k := range values {
+			ConfigCache.Delete(k)
+		}
+		clearSignKey()
+		clearRetiredSecret()
+	})
+}
+
+func TestLinkSignature(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	query := url.Values{"c": {"3"}, "token": {"abc"}, "exp": {"1700000000"}, "b": {BindSession}}
+	client := LinkClient{IP: "192.0.2.1", UserAgent: "VLC/3.0"}
+	base := linkSignature(key, "/live.m3u8", query, client)
+	if len(base) != 22 {
+		t.Fatalf("signature %q has %d characters, want 22", base, len(base))
+	}
+
+	tests := []struct {
+		name   string
+		key    []byte
+		path   string
+		change func(q url.Values)
+		client LinkClient
+		same   bool
+	}{
+		{"same link", key, "/live.m3u8", nil, client, true},
+		{"path without slash", key, "live.m3u8", nil, client, true},
+		{"unsigned parameter", key, "/live.m3u8", func(q url.Values) { q.Set("header", "x") }, client, true},
+		{"other key", []byte("fedcba9876543210fedcba9876543210"), "/live.m3u8", nil, client, false},
+		{"other path", key, "/live.ts", nil, client, false},
+		{"other channel", key, "/live.m3u8", func(q url.Values) { q.Set("c", "4") }, client, false},
+		{"other token", key, "/live.m3u8", func(q url.Values) { q.Set("token", "abd") }, client, false},
+		{"later expiry", key, "/live.m3u8", func(q url.Values) { q.Set("exp", "1800000000") }, client, false},
+		{"proxy added", key, "/live.m3u8", func(q url.Values) { q.Set("proxy", "1") }, client, false},
+		{"binding dropped", key, "/live.m3u8", func(q url.Values) { q.Del("b") }, client, false},
+		{"other address", key, "/live.m3u8", nil, LinkClient{IP: "192.0.2.2", UserAgent: "VLC/3.0"}, false},
+		{"other user agent", key, "/live.m3u8", nil, LinkClient{IP: "192.0.2.1", UserAgent: "curl/8"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := url.Values{}
+			for k, v := range query {
+				q[k] = append([]string{}, v...)
+			}
+			if tt.change != nil {
+				tt.change(q)
+			}
+			sig := linkSignature(tt.key, tt.path, q, tt.client)
+			if (sig == base) != tt.same {
+				t.Errorf("signature %q, base %q, want same = %v", sig, base, tt.same)
+			}
+		})
+	}
+}
+
+func TestCheckLink(t *testing.T) {
+	client := LinkClient{IP: "192.0.2.1", UserAgent: "VLC/3.0"}
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	past := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	// a link signed with the settings and then changed before it is checked
+	sign := func(t *testing.T, settings map[string]string, change func(q url.Values)) url.Values {
+		setLinkConfig(t, settings)
+		q := url.Values{"c": {"3"}, "token": {"abc"}}
+		SignLink("/live.m3u8", q, client)
+		if change != nil {
+			change(q)
+		}
+		return q
+	}
+	signed := map[string]string{"secret": "s3cret", "link_lifetime": "2"}
+
+	tests := []struct {
+		name     string
+		settings map[string]string
+		change   func(q url.Values)
+		checkAs  LinkClient
+		check    map[string]string // settings when the link is checked, the signing ones when nil
+		want     int
+	}{
+		{"unsigned", map[string]string{"secret": "s3cret"}, nil, client, nil, LinkUnsigned},
+		{"valid", signed, nil, client, nil, LinkValid},
+		{"unbound from elsewhere", signed, nil, LinkClient{IP: "198.51.100.7"}, nil, LinkValid},
+		{"expired", signed, func(q url.Values) {
+			q.Set("exp", past)
+			q.Set("sig", linkSignature(linkSignKey(), "/live.m3u8", q, client))
+		}, client, nil, LinkInvalid},
+		{"expiry extended", signed, func(q url.Values) { q.Set("exp", future+"0") }, client, nil, LinkInvalid},
+		{"bad expiry", signed, func(q url.Values) { q.Set("exp", "soon") }, client, nil, LinkInvalid},
+		{"other channel", signed, func(q url.Values) { q.Set("c", "4") }, client, nil, LinkInvalid},
+		{"forged signature", signed, func(q url.Values) { q.Set("sig", "AAAAAAAAAAAAAAAAAAAAAA") }, client, nil, LinkInvalid},
+		{"ip bound", map[string]string{"secret": "s3cret", "link_lifetime": "2", "link_binding": BindIP}, nil,
+			LinkClient{IP: "192.0.2.1", UserAgent: "other"}, nil, LinkValid},
+		{"ip bound from elsewhere", map[string]string{"secret": "s3cret", "link_lifetime": "2", "link_binding": BindIP}, nil,
+			LinkClient{IP: "198.51.100.7", UserAgent: "VLC/3.0"}, nil, LinkInvalid},
+		{"binding removed", map[string]string{"secret": "s3cret", "link_lifetime": "2", "link_binding": BindIP},
+			func(q url.Values) { q.Del("b") }, LinkClient{IP: "198.51.100.7"}, nil, LinkInvalid},
+		{"session bound with other player", map[string]string{"secret": "s3cret", "link_lifetime": "2", "link_binding": BindSession}, nil,
+			LinkClient{IP: "192.0.2.1", UserAgent: "curl/8"}, nil, LinkInvalid},
+		{"secret removed", signed, nil, client, map[string]string{}, LinkInvalid},
+		{"secret changed", signed, nil, client, map[string]string{"secret": "other", "link_lifetime": "2"}, LinkInvalid},
+		{"secret rotated", signed, nil, client, map[string]string{"secret": "other", "link_lifetime": "2",
+			"secret_prev": "s3cret", "secret_prev_until": future}, LinkValid},
+		{"retired secret expired", signed, nil, client, map[string]string{"secret": "other", "link_lifetime": "2",
+			"secret_prev": "s3cret", "secret_prev_until": past}, LinkInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := sign(t, tt.settings, tt.change)
+			if tt.check != nil {
+				setLinkConfig(t, tt.check)
+			}
+			if got := CheckLink("/live.m3u8", q, tt.checkAs); got != tt.want {
+				t.Errorf("CheckLink = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkAllowed(t *testing.T) {
+	client := LinkClient{IP: "192.0.2.1"}
+	tests := []struct {
+		name     string
+		settings map[string]string
+		sig      string
+		want     bool
+	}{
+		{"signing off", map[string]string{"secret": "s3cret"}, "", true},
+		{"legacy links", map[string]string{"secret": "s3cret", "link_lifetime": "2"}, "", true},
+		{"legacy links off", map[string]string{"secret": "s3cret", "link_lifetime": "2", "link_legacy": "false"}, "", false},
+		{"legacy links off without signing", map[string]string{"secret": "s3cret", "link_legacy": "false"}, "", true},
+		{"bad signature", map[string]string{"secret": "s3cret", "link_lifetime": "2"}, "AAAAAAAAAAAAAAAAAAAAAA", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setLinkConfig(t, tt.settings)
+			q := url.Values{"c": {"3"}}
+			if tt.sig != "" {
+				q.Set("exp", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+				q.Set("sig", tt.sig)
+			}
+			if got := LinkAllowed("/live.m3u8", q, client); got != tt.want {
+				t.Errorf("LinkAllowed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
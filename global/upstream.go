@@ -0,0 +1,39 @@
+package global
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/snowie2000/livetv/metrics"
+)
+
+var (
+	upstreamLatency = metrics.NewHistogram("livetv_upstream_request_duration_seconds",
+		"Time until the response headers of upstream requests arrived.", metrics.DefBuckets, "host")
+	upstreamErrors = metrics.NewCounter("livetv_upstream_errors_total",
+		"Upstream requests that failed or got an error status, by host and reason.", "host", "reason")
+)
+
+// instruments the requests to upstream servers
+type upstreamTransport struct {
+	next http.RoundTripper
+}
+
+func (t upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	host := req.URL.Hostname()
+	upstreamLatency.Observe(time.Since(start).Seconds(), host)
+	if err != nil {
+		upstreamErrors.With(host, "network").Inc()
+	} else if resp.StatusCode >= 400 {
+		upstreamErrors.With(host, strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	return resp, err
+}
+
+// UpstreamTransport is TransportWithProxy with request latency and errors recorded by host
+func UpstreamTransport(proxyUrl string) http.RoundTripper {
+	return upstreamTransport{TransportWithProxy(proxyUrl)}
+}
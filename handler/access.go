@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/service"
+)
+
+func toAccessPolicyInfo(policy *model.AccessPolicy) *AccessPolicyInfo {
+	if policy == nil {
+		return nil
+	}
+	return &AccessPolicyInfo{
+		ID:            policy.ID,
+		Scope:         policy.Scope,
+		Target:        policy.Target,
+		Allow:         policy.Allow,
+		Deny:          policy.Deny,
+		Countries:     policy.Countries,
+		DenyCountries: policy.DenyCountries,
+	}
+}
+
+func accessPolicyList() ([]*AccessPolicyInfo, error) {
+	policies, err := service.GetAccessPolicies()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*AccessPolicyInfo, 0, len(policies))
+	for i := range policies {
+		list = append(list, toAccessPolicyInfo(&policies[i]))
+	}
+	return list, nil
+}
+
+// save a policy, the one it replaces is recorded in the audit log
+func saveAccessPolicy(c *gin.Context, in *AccessPolicyInfo) (*model.AccessPolicy, error) {
+	policy := &model.AccessPolicy{
+		ID:            in.ID,
+		Scope:         in.Scope,
+		Target:        in.Target,
+		Allow:         in.Allow,
+		Deny:          in.Deny,
+		Countries:     in.Countries,
+		DenyCountries: in.DenyCountries,
+	}
+	if err := service.ValidateAccessPolicy(policy); err != nil {
+		return nil, err
+	}
+	var before *model.AccessPolicy
+	if policy.ID > 0 {
+		var err error
+		if before, err = service.GetAccessPolicy(policy.ID); err != nil {
+			return nil, err
+		}
+	} else if before = service.FindAccessPolicy(policy.Scope, policy.Target); before != nil {
+		policy.ID = before.ID
+	}
+	if err := service.SaveAccessPolicy(policy); err != nil {
+		return nil, err
+	}
+	recordAudit(c, service.AuditAccessSave, service.AuditAccessTarget(policy), toAccessPolicyInfo(before), toAccessPolicyInfo(policy))
+	logAction(c, "saved %s", service.AccessPolicyName(policy))
+	return policy, nil
+}
+
+func deleteAccessPolicy(c *gin.Context, id int) error {
+	policy, err := service.GetAccessPolicy(id)
+	if err != nil {
+		return err
+	}
+	if err = service.DeleteAccessPolicy(id); err != nil {
+		return err
+	}
+	recordAudit(c, service.AuditAccessDelete, service.AuditAccessTarget(policy), toAccessPolicyInfo(policy), nil)
+	logAction(c, "deleted %s", service.AccessPolicyName(policy))
+	return nil
+}
+
+// whether an address may play a channel, the playlists when no channel is given
+func checkAccess(ip string, channel string) (*AccessCheck, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, errors.New("invalid address")
+	}
+	var ch *model.Channel
+	if channel != "" {
+		var err error
+		if ch, err = service.GetChannel(getChannelNumbers(channel)); err != nil {
+			return nil, err
+		}
+	}
+	check := &AccessCheck{IP: ip, Country: service.ClientCountry(addr), Allowed: true}
+	if policy := service.RefusingPolicy(ip, ch); policy != nil {
+		check.Allowed = false
+		check.Policy = service.AccessPolicyName(policy)
+	}
+	return check, nil
+}
+
+func AccessPolicyListHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleOwner) {
+		return
+	}
+	list, err := accessPolicyList()
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func SaveAccessPolicyHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleOwner) {
+		return
+	}
+	in := &AccessPolicyInfo{
+		Scope:         c.PostForm("scope"),
+		Target:        c.PostForm("target"),
+		Allow:         c.PostForm("allow"),
+		Deny:          c.PostForm("deny"),
+		Countries:     c.PostForm("countries"),
+		DenyCountries: c.PostForm("denycountries"),
+	}
+	in.ID, _ = strconv.Atoi(c.PostForm("id"))
+	policy, err := saveAccessPolicy(c, in)
+	if err != nil {
+		if errors.Is(err, service.ErrAccessPolicyNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, toAccessPolicyInfo(policy))
+}
+
+func DeleteAccessPolicyHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleOwner) {
+		return
+	}
+	id, _ := strconv.Atoi(c.Query("id"))
+	if id == 0 {
+		c.String(http.StatusBadRequest, "empty id")
+		return
+	}
+	if err := deleteAccessPolicy(c, id); err != nil {
+		if errors.Is(err, service.ErrAccessPolicyNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "")
+}
+
+// AccessCheckHandler tells whether the address given by ip may play the channel given by c, the caller's
+// address and the playlists when they are left out
+func AccessCheckHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleOwner) {
+		return
+	}
+	check, err := checkAccess(c.DefaultQuery("ip", c.ClientIP()), c.Query("c"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, check)
+}
+
+func V2AccessPoliciesHandler(c *gin.Context) {
+	list, err := accessPolicyList()
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func V2SaveAccessPolicyHandler(c *gin.Context) {
+	var in AccessPolicyInfo
+	if !bindJSON(c, &in) {
+		return
+	}
+	policy, err := saveAccessPolicy(c, &in)
+	if err != nil {
+		if errors.Is(err, service.ErrAccessPolicyNotFound) {
+			apiError(c, http.StatusNotFound, "not_found", err.Error())
+			return
+		}
+		apiError(c, http.StatusBadRequest, "invalid_policy", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, toAccessPolicyInfo(policy))
+}
+
+func V2AccessCheckHandler(c *gin.Context) {
+	check, err := checkAccess(c.DefaultQuery("ip", c.ClientIP()), c.Query("c"))
+	if err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_check", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, check)
+}
+
+func V2DeleteAccessPolicyHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_id", "invalid policy id")
+		return
+	}
+	if err = deleteAccessPolicy(c, id); err != nil {
+		if errors.Is(err, service.ErrAccessPolicyNotFound) {
+			apiError(c, http.StatusNotFound, "not_found", err.Error())
+		} else {
+			apiInternalError(c, err)
+		}
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -2,14 +2,17 @@ package handler
 
 import (
 	"embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/gin-contrib/sessions"
@@ -38,7 +41,7 @@ func FetchHandler(c *gin.Context) {
 	// verify token against the unique token of the requested channel
 	if !disableProtection {
 		token := c.Query("token")
-		if token != global.GetSecretToken() { // invalid token
+		if !global.CheckSecretToken(token) { // invalid token
 			c.String(http.StatusForbidden, "Forbidden")
 			return
 		}
@@ -49,12 +52,17 @@ func FetchHandler(c *gin.Context) {
 		c.AbortWithStatus(404)
 		return
 	}
+	// the target is checked here too, in case a proxy of the environment makes the connection
+	if err := global.CheckDestination(c.Request.Context(), url); err != nil {
+		upstreamFailed(c, err)
+		return
+	}
 	device := c.Query("device")
 	if device == "" {
 		device = "chrome"
 	}
 
-	client := freq.C()
+	client := freq.C().SetDial(global.GuardedDialContext)
 	switch device {
 	case "safari":
 		client.ImpersonateSafari()
@@ -116,6 +124,10 @@ func FetchHandler(c *gin.Context) {
 	req.Method = c.Request.Method
 
 	resp := req.Do()
+	if resp.Err != nil {
+		upstreamFailed(c, resp.Err)
+		return
+	}
 	for k, v := range resp.Header {
 		if len(v) > 0 {
 			c.Header(k, v[0])
@@ -183,6 +195,34 @@ func loadConfig() (Config, error) {
 	if apiKey, err := global.GetConfig("apiKey"); err == nil {
 		conf.ApiKey = apiKey
 	}
+	if interval, err := global.GetConfig("backup_interval"); err == nil {
+		conf.BackupInterval = interval
+	}
+	if keep, err := global.GetConfig("backup_keep"); err == nil {
+		conf.BackupKeep = keep
+	}
+	if token, err := global.GetConfig("metrics_token"); err == nil {
+		conf.MetricsToken = token
+	}
+	if allow, err := global.GetConfig("metrics_allow"); err == nil {
+		conf.MetricsAllow = allow
+	}
+	if lifetime, err := global.GetConfig("link_lifetime"); err == nil {
+		conf.LinkLifetime = lifetime
+	}
+	conf.LinkBinding = global.LinkBinding()
+	conf.LinkLegacy = strconv.FormatBool(global.LegacyLinksAllowed())
+	if grace, err := global.GetConfig("secret_grace"); err == nil {
+		conf.SecretGrace = grace
+	}
+	conf.Rotation = global.SecretRotation()
+	for key, field := range oidcConfigFields(&conf) {
+		*field, _ = global.GetConfig(key)
+	}
+	conf.DestAllow, _ = global.GetConfig("dest_allow")
+	conf.DestDeny, _ = global.GetConfig("dest_deny")
+	conf.DestTrustChannels, _ = global.GetConfig("dest_trust_channels")
+	conf.GeoIPDB, _ = global.GetConfig("geoip_db")
 	return conf, nil
 }
 
@@ -205,8 +245,7 @@ func CRSFHandler(c *gin.Context) {
 }
 
 func PluginListHandler(c *gin.Context) {
-	if sessions.Default(c).Get("logined") != true {
-		c.String(http.StatusUnauthorized, "Unauthorized")
+	if !requireRole(c, model.RoleViewer) {
 		return
 	}
 	list := service.GetPluginList()
@@ -214,8 +253,7 @@ func PluginListHandler(c *gin.Context) {
 }
 
 func ChannelListHandler(c *gin.Context) {
-	if sessions.Default(c).Get("logined") != true {
-		c.String(http.StatusUnauthorized, "Unauthorized")
+	if !requireRole(c, model.RoleViewer) {
 		return
 	}
 	baseUrl, err := global.GetConfig("base_url")
@@ -245,7 +283,7 @@ func ChannelListHandler(c *gin.Context) {
 			URL:        v.URL,
 			Parser:     v.Parser,
 			TsProxy:    v.TsProxy,
-			M3U8:       fmt.Sprintf("%s/live.m3u8?token=%s&c=%d", baseUrl, v.Token, v.ID),
+			M3U8:       baseUrl + "/live.m3u8?" + service.LiveQuery(v.Token, v.ChannelID, linkClient(c)),
 			Proxy:      v.Proxy,
 			ProxyUrl:   v.ProxyUrl,
 			LastUpdate: status.Time.Format("2006-01-02 15:04:05"),
@@ -253,13 +291,14 @@ func ChannelListHandler(c *gin.Context) {
 			Message:    status.Msg,
 			Category:   v.Category,
 			Extra:      v.Extra,
+			Uptime:     uptimeSummary(v.URL),
 		}
 		if len(v.Children) > 0 {
 			list := []Channel{}
 			for _, sub := range v.Children {
 				status := service.GetStatus(sub.URL)
 				subID := fmt.Sprintf("%d-%d", v.ID, sub.ID)
-				composedUrl := fmt.Sprintf("%s/live.m3u8?token=%s&c=%s", baseUrl, sub.Token, subID)
+				composedUrl := baseUrl + "/live.m3u8?" + service.LiveQuery(sub.Token, subID, linkClient(c))
 				if sub.CustomQueryString != "" {
 					composedUrl = composedUrl + "&" + sub.CustomQueryString
 				}
@@ -277,6 +316,9 @@ func ChannelListHandler(c *gin.Context) {
 					Message:    status.Msg,
 					Category:   sub.Category,
 					Extra:      sub.Extra,
+					Logo:       sub.Logo,
+					Identity:   sub.Identity,
+					Uptime:     uptimeSummary(sub.URL),
 					Virtual:    true, // sub channels are all virtual
 				}
 				list = append(list, c)
@@ -288,9 +330,41 @@ func ChannelListHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, channels)
 }
 
+// number of recent events to return, 50 by default
+func historyLimit(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		return 50
+	}
+	return limit
+}
+
+func ChannelHistoryHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleViewer) {
+		return
+	}
+	chID, chSubId := getChannelNumbers(c.Query("id"))
+	if chID == 0 {
+		c.String(http.StatusBadRequest, "empty id")
+		return
+	}
+	ch, err := service.GetChannel(chID, chSubId)
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	days, _ := strconv.Atoi(c.Query("days"))
+	history, err := service.GetChannelHistory(ch.URL, days, historyLimit(c))
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
 func NewChannelHandler(c *gin.Context) {
-	if sessions.Default(c).Get("logined") != true {
-		c.String(http.StatusUnauthorized, "Unauthorized")
+	if !requireRole(c, model.RoleEditor) {
 		return
 	}
 	chName := c.PostForm("name")
@@ -328,12 +402,14 @@ func NewChannelHandler(c *gin.Context) {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	logAction(c, "added channel %d %s", mch.ID, mch.Name)
+	recordAudit(c, service.AuditChannelCreate, service.AuditChannelTarget(strconv.Itoa(mch.ID)), nil, service.ChannelSnapshot(mch))
 	c.String(http.StatusOK, "")
 	go service.UpdateURLCacheSingle(mch, true) // update liveURL on adding new channel
 }
 
 func AuthProbeHandler(c *gin.Context) {
-	if sessions.Default(c).Get("logined") != true {
+	if currentUser(c) == nil {
 		c.String(http.StatusUnauthorized, "Unauthorized")
 	} else {
 		c.String(http.StatusOK, "")
@@ -341,8 +417,7 @@ func AuthProbeHandler(c *gin.Context) {
 }
 
 func UpdateChannelHandler(c *gin.Context) {
-	if sessions.Default(c).Get("logined") != true {
-		c.String(http.StatusUnauthorized, "Unauthorized")
+	if !requireRole(c, model.RoleEditor) {
 		return
 	}
 	chID, chSubId := getChannelNumbers(c.PostForm("id"))
@@ -351,7 +426,7 @@ func UpdateChannelHandler(c *gin.Context) {
 		return
 	}
 	if chSubId >= 0 {
-		c.String(http.StatusInternalServerError, "can't update sub channels")
+		updateSubChannel(c, chID, chSubId)
 		return
 	}
 	channel, err := service.GetChannel(chID, -1)
@@ -359,6 +434,7 @@ func UpdateChannelHandler(c *gin.Context) {
 		c.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
+	before := service.ChannelSnapshot(channel)
 	chName := c.PostForm("name")
 	chURL := global.CleanString(c.PostForm("url"))
 	chParser := global.CleanString(c.PostForm("parser"))
@@ -393,13 +469,14 @@ func UpdateChannelHandler(c *gin.Context) {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	logAction(c, "updated channel %d %s", channel.ID, channel.Name)
+	recordAudit(c, service.AuditChannelUpdate, service.AuditChannelTarget(strconv.Itoa(channel.ID)), before, service.ChannelSnapshot(channel))
 	c.String(http.StatusOK, "")
 	go service.UpdateURLCacheSingle(channel, true) // update liveURL on updating new channel
 }
 
 func DeleteChannelHandler(c *gin.Context) {
-	if sessions.Default(c).Get("logined") != true {
-		c.String(http.StatusUnauthorized, "Unauthorized")
+	if !requireRole(c, model.RoleEditor) {
 		return
 	}
 	chID, chSubId := getChannelNumbers(c.Query("id"))
@@ -408,21 +485,261 @@ func DeleteChannelHandler(c *gin.Context) {
 		return
 	}
 	if chSubId >= 0 {
-		c.String(http.StatusInternalServerError, "can't delete sub channels")
+		// sub channels come from their provider, deleting one hides it instead
+		sub, err := service.GetChannel(chID, chSubId)
+		if err != nil {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		if err = service.HideSubChannel(sub); err != nil {
+			log.Println(err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		logAction(c, "hid sub channel %s %s", sub.ChannelID, sub.Name)
+		recordAudit(c, service.AuditSubChannelHide, service.AuditChannelTarget(sub.ChannelID), subChannelSnapshot(sub, false), subChannelSnapshot(sub, true))
+		c.String(http.StatusOK, "")
+		return
+	}
+	channel, err := service.GetChannel(chID, -1)
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	err = deleteChannel(chID)
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	logAction(c, "deleted channel %d", chID)
+	recordAudit(c, service.AuditChannelDelete, service.AuditChannelTarget(strconv.Itoa(chID)), service.ChannelSnapshot(channel), nil)
+	c.String(http.StatusOK, "")
+}
+
+// delete a primary channel with everything that belongs to it
+func deleteChannel(chID int) error {
+	if err := service.DeleteChannel(chID); err != nil {
+		return err
+	}
+	// the channel is gone for good, release its reserved sub channel numbers and overrides
+	if err := service.DeleteSubChannelNumbers(chID); err != nil {
+		log.Println(err.Error())
+	}
+	if err := service.DeleteChannelOverrides(chID); err != nil {
+		log.Println(err.Error())
+	}
+	if err := service.DeleteFilterRules(chID); err != nil {
+		log.Println(err.Error())
+	}
+	return nil
+}
+
+// save name, category and logo of a sub channel as an override of its provider's data
+func updateSubChannel(c *gin.Context, chID int, chSubId int) {
+	sub, err := service.GetChannel(chID, chSubId)
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	chName := strings.TrimSpace(c.PostForm("name"))
+	chCategory := global.CleanString(c.PostForm("category"))
+	chLogo := global.CleanString(c.PostForm("logo"))
+	chHidden := c.PostForm("hidden") == "true"
+	err = service.SaveChannelOverride(sub, chName, chCategory, chLogo, chHidden)
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	logAction(c, "updated sub channel %s %s", sub.ChannelID, chName)
+	after := &model.Channel{Name: chName, Category: chCategory, Logo: chLogo}
+	recordAudit(c, service.AuditSubChannelUpdate, service.AuditChannelTarget(sub.ChannelID), subChannelSnapshot(sub, false), subChannelSnapshot(after, chHidden))
+	c.String(http.StatusOK, "")
+}
+
+func ChannelOverridesHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleViewer) {
+		return
+	}
+	chID, _ := getChannelNumbers(c.Query("id"))
+	if chID == 0 {
+		c.String(http.StatusInternalServerError, "empty id")
+		return
+	}
+	overrides, err := service.GetChannelOverrides(chID)
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
-	err := service.DeleteChannel(chID)
+	c.JSON(http.StatusOK, overrides)
+}
+
+func DeleteOverrideHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleEditor) {
+		return
+	}
+	chID, _ := getChannelNumbers(c.Query("id"))
+	identity := c.Query("identity")
+	if chID == 0 || identity == "" {
+		c.String(http.StatusBadRequest, "empty id")
+		return
+	}
+	err := service.DeleteChannelOverride(chID, identity)
 	if err != nil {
 		log.Println(err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	logAction(c, "deleted override %s of channel %d", identity, chID)
+	c.String(http.StatusOK, "")
+}
+
+// parse filter rules posted as a json array
+func postedFilterRules(c *gin.Context) ([]model.FilterRule, error) {
+	var rules []model.FilterRule
+	if raw := strings.TrimSpace(c.PostForm("rules")); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+func FilterListHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleViewer) {
+		return
+	}
+	chID, _ := getChannelNumbers(c.Query("id"))
+	if chID == 0 {
+		c.String(http.StatusInternalServerError, "empty id")
+		return
+	}
+	rules, err := service.GetFilterRules(chID)
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+func UpdateFiltersHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleEditor) {
+		return
+	}
+	chID, chSubId := getChannelNumbers(c.PostForm("id"))
+	if chID == 0 || chSubId >= 0 {
+		c.String(http.StatusBadRequest, "invalid id")
+		return
+	}
+	channel, err := service.GetChannel(chID, -1)
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	rules, err := postedFilterRules(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	err = service.SaveFilterRules(chID, rules)
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	logAction(c, "updated %d filter rules of channel %d", len(rules), chID)
 	c.String(http.StatusOK, "")
+	go service.UpdateURLCacheSingle(channel, true) // reparse the playlist with the new rules
+}
+
+func FilterPreviewHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleViewer) {
+		return
+	}
+	channel := &model.Channel{}
+	if chID, _ := getChannelNumbers(c.PostForm("id")); chID > 0 {
+		ch, err := service.GetChannel(chID, -1)
+		if err != nil {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		channel = ch
+	}
+	if chURL := global.CleanString(c.PostForm("url")); chURL != "" {
+		channel.URL = chURL
+		channel.ProxyUrl = global.CleanString(c.PostForm("proxyurl"))
+		channel.Extra = c.PostForm("extra")
+	}
+	if chParser := global.CleanString(c.PostForm("parser")); chParser != "" {
+		channel.Parser = chParser
+	}
+	if channel.URL == "" {
+		c.String(http.StatusBadRequest, "Incomplete channel info")
+		return
+	}
+	p, err := service.GetPlugin(channel.Parser)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	previewer, ok := p.(service.FilterPreviewer)
+	if !ok {
+		c.String(http.StatusBadRequest, "parser does not support filters")
+		return
+	}
+	rules, err := postedFilterRules(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	kept, dropped, err := previewer.PreviewFilter(channel, rules)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, FilterPreview{
+		Total:   len(kept) + len(dropped),
+		Kept:    kept,
+		Dropped: dropped,
+	})
+}
+
+func PromoteChannelHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleEditor) {
+		return
+	}
+	chID, chSubId := getChannelNumbers(c.Query("id"))
+	if chID == 0 || chSubId < 0 {
+		c.String(http.StatusBadRequest, "not a sub channel")
+		return
+	}
+	sub, err := service.GetChannel(chID, chSubId)
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	mch, err := service.PromoteSubChannel(sub)
+	if mch == nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	logAction(c, "promoted sub channel %s to channel %d", sub.ChannelID, mch.ID)
+	go service.UpdateURLCacheSingle(mch, true)
+	if err != nil {
+		// the channel exists, but the sub channel is still listed next to it
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, fmt.Sprintf("channel %d was created but the sub channel couldn't be hidden: %s", mch.ID, err))
+		return
+	}
+	c.String(http.StatusOK, strconv.Itoa(mch.ID))
 }
 
 func GetConfigHandler(c *gin.Context) {
-	if sessions.Default(c).Get("logined") != true {
-		c.String(http.StatusUnauthorized, "Unauthorized")
+	if !requireRole(c, model.RoleOwner) {
 		return
 	}
 	conf, err := loadConfig()
@@ -435,57 +752,138 @@ func GetConfigHandler(c *gin.Context) {
 }
 
 func CategoryHandler(c *gin.Context) {
-	if sessions.Default(c).Get("logined") != true {
-		c.String(http.StatusUnauthorized, "Unauthorized")
+	if !requireRole(c, model.RoleViewer) {
 		return
 	}
 	categories := global.GetAllCategories()
 	c.JSON(http.StatusOK, categories)
 }
 
+func validLinkBinding(binding string) bool {
+	return binding == global.BindNone || binding == global.BindIP || binding == global.BindSession
+}
+
+// the relays tell the channel of a link by its signature while there are category or channel policies
+func validLinkLifetime(lifetime string) bool {
+	hours, _ := strconv.Atoi(lifetime)
+	return hours > 0 || !service.ChannelPoliciesExist()
+}
+
+const linkLifetimeNeeded = "link_lifetime can't be turned off while there are category or channel policies"
+
 func UpdateConfigHandler(c *gin.Context) {
-	if sessions.Default(c).Get("logined") != true {
-		c.String(http.StatusUnauthorized, "Unauthorized")
+	if !requireRole(c, model.RoleOwner) {
 		return
 	}
-	ytdlCmd := c.PostForm("cmd")
-	ytdlArgs := c.PostForm("args")
-	baseUrl := strings.TrimSuffix(c.PostForm("baseurl"), "/")
-	apiKey := strings.TrimSpace(c.PostForm("apikey"))
-	secret := strings.TrimSpace(c.PostForm("secret"))
-	if len(ytdlCmd) > 0 {
-		err := global.SetConfig("ytdl_cmd", ytdlCmd)
-		if err != nil {
-			log.Println(err.Error())
-			c.String(http.StatusInternalServerError, err.Error())
+	// settings saved before a write fails stay changed, so they are recorded either way
+	defer recordConfigChange(c, service.ConfigSnapshot())
+	type change struct{ key, value string }
+	var changes []change
+	// validate everything before saving anything
+	for _, field := range []struct{ key, form string }{{"ytdl_cmd", "cmd"}, {"ytdl_args", "args"}, {"base_url", "baseurl"}} {
+		value := c.PostForm(field.form)
+		if field.key == "base_url" {
+			value = strings.TrimSuffix(value, "/")
+		}
+		if len(value) > 0 {
+			changes = append(changes, change{field.key, value})
+		}
+	}
+	for _, field := range []struct{ key, form string }{{"backup_interval", "backupinterval"}, {"backup_keep", "backupkeep"}, {"link_lifetime", "linklifetime"}, {"secret_grace", "secretgrace"}} {
+		if value, ok := c.GetPostForm(field.form); ok {
+			if _, err := strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				c.String(http.StatusBadRequest, "%s must be a number", field.form)
+				return
+			}
+			if field.key == "link_lifetime" && !validLinkLifetime(strings.TrimSpace(value)) {
+				c.String(http.StatusBadRequest, linkLifetimeNeeded)
+				return
+			}
+			changes = append(changes, change{field.key, strings.TrimSpace(value)})
+		}
+	}
+	if binding, ok := c.GetPostForm("linkbinding"); ok {
+		if !validLinkBinding(binding) {
+			c.String(http.StatusBadRequest, "linkbinding must be empty, ip or session")
 			return
 		}
+		changes = append(changes, change{"link_binding", binding})
 	}
-	if len(ytdlArgs) > 0 {
-		err := global.SetConfig("ytdl_args", ytdlArgs)
-		if err != nil {
-			log.Println(err.Error())
-			c.String(http.StatusInternalServerError, err.Error())
+	if legacy, ok := c.GetPostForm("linklegacy"); ok {
+		changes = append(changes, change{"link_legacy", strconv.FormatBool(legacy != "false")})
+	}
+	for _, field := range []struct{ key, form string }{{"dest_allow", "destallow"}, {"dest_deny", "destdeny"}} {
+		if value, ok := c.GetPostForm(field.form); ok {
+			if err := global.CheckDestinationList(value); err != nil {
+				c.String(http.StatusBadRequest, "%s: %s", field.form, err)
+				return
+			}
+			changes = append(changes, change{field.key, strings.TrimSpace(value)})
+		}
+	}
+	if trust, ok := c.GetPostForm("desttrustchannels"); ok {
+		changes = append(changes, change{"dest_trust_channels", strconv.FormatBool(trust != "false")})
+	}
+	if geoip, ok := c.GetPostForm("geoipdb"); ok {
+		if err := service.CheckGeoIPDatabase(strings.TrimSpace(geoip)); err != nil {
+			c.String(http.StatusBadRequest, "geoipdb: %s", err)
 			return
 		}
+		changes = append(changes, change{"geoip_db", strings.TrimSpace(geoip)})
 	}
-	if len(baseUrl) > 0 {
-		err := global.SetConfig("base_url", baseUrl)
-		if err != nil {
+	for _, field := range []struct{ key, form string }{{"metrics_token", "metricstoken"}, {"metrics_allow", "metricsallow"}} {
+		if value, ok := c.GetPostForm(field.form); ok {
+			changes = append(changes, change{field.key, strings.TrimSpace(value)})
+		}
+	}
+	oidc := make(map[string]*string)
+	for key, form := range oidcConfigForms {
+		if value, ok := c.GetPostForm(form); ok {
+			oidc[key] = &value
+		}
+	}
+	if err := checkOIDCConfig(oidc); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	for key, value := range oidc {
+		changes = append(changes, change{key, strings.TrimSpace(*value)})
+	}
+	changes = append(changes, change{"apiKey", strings.TrimSpace(c.PostForm("apikey"))})
+	for _, change := range changes {
+		if err := global.SetConfig(change.key, change.value); err != nil {
 			log.Println(err.Error())
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 	}
-	global.SetConfig("apiKey", apiKey)
-	global.SetConfig("secret", secret)
+	// after secret_grace, so a new grace window applies to this change already
+	if err := global.SetSecret(strings.TrimSpace(c.PostForm("secret"))); err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
 	global.ClearSecretToken()
+	logAction(c, "updated settings")
+	c.String(http.StatusOK, "")
+}
+
+// EndRotationHandler stops accepting tokens of the previous secret right away
+func EndRotationHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleOwner) {
+		return
+	}
+	if err := global.EndRotation(); err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	logAction(c, "ended the secret rotation")
 	c.String(http.StatusOK, "")
 }
 
 func LogHandler(c *gin.Context) {
-	if sessions.Default(c).Get("logined") != true {
-		c.String(http.StatusUnauthorized, "Unauthorized")
+	if !requireRole(c, model.RoleViewer) {
 		return
 	}
 	c.File(os.Getenv("LIVETV_DATADIR") + "/livetv.log")
@@ -507,36 +905,68 @@ func LoginViewHandler(c *gin.Context) {
 	})
 }
 
-func LoginActionHandler(c *gin.Context) {
-	session := sessions.Default(c)
+// log a user in for a week
+func startSession(session sessions.Session, userID int) error {
 	session.Options(sessions.Options{
 		SameSite: http.SameSiteStrictMode,
 		Secure:   false,
 		MaxAge:   86400 * 7,
 		Path:     "/",
 	})
+	session.Set("logined", true)
+	session.Set("user", userID)
+	return session.Save()
+}
+
+func LoginActionHandler(c *gin.Context) {
+	session := sessions.Default(c)
 	crsfToken := c.PostForm("crsf")
 	if crsfToken != session.Get("crsfToken") {
 		log.Println(crsfToken, session.Get("crsfToken"))
 		c.String(http.StatusBadRequest, "bad request")
 		return
 	}
+	// clients that failed too often have to wait, whatever they send
+	if wait := service.LoginLocked(c.ClientIP()); wait > 0 {
+		seconds := int(math.Ceil(wait.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		c.String(http.StatusTooManyRequests, "Too many failed logins, try again in %d seconds", seconds)
+		return
+	}
 	// verify captcha before verifying password so as to protect us from bruteforce attack.
 	captchaId := c.PostForm("captcha_id")
 	captchaAnswer := c.PostForm("answer")
-	if !recaptcha.DefaultCaptcha.Verify(&recaptcha.CaptchaData{captchaId, "", captchaAnswer}) {
+	if !recaptcha.DefaultCaptcha.Verify(&recaptcha.CaptchaData{CaptchaId: captchaId, Answer: captchaAnswer}) {
 		c.String(http.StatusForbidden, "Invalid captcha")
 		return
 	}
-	pass := c.PostForm("password")
-	cfgPass, err := global.GetConfig("password")
+	// the login form of older versions only asks for the password
+	name := strings.TrimSpace(c.PostForm("username"))
+	if name == "" {
+		name = global.DefaultOwner
+	}
+	user, ok, err := service.Authenticate(name, c.PostForm("password"))
 	if err != nil {
+		log.Println(err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
-	if pass == cfgPass {
-		session.Set("logined", true)
-		err = session.Save()
+	if ok && user.TOTPSecret != "" {
+		otp := c.PostForm("otp")
+		if otp == "" {
+			// the password was right, the login form has to ask for the code. This counts as a failure
+			// too, or the answer would let the password be guessed without ever being locked out
+			service.LoginFailed(c.ClientIP())
+			c.String(http.StatusUnauthorized, "Two-factor code required")
+			return
+		}
+		ok = service.CheckTwoFactor(user, otp)
+	}
+	if ok {
+		service.LoginSucceeded(c.ClientIP())
+		service.PublishLogin(true, name, c.ClientIP())
+		service.UserLoggedIn(user)
+		err = startSession(session, user.ID)
 		if err != nil {
 			log.Println(err.Error())
 			c.String(http.StatusInternalServerError, err.Error())
@@ -544,6 +974,8 @@ func LoginActionHandler(c *gin.Context) {
 		}
 		c.String(http.StatusOK, "ok")
 	} else {
+		service.LoginFailed(c.ClientIP())
+		service.PublishLogin(false, name, c.ClientIP())
 		c.String(http.StatusForbidden, "Password error!")
 	}
 }
@@ -555,6 +987,7 @@ func LogoutHandler(c *gin.Context) {
 	}
 	session := sessions.Default(c)
 	session.Delete("logined")
+	session.Delete("user")
 	err := session.Save()
 	if err != nil {
 		log.Println(err.Error())
@@ -575,24 +1008,29 @@ func CORSHandler(c *gin.Context) {
 }
 
 func ChangePasswordHandler(c *gin.Context) {
-	if sessions.Default(c).Get("logined") != true {
-		c.String(http.StatusUnauthorized, "Unauthorized")
+	if !requireRole(c, model.RoleViewer) {
 		return
 	}
 	pass := c.PostForm("password")
 	pass2 := c.PostForm("password2")
 	if pass == "" {
 		c.String(http.StatusBadRequest, "Empty password!")
+		return
 	}
 	if pass != pass2 {
 		c.String(http.StatusBadRequest, "Password mismatch!")
+		return
 	}
-	err := global.SetConfig("password", pass)
+	user := currentUser(c)
+	before := map[string]string{"password": user.Password}
+	err := service.SetUserPassword(user, pass)
 	if err != nil {
 		log.Println(err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	logAction(c, "changed their password")
+	recordAudit(c, service.AuditPasswordChange, service.AuditUserTarget(user.Name), before, map[string]string{"password": user.Password})
 	LogoutHandler(c)
 }
 
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/service"
+)
+
+func auditActor(c *gin.Context) service.AuditActor {
+	return service.AuditActor{Name: actor(c), IP: c.ClientIP()}
+}
+
+// record a change in the audit log, before and after are snapshots of the target, nil when it didn't exist
+func recordAudit(c *gin.Context, action string, target string, before any, after any) {
+	service.RecordAudit(auditActor(c), action, target, before, after)
+}
+
+// record the settings changed by a request, given the settings before it
+func recordConfigChange(c *gin.Context, before map[string]string) {
+	after := service.ConfigSnapshot()
+	if !reflect.DeepEqual(before, after) {
+		recordAudit(c, service.AuditConfigUpdate, service.AuditConfigTarget, before, after)
+	}
+}
+
+// snapshot of the fields of a sub channel that can be changed
+func subChannelSnapshot(sub *model.Channel, hidden bool) map[string]any {
+	return map[string]any{
+		"Name":     sub.Name,
+		"Category": sub.Category,
+		"Logo":     sub.Logo,
+		"Hidden":   hidden,
+	}
+}
+
+func toAuditEntryInfo(entry *model.AuditEntry) AuditEntryInfo {
+	return AuditEntryInfo{
+		ID:         entry.ID,
+		Time:       entry.Time,
+		Actor:      entry.Actor,
+		IP:         entry.IP,
+		Action:     entry.Action,
+		Target:     entry.Target,
+		Changes:    service.AuditChanges(entry),
+		Revertible: service.AuditRevertible(entry),
+	}
+}
+
+func auditPage(c *gin.Context) (*AuditPage, error) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit := historyLimit(c)
+	if limit > service.MaxAuditLimit {
+		limit = service.MaxAuditLimit
+	}
+	entries, total, err := service.GetAuditLog(page, limit, c.Query("target"), c.Query("actor"))
+	if err != nil {
+		return nil, err
+	}
+	result := &AuditPage{
+		Entries: make([]AuditEntryInfo, 0, len(entries)),
+		Total:   total,
+		Page:    page,
+		Limit:   limit,
+	}
+	for i := range entries {
+		result.Entries = append(result.Entries, toAuditEntryInfo(&entries[i]))
+	}
+	return result, nil
+}
+
+// AuditLogHandler returns a page of the audit log, newest first. page, limit, target and actor are optional.
+func AuditLogHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleEditor) {
+		return
+	}
+	result, err := auditPage(c)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// RevertAuditHandler brings a channel back to its version before the change of the posted audit entry
+func RevertAuditHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleEditor) {
+		return
+	}
+	id, _ := strconv.Atoi(c.PostForm("id"))
+	ch, err := service.RevertChannel(id, auditActor(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrAuditNotFound):
+			c.String(http.StatusNotFound, err.Error())
+		case errors.Is(err, service.ErrNotRevertible):
+			c.String(http.StatusBadRequest, err.Error())
+		default:
+			c.String(http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	logAction(c, "reverted channel %d to its version before change %d", ch.ID, id)
+	c.String(http.StatusOK, strconv.Itoa(ch.ID))
+	go service.UpdateURLCacheSingle(ch, true)
+}
+
+func V2AuditHandler(c *gin.Context) {
+	result, err := auditPage(c)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func V2RevertAuditHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_id", "invalid audit entry id")
+		return
+	}
+	ch, err := service.RevertChannel(id, auditActor(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrAuditNotFound):
+			apiError(c, http.StatusNotFound, "not_found", err.Error())
+		case errors.Is(err, service.ErrNotRevertible):
+			apiError(c, http.StatusBadRequest, "not_revertible", err.Error())
+		default:
+			apiInternalError(c, err)
+		}
+		return
+	}
+	logAction(c, "reverted channel %d to its version before change %d", ch.ID, id)
+	go service.UpdateURLCacheSingle(ch, true)
+	replyChannel(c, http.StatusOK, ch.ID)
+}
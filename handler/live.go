@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"errors"
 	"io"
 	"log"
 	"net/http"
@@ -23,18 +24,85 @@ import (
 	"github.com/snowie2000/livetv/util"
 )
 
+// the full playlist is served with the secret token, subscribers get the channels they may watch.
+// sub is nil when the request isn't from a subscriber.
+func playlistAccess(c *gin.Context) (sub *model.Subscriber, ok bool) {
+	if os.Getenv("LIVETV_FREEACCESS") == "1" {
+		return nil, true
+	}
+	token := c.Query("token")
+	if global.CheckSecretToken(token) {
+		return nil, true
+	}
+	return service.CheckSubscriber(token)
+}
+
+// the client signed links are bound to
+func linkClient(c *gin.Context) global.LinkClient {
+	return global.LinkClient{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+}
+
+// refuse a request whose session can't be opened
+func sessionRefused(c *gin.Context, err error) {
+	if errors.Is(err, service.ErrTooManyStreams) {
+		c.String(http.StatusTooManyRequests, err.Error())
+		return
+	}
+	c.String(http.StatusForbidden, "Forbidden")
+}
+
+// refuse a request the access policies don't let through, ch is nil for the playlists
+func playbackRefused(c *gin.Context, ch *model.Channel) bool {
+	if err := service.CheckPlayback(c.ClientIP(), ch); err != nil {
+		c.String(http.StatusForbidden, "Forbidden")
+		return true
+	}
+	return false
+}
+
+// the channel of a request's c parameter, nil when there is none or it isn't written the way channel ids are
+func requestedChannel(c *gin.Context) *model.Channel {
+	channelNumber, subNumber := getChannelNumbers(c.Query("c"))
+	if channelNumber <= 0 {
+		return nil
+	}
+	ch, err := service.GetChannel(channelNumber, subNumber)
+	if err != nil || ch.ChannelID != c.Query("c") {
+		return nil
+	}
+	return ch
+}
+
+// the channel a relay link is for, the request is refused when it has none the client may play.
+// The relays take the live token every channel shares, so with category or channel policies
+// only a signed link, which binds c to the upstream in k, says which channel it is.
+func relayChannel(c *gin.Context, path string) (*model.Channel, bool) {
+	ch := requestedChannel(c)
+	if ch == nil {
+		c.String(http.StatusBadRequest, "Unknown channel")
+		return nil, false
+	}
+	if service.ChannelPoliciesExist() && global.CheckLink(path, c.Request.URL.Query(), linkClient(c)) != global.LinkValid {
+		c.String(http.StatusForbidden, "Forbidden")
+		return nil, false
+	}
+	if playbackRefused(c, ch) {
+		return nil, false
+	}
+	return ch, true
+}
+
 func M3UHandler(c *gin.Context) {
-	disableProtection := os.Getenv("LIVETV_FREEACCESS") == "1"
-	// verify token against the unique token of the requested channel
-	if !disableProtection {
-		token := c.Query("token")
-		if token != global.GetSecretToken() { // invalid token
-			c.String(http.StatusForbidden, "Forbidden")
-			return
-		}
+	if playbackRefused(c, nil) {
+		return
+	}
+	sub, ok := playlistAccess(c)
+	if !ok {
+		c.String(http.StatusForbidden, "Forbidden")
+		return
 	}
 
-	content, err := service.M3UGenerate()
+	content, err := service.M3UGenerate(sub, linkClient(c))
 	if err != nil {
 		log.Println(err)
 		c.AbortWithStatus(http.StatusInternalServerError)
@@ -44,17 +112,16 @@ func M3UHandler(c *gin.Context) {
 }
 
 func TXTHandler(c *gin.Context) {
-	disableProtection := os.Getenv("LIVETV_FREEACCESS") == "1"
-	// verify token against the unique token of the requested channel
-	if !disableProtection {
-		token := c.Query("token")
-		if token != global.GetSecretToken() { // invalid token
-			c.String(http.StatusForbidden, "Forbidden")
-			return
-		}
+	if playbackRefused(c, nil) {
+		return
+	}
+	sub, ok := playlistAccess(c)
+	if !ok {
+		c.String(http.StatusForbidden, "Forbidden")
+		return
 	}
 
-	content, err := service.TXTGenerate()
+	content, err := service.TXTGenerate(sub, linkClient(c))
 	if err != nil {
 		log.Println(err)
 		c.AbortWithStatus(http.StatusInternalServerError)
@@ -69,7 +136,7 @@ func LivePreHandler(c *gin.Context) {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
-	_, err := service.GetChannel(channelNumber, subNumber)
+	ch, err := service.GetChannel(channelNumber, subNumber)
 	if err != nil {
 		if gorm.IsRecordNotFoundError(err) {
 			c.AbortWithStatus(http.StatusNotFound)
@@ -79,6 +146,9 @@ func LivePreHandler(c *gin.Context) {
 		}
 		return
 	}
+	if playbackRefused(c, ch) {
+		return
+	}
 	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", []byte(nil))
 }
 
@@ -110,28 +180,47 @@ func getChannelNumbers(ch string) (chMain int, chSub int) {
 func LiveHandler(c *gin.Context) {
 	channelCacheKey := c.Query("c")
 	channelNumber, subNumber := getChannelNumbers(channelCacheKey)
-	if channelNumber <= 0 { // invalid channel id format
+	ch := requestedChannel(c)
+	if ch == nil { // invalid channel id format or non-existent channel
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
+	if playbackRefused(c, ch) {
+		return
+	}
 
 	disableProtection := os.Getenv("LIVETV_FREEACCESS") == "1"
-	// verify token against the unique token of the requested channel
+	var sub *model.Subscriber
+	// verify token against the unique token of the requested channel, or the token of a subscriber allowed to watch it
 	if !disableProtection {
 		token := c.Query("token")
-		ch, err := service.GetChannel(channelNumber, subNumber)
-		if err != nil { // non-existent channel
-			c.AbortWithError(http.StatusBadRequest, err)
-			return
+		if !service.CheckChannelToken(ch, token) {
+			var ok bool
+			if sub, ok = service.CheckSubscriber(token); !ok || !service.SubscriberAllows(sub, ch) { // invalid token
+				c.String(http.StatusForbidden, "Forbidden")
+				return
+			}
 		}
-		if token != ch.Token { // invalid token
+		if !global.LinkAllowed("live.m3u8", c.Request.URL.Query(), linkClient(c)) { // expired or forged link
 			c.String(http.StatusForbidden, "Forbidden")
 			return
 		}
 	}
 
+	done, err := service.OpenSession(c, channelCacheKey, sub)
+	defer done()
+	if err != nil {
+		sessionRefused(c, err)
+		return
+	}
 	var m3u8Body string
-	iBody, found := global.M3U8Cache.Get(channelCacheKey)
+	playlistCacheKey := channelCacheKey
+	if global.LinkLifetime() > 0 && global.LinkBinding() != global.BindNone {
+		// links in the playlist are bound to the client
+		playlistCacheKey += "|" + c.ClientIP() + "|" + c.Request.UserAgent()
+	}
+	iBody, found := global.M3U8Cache.Get(playlistCacheKey)
+	service.CountCacheLookup(service.M3U8CacheName, found)
 	if found {
 		m3u8Body = iBody.(string)
 	} else {
@@ -215,14 +304,14 @@ func LiveHandler(c *gin.Context) {
 			}
 			iTsTransformer, _ := parser.(service.TsTransformer)
 			// get m3u8 content and transcode into tsproxy link if needed
-			m3u8Body = service.M3U8Process(finalUrl, bodyString, proxyUrl, global.GetLiveToken(), channelInfo.Proxy, channelNumber,
+			m3u8Body = service.M3U8Process(finalUrl, bodyString, proxyUrl, global.GetLiveToken(), linkClient(c), channelInfo.Proxy, channelCacheKey,
 				func(raw string, ts string) string {
 					if iTsTransformer == nil {
 						return ts
 					}
 					return iTsTransformer.TransformTs(raw, ts, liveInfo) // allow plugins to override our default tslink
 				})
-			global.M3U8Cache.Set(channelCacheKey, m3u8Body, 3*time.Second)
+			global.M3U8Cache.Set(playlistCacheKey, m3u8Body, 3*time.Second)
 		}
 	}
 	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
@@ -231,18 +320,27 @@ func LiveHandler(c *gin.Context) {
 }
 
 func M3U8ProxyHandler(c *gin.Context) {
+	channelInfo, ok := relayChannel(c, "playlist.m3u8")
+	if !ok {
+		return
+	}
 	// verify access token if protection is enabled (by default)
 	disableProtection := os.Getenv("LIVETV_FREEACCESS") == "1"
 	if !disableProtection {
 		token := c.Query("token")
-		if token != global.GetLiveToken() {
+		if !global.CheckLiveToken(token) || !global.LinkAllowed("playlist.m3u8", c.Request.URL.Query(), linkClient(c)) {
 			c.String(http.StatusForbidden, "Forbidden")
 			return
 		}
 	}
 
 	zippedRemoteURL := c.Query("k")
-	chNum, chSub := getChannelNumbers(c.Query("c"))
+	done, err := service.OpenSession(c, channelInfo.ChannelID, nil)
+	defer done()
+	if err != nil {
+		sessionRefused(c, err)
+		return
+	}
 	remoteURL, err := util.DecompressString(zippedRemoteURL)
 	if err != nil {
 		log.Println(err)
@@ -260,20 +358,9 @@ func M3U8ProxyHandler(c *gin.Context) {
 		return
 	}
 
-	channelInfo, err := service.GetChannel(chNum, chSub)
-	if err != nil {
-		if gorm.IsRecordNotFoundError(err) {
-			c.AbortWithStatus(http.StatusNotFound)
-		} else {
-			log.Println(err)
-			c.AbortWithStatus(http.StatusInternalServerError)
-		}
-		return
-	}
-
 	client := http.Client{
 		Timeout:   global.HttpClientTimeout,
-		Transport: global.TransportWithProxy(channelInfo.ProxyUrl),
+		Transport: global.GuardedTransport(channelInfo.ProxyUrl),
 		Jar:       global.CookieJar,
 	}
 	req, _ := http.NewRequest(http.MethodGet, remoteURL, nil)
@@ -297,8 +384,7 @@ func M3U8ProxyHandler(c *gin.Context) {
 	req.URL.RawQuery = reqQueries.Encode()
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Println(err)
-		c.AbortWithError(http.StatusInternalServerError, err)
+		upstreamFailed(c, err)
 		return
 	}
 	defer global.CloseBody(resp)
@@ -319,18 +405,22 @@ func M3U8ProxyHandler(c *gin.Context) {
 	io.Copy(buffer, reader)
 	// make prefixURL from ourselves
 	// prefixUrl, _ := global.GetConfig("base_url")
-	newList := service.M3U8Process(remoteURL, buffer.String(), "", global.GetLiveToken(), true, chNum, nil)
+	newList := service.M3U8Process(remoteURL, buffer.String(), "", global.GetLiveToken(), linkClient(c), true, channelInfo.ChannelID, nil)
 	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 	c.Writer.Header().Set("Access-Control-Allow-Methods", "*")
 	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", []byte(newList))
 }
 
 func TsProxyHandler(c *gin.Context) {
+	channelInfo, ok := relayChannel(c, "live.ts")
+	if !ok {
+		return
+	}
 	// verify access token if protection is enabled (by default)
 	disableProtection := os.Getenv("LIVETV_FREEACCESS") == "1"
 	if !disableProtection {
 		token := c.Query("token")
-		if token != global.GetLiveToken() {
+		if !global.CheckLiveToken(token) || !global.LinkAllowed("live.ts", c.Request.URL.Query(), linkClient(c)) {
 			c.String(http.StatusForbidden, "Forbidden")
 			return
 		}
@@ -343,7 +433,12 @@ func TsProxyHandler(c *gin.Context) {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
-	chNum, chSub := getChannelNumbers(c.Query("c"))
+	done, err := service.OpenSession(c, channelInfo.ChannelID, nil)
+	defer done()
+	if err != nil {
+		sessionRefused(c, err)
+		return
+	}
 	if remoteURL == "" {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
@@ -351,21 +446,12 @@ func TsProxyHandler(c *gin.Context) {
 	rurl, err := url.Parse(remoteURL)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
-	}
-	channelInfo, err := service.GetChannel(chNum, chSub)
-	if err != nil {
-		if gorm.IsRecordNotFoundError(err) {
-			c.AbortWithStatus(http.StatusNotFound)
-		} else {
-			log.Println(err)
-			c.AbortWithStatus(http.StatusInternalServerError)
-		}
 		return
 	}
 
 	client := http.Client{
 		Timeout:   global.HttpClientTimeout,
-		Transport: global.TransportWithProxy(channelInfo.ProxyUrl),
+		Transport: global.GuardedTransport(channelInfo.ProxyUrl),
 		Jar:       global.CookieJar,
 	}
 	req := c.Request.Clone(context.Background())
@@ -391,8 +477,7 @@ func TsProxyHandler(c *gin.Context) {
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Println(err)
-		c.AbortWithError(http.StatusInternalServerError, err)
+		upstreamFailed(c, err)
 		return
 	}
 	for key, values := range resp.Header {
@@ -404,15 +489,20 @@ func TsProxyHandler(c *gin.Context) {
 	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 	c.Writer.Header().Set("Access-Control-Allow-Methods", "*")
 	c.Writer.WriteHeader(resp.StatusCode)
-	io.Copy(c.Writer, resp.Body)
+	n, _ := io.Copy(c.Writer, resp.Body)
+	relayedBytes.With("tsproxy", channelInfo.ChannelID).Add(float64(n))
 }
 
 func ReverseProxyHandler(c *gin.Context) {
+	channelInfo, ok := relayChannel(c, "proxy")
+	if !ok {
+		return
+	}
 	// verify access token if protection is enabled (by default)
 	disableProtection := os.Getenv("LIVETV_FREEACCESS") == "1"
 	if !disableProtection {
 		token := c.Query("token")
-		if token != global.GetLiveToken() {
+		if !global.CheckLiveToken(token) || !global.LinkAllowed("proxy", c.Request.URL.Query(), linkClient(c)) {
 			c.String(http.StatusForbidden, "Forbidden")
 			return
 		}
@@ -431,17 +521,37 @@ func ReverseProxyHandler(c *gin.Context) {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	done, err := service.OpenSession(c, channelInfo.ChannelID, nil)
+	defer done()
+	if err != nil {
+		sessionRefused(c, err)
+		return
+	}
 	proxyUrl := c.Query("proxy")
 	// use reverseProxy to proxy the request
 	server := httputil.NewSingleHostReverseProxy(u)
-	if proxyUrl != "" {
-		server.Transport = global.TransportWithProxy(proxyUrl)
-	}
+	server.Transport = global.GuardedTransport(proxyUrl)
 	server.Director = func(req *http.Request) {
 		req.URL = u
 		req.Host = u.Host
 	}
+	server.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		upstreamFailed(c, err)
+	}
 	server.ServeHTTP(c.Writer, c.Request)
+	if n := c.Writer.Size(); n > 0 {
+		relayedBytes.With("proxy", channelInfo.ChannelID).Add(float64(n))
+	}
+}
+
+// reply to a request whose upstream request failed
+func upstreamFailed(c *gin.Context, err error) {
+	if errors.Is(err, global.ErrDestinationBlocked) {
+		c.String(http.StatusForbidden, "Destination not allowed")
+		return
+	}
+	log.Println(err)
+	c.AbortWithError(http.StatusInternalServerError, err)
 }
 
 func CacheHandler(c *gin.Context) {
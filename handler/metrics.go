@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/metrics"
+)
+
+var relayedBytes = metrics.NewCounter("livetv_relayed_bytes_total",
+	"Bytes relayed to clients by the proxies, by proxy and channel.", "handler", "channel")
+
+// whether the client ip is in a comma separated list of ips and networks
+func ipAllowed(ip string, list string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// scrapers are let in with the metrics token or from an allowed address, only local ones when neither is set
+func metricsAccess(c *gin.Context) bool {
+	token, _ := global.GetConfig("metrics_token")
+	allow, _ := global.GetConfig("metrics_allow")
+	if token != "" {
+		given := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if given == "" {
+			given = c.Query("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+			return true
+		}
+	}
+	if allow != "" {
+		return ipAllowed(c.ClientIP(), allow)
+	}
+	if token == "" {
+		ip := net.ParseIP(c.ClientIP())
+		return ip != nil && ip.IsLoopback()
+	}
+	return false
+}
+
+func MetricsHandler(c *gin.Context) {
+	if !metricsAccess(c) {
+		c.String(http.StatusForbidden, "Forbidden")
+		return
+	}
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	metrics.WriteText(c.Writer)
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/service"
+)
+
+// the browser that started a login at the identity provider, the session cookie is strict and doesn't come back from there
+const oidcStateCookie = "livetv_oidc"
+
+// single sign-on settings and their form fields in the v1 api
+var oidcConfigForms = map[string]string{
+	"oidc_issuer":         "oidcissuer",
+	"oidc_client_id":      "oidcclientid",
+	"oidc_client_secret":  "oidcclientsecret",
+	"oidc_scopes":         "oidcscopes",
+	"oidc_groups_claim":   "oidcgroupsclaim",
+	"oidc_allowed_groups": "oidcallowedgroups",
+	"oidc_allowed_emails": "oidcallowedemails",
+	"oidc_role_map":       "oidcrolemap",
+	"oidc_default_role":   "oidcdefaultrole",
+}
+
+func oidcConfigFields(conf *Config) map[string]*string {
+	return map[string]*string{
+		"oidc_issuer":         &conf.OIDCIssuer,
+		"oidc_client_id":      &conf.OIDCClientID,
+		"oidc_client_secret":  &conf.OIDCClientSecret,
+		"oidc_scopes":         &conf.OIDCScopes,
+		"oidc_groups_claim":   &conf.OIDCGroupsClaim,
+		"oidc_allowed_groups": &conf.OIDCAllowedGroups,
+		"oidc_allowed_emails": &conf.OIDCAllowedEmails,
+		"oidc_role_map":       &conf.OIDCRoleMap,
+		"oidc_default_role":   &conf.OIDCDefaultRole,
+	}
+}
+
+// validate the single sign-on settings about to be saved, nil values aren't changed
+func checkOIDCConfig(values map[string]*string) error {
+	if value := values["oidc_role_map"]; value != nil {
+		if _, err := service.ParseOIDCRoleMap(*value); err != nil {
+			return err
+		}
+	}
+	if value := values["oidc_default_role"]; value != nil {
+		return service.CheckOIDCRole(*value)
+	}
+	return nil
+}
+
+// where the identity provider sends the browser back to, it has to be registered there
+func oidcRedirectURL() string {
+	baseUrl, _ := global.GetConfig("base_url")
+	return baseUrl + "/api/oidc/callback"
+}
+
+// OIDCInfoHandler tells the login page whether to offer single sign-on
+func OIDCInfoHandler(c *gin.Context) {
+	enabled := service.OIDCEnabled()
+	info := gin.H{"enabled": enabled}
+	if enabled {
+		info["login"] = "/api/oidc/login"
+		info["redirect"] = oidcRedirectURL()
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// OIDCLoginHandler sends the browser to the identity provider
+func OIDCLoginHandler(c *gin.Context) {
+	login, err := service.BeginOIDCLogin(c.Request.Context(), oidcRedirectURL())
+	if err != nil {
+		if errors.Is(err, service.ErrOIDCDisabled) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		log.Println("oidc:", err)
+		c.String(http.StatusBadGateway, "The identity provider can't be reached")
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, login.State, 600, "/api/oidc", "", false, true)
+	c.Redirect(http.StatusFound, login.URL)
+}
+
+// OIDCCallbackHandler logs in the user the identity provider sent back
+func OIDCCallbackHandler(c *gin.Context) {
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, "/api/oidc", "", false, true)
+	if msg := c.Query("error"); msg != "" {
+		if desc := c.Query("error_description"); desc != "" {
+			msg += ": " + desc
+		}
+		c.String(http.StatusForbidden, "Login failed: %s", msg)
+		return
+	}
+	if state == "" || cookie != state {
+		c.String(http.StatusBadRequest, service.ErrOIDCState.Error())
+		return
+	}
+	user, err := service.FinishOIDCLogin(c.Request.Context(), oidcRedirectURL(), state, c.Query("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrOIDCState), errors.Is(err, service.ErrOIDCDisabled):
+			c.String(http.StatusBadRequest, err.Error())
+		case errors.Is(err, service.ErrOIDCNotAllowed), errors.Is(err, service.ErrOIDCNameTaken):
+			service.PublishLogin(false, "single sign-on", c.ClientIP())
+			c.String(http.StatusForbidden, err.Error())
+		default:
+			log.Println("oidc:", err)
+			c.String(http.StatusBadGateway, "Login failed: the identity provider's answer couldn't be verified")
+		}
+		return
+	}
+	session := sessions.Default(c)
+	if err := startSession(session, user.ID); err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	service.PublishLogin(true, user.Name, c.ClientIP())
+	service.UserLoggedIn(user)
+	c.Redirect(http.StatusFound, "/")
+}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/service"
+)
+
+// parse a source without adding it as a channel and report every step
+func ParseTestHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleEditor) {
+		return
+	}
+	channel := &model.Channel{
+		Name:     "test",
+		URL:      global.CleanString(c.PostForm("url")),
+		Parser:   global.CleanString(c.PostForm("parser")),
+		ProxyUrl: global.CleanString(c.PostForm("proxyurl")),
+		Extra:    c.PostForm("extra"),
+	}
+	if channel.URL == "" {
+		c.String(http.StatusBadRequest, "Incomplete channel info")
+		return
+	}
+	if channel.Parser == "" {
+		channel.Parser = "auto"
+	}
+	if _, err := service.GetPlugin(channel.Parser); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, service.ParseTest(channel))
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/service"
+)
+
+func PlaylistFilesHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleViewer) {
+		return
+	}
+	files, err := service.ListPlaylistFiles()
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+// store an uploaded m3u/txt playlist in the data dir, the returned file:// url can be used as a channel url
+func UploadPlaylistHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleEditor) {
+		return
+	}
+	fh, err := c.FormFile("file")
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	name := c.PostForm("name")
+	if name == "" {
+		name = fh.Filename
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	defer f.Close()
+	fileUrl, err := service.SavePlaylistFile(name, f)
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	logAction(c, "uploaded playlist %s", name)
+	c.String(http.StatusOK, fileUrl)
+}
+
+func DeletePlaylistFileHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleEditor) {
+		return
+	}
+	err := service.DeletePlaylistFile(c.Query("name"))
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	logAction(c, "deleted playlist %s", c.Query("name"))
+	c.String(http.StatusOK, "")
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/service"
+)
+
+const (
+	sessionFeedInterval  = 2 * time.Second
+	sessionFeedKeepAlive = 30 * time.Second
+)
+
+func SessionListHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleViewer) {
+		return
+	}
+	c.JSON(http.StatusOK, service.GetSessions())
+}
+
+// send the sessions as server sent events whenever they change
+func streamSessions(c *gin.Context) {
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	ticker := time.NewTicker(sessionFeedInterval)
+	defer ticker.Stop()
+	var last []byte
+	lastSent := time.Time{}
+	c.Stream(func(w io.Writer) bool {
+		report, err := json.Marshal(service.GetSessions())
+		if err != nil {
+			log.Println(err.Error())
+			return false
+		}
+		if !bytes.Equal(report, last) {
+			c.SSEvent("sessions", json.RawMessage(report))
+			last = report
+			lastSent = time.Now()
+		} else if time.Since(lastSent) > sessionFeedKeepAlive {
+			io.WriteString(w, ": keepalive\n\n")
+			lastSent = time.Now()
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-ticker.C:
+			return true
+		}
+	})
+}
+
+func SessionEventsHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleViewer) {
+		return
+	}
+	streamSessions(c)
+}
+
+func KillSessionHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleEditor) {
+		return
+	}
+	id := c.Query("id")
+	if id == "" {
+		c.String(http.StatusBadRequest, "empty id")
+		return
+	}
+	if err := service.KillSession(id); err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	logAction(c, "killed session %s", id)
+	c.String(http.StatusOK, "")
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/service"
+)
+
+func toSubscriberInfo(sub *model.Subscriber, baseUrl string) SubscriberInfo {
+	return SubscriberInfo{
+		ID:         sub.ID,
+		Name:       sub.Name,
+		Token:      sub.Token,
+		M3U:        baseUrl + "/lives.m3u?token=" + sub.Token,
+		TXT:        baseUrl + "/lives.txt?token=" + sub.Token,
+		Expires:    sub.Expires,
+		Expired:    sub.Expires != nil && time.Now().After(*sub.Expires),
+		MaxStreams: sub.MaxStreams,
+		Devices:    service.SubscriberDevices(sub.ID),
+		Categories: sub.Categories,
+		Channels:   sub.Channels,
+		Disabled:   sub.Disabled,
+		Created:    sub.Created,
+	}
+}
+
+// expiry dates are posted as 2006-01-02 (the end of that day) or RFC 3339, empty for none
+func parseExpiry(value string) (*time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		t = t.AddDate(0, 0, 1).Add(-time.Second)
+		return &t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errors.New("invalid expiry date")
+	}
+	return &t, nil
+}
+
+func SubscriberListHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleOwner) {
+		return
+	}
+	subs, err := service.GetSubscribers()
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	baseUrl, _ := global.GetConfig("base_url")
+	list := make([]SubscriberInfo, 0, len(subs))
+	for i := range subs {
+		list = append(list, toSubscriberInfo(&subs[i], baseUrl))
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func SaveSubscriberHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleOwner) {
+		return
+	}
+	sub := &model.Subscriber{}
+	if id, _ := strconv.Atoi(c.PostForm("id")); id > 0 {
+		var err error
+		if sub, err = service.GetSubscriber(id); err != nil {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+	}
+	expires, err := parseExpiry(c.PostForm("expires"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	sub.Name = c.PostForm("name")
+	sub.Expires = expires
+	sub.MaxStreams, _ = strconv.Atoi(c.PostForm("maxstreams"))
+	sub.Categories = strings.TrimSpace(c.PostForm("categories"))
+	sub.Channels = global.CleanString(c.PostForm("channels"))
+	sub.Disabled = c.PostForm("disabled") == "true"
+	if err = service.SaveSubscriber(sub, c.PostForm("resettoken") == "true"); err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	logAction(c, "saved subscriber %d %s", sub.ID, sub.Name)
+	baseUrl, _ := global.GetConfig("base_url")
+	c.JSON(http.StatusOK, toSubscriberInfo(sub, baseUrl))
+}
+
+func DeleteSubscriberHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleOwner) {
+		return
+	}
+	id, _ := strconv.Atoi(c.Query("id"))
+	if id == 0 {
+		c.String(http.StatusBadRequest, "empty id")
+		return
+	}
+	if err := service.DeleteSubscriber(id); err != nil {
+		if errors.Is(err, service.ErrSubscriberNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	logAction(c, "deleted subscriber %d", id)
+	c.String(http.StatusOK, "")
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/service"
+)
+
+var exportContentTypes = map[string]string{
+	service.FormatJSON: "application/json",
+	service.FormatM3U:  "audio/x-mpegurl",
+	service.FormatTXT:  "text/plain; charset=UTF-8",
+}
+
+func ExportChannelsHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleEditor) {
+		return
+	}
+	format := c.DefaultQuery("format", service.FormatJSON)
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		c.String(http.StatusBadRequest, "unknown format")
+		return
+	}
+	content, err := service.ExportChannels(format)
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=livetv-channels."+format)
+	c.Data(http.StatusOK, contentType, []byte(content))
+}
+
+// import channels from an uploaded file or a posted content field
+func ImportChannelsHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleEditor) {
+		return
+	}
+	var content []byte
+	if fh, err := c.FormFile("file"); err == nil {
+		f, err := fh.Open()
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		defer f.Close()
+		content, err = io.ReadAll(io.LimitReader(f, service.MaxPlaylistSize+1))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	} else {
+		content = []byte(c.PostForm("content"))
+	}
+	if len(content) > service.MaxPlaylistSize {
+		c.String(http.StatusRequestEntityTooLarge, "import too large")
+		return
+	}
+	if len(content) == 0 {
+		c.String(http.StatusBadRequest, "empty import")
+		return
+	}
+	format := global.CleanString(c.PostForm("format"))
+	parser := global.CleanString(c.DefaultPostForm("parser", "auto"))
+	conflict := global.CleanString(c.PostForm("conflict"))
+	dryRun := c.PostForm("dryrun") == "true"
+	result, err := service.ImportChannels(content, format, parser, conflict, dryRun)
+	if err != nil {
+		log.Println(err.Error())
+		if result == nil {
+			c.String(http.StatusBadRequest, err.Error())
+		} else {
+			c.String(http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if !dryRun {
+		logAction(c, "imported channels")
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// download a consistent backup archive, secrets=false leaves passwords and secrets out
+func BackupHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleOwner) {
+		return
+	}
+	secrets := c.DefaultQuery("secrets", "true") == "true"
+	var buf bytes.Buffer
+	if err := service.WriteBackup(&buf, secrets); err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	logAction(c, "downloaded a backup")
+	c.Header("Content-Disposition", "attachment; filename=livetv-"+time.Now().Format("20060102-150405")+".zip")
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
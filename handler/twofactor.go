@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/service"
+)
+
+func twoFactorError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidOTP):
+		c.String(http.StatusForbidden, err.Error())
+	case errors.Is(err, service.ErrTwoFactorNotStarted), errors.Is(err, service.ErrTwoFactorDisabled):
+		c.String(http.StatusBadRequest, err.Error())
+	default:
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+	}
+}
+
+func TwoFactorStatusHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleViewer) {
+		return
+	}
+	c.JSON(http.StatusOK, service.GetTwoFactorStatus(currentUser(c)))
+}
+
+// EnrollTwoFactorHandler starts setting up an authenticator app, 2fa is enabled once a code of it is confirmed
+func EnrollTwoFactorHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleViewer) {
+		return
+	}
+	user := currentUser(c)
+	if user.TOTPSecret != "" {
+		c.String(http.StatusConflict, "Two-factor authentication is enabled already")
+		return
+	}
+	enrolment, err := service.BeginTwoFactor(user)
+	if err != nil {
+		twoFactorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, enrolment)
+}
+
+// ConfirmTwoFactorHandler enables 2fa and returns the recovery codes, they are shown only this once
+func ConfirmTwoFactorHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleViewer) {
+		return
+	}
+	codes, err := service.ConfirmTwoFactor(currentUser(c), c.PostForm("code"))
+	if err != nil {
+		twoFactorError(c, err)
+		return
+	}
+	logAction(c, "turned on two-factor authentication")
+	c.JSON(http.StatusOK, codes)
+}
+
+// RecoveryCodesHandler replaces the recovery codes of the user, a current code is needed
+func RecoveryCodesHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleViewer) {
+		return
+	}
+	user := currentUser(c)
+	if !service.CheckTwoFactor(user, c.PostForm("code")) {
+		twoFactorError(c, service.ErrInvalidOTP)
+		return
+	}
+	codes, err := service.NewRecoveryCodes(user)
+	if err != nil {
+		twoFactorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, codes)
+}
+
+// DisableTwoFactorHandler turns 2fa of the user off, a current code is needed
+func DisableTwoFactorHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleViewer) {
+		return
+	}
+	user := currentUser(c)
+	if !service.CheckTwoFactor(user, c.PostForm("code")) {
+		twoFactorError(c, service.ErrInvalidOTP)
+		return
+	}
+	if err := service.DisableTwoFactor(user); err != nil {
+		twoFactorError(c, err)
+		return
+	}
+	logAction(c, "turned off two-factor authentication")
+	c.String(http.StatusOK, "")
+}
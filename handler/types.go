@@ -1,5 +1,13 @@
 package handler
 
+import (
+	"time"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/service"
+)
+
 type Channel struct {
 	ID         string
 	Name       string
@@ -15,7 +23,10 @@ type Channel struct {
 	Category   string
 	Virtual    bool
 	Extra      string
-	Children   []Channel `json:"children"`
+	Logo       string
+	Identity   string
+	Uptime     *service.UptimeSummary // over the last days, nil when nothing has been recorded yet
+	Children   []Channel              `json:"children"`
 }
 
 type Config struct {
@@ -25,4 +36,233 @@ type Config struct {
 	ApiKey   string `json:"apikey"`
 	Secret   string `json:"secret"`
 	ProxyURL string `json:"proxyurl"`
+	// scheduled backup interval in hours (0 to disable) and number of backups to keep
+	BackupInterval string `json:"backupinterval"`
+	BackupKeep     string `json:"backupkeep"`
+	// bearer token and comma separated ips or networks allowed to scrape /metrics
+	MetricsToken string `json:"metricstoken"`
+	MetricsAllow string `json:"metricsallow"`
+	// signed playback links: lifetime in hours (0 to disable), binding (empty, ip or session) and whether unsigned links still work
+	LinkLifetime string `json:"linklifetime"`
+	LinkBinding  string `json:"linkbinding"`
+	LinkLegacy   string `json:"linklegacy"`
+	// hours tokens of the previous secret keep working after the secret is changed, and the state of the last rotation
+	SecretGrace string                `json:"secretgrace"`
+	Rotation    global.RotationStatus `json:"rotation"`
+	// single sign-on: the provider, the client registered there, who may log in and the roles of groups as group=role pairs
+	OIDCIssuer        string `json:"oidcissuer"`
+	OIDCClientID      string `json:"oidcclientid"`
+	OIDCClientSecret  string `json:"oidcclientsecret"`
+	OIDCScopes        string `json:"oidcscopes"`
+	OIDCGroupsClaim   string `json:"oidcgroupsclaim"`
+	OIDCAllowedGroups string `json:"oidcallowedgroups"`
+	OIDCAllowedEmails string `json:"oidcallowedemails"`
+	OIDCRoleMap       string `json:"oidcrolemap"`
+	OIDCDefaultRole   string `json:"oidcdefaultrole"`
+	// where fetch and the proxies may connect to, comma separated networks and hosts, and whether hosts of channels are trusted
+	DestAllow         string `json:"destallow"`
+	DestDeny          string `json:"destdeny"`
+	DestTrustChannels string `json:"desttrustchannels"`
+	// MaxMind DB file the countries of the access policies are looked up in
+	GeoIPDB string `json:"geoipdb"`
+}
+
+type FilterPreview struct {
+	Total   int              `json:"total"`
+	Kept    []*model.Channel `json:"kept"`
+	Dropped []*model.Channel `json:"dropped"`
+}
+
+// APIError is the error body of all v2 api responses
+type APIError struct {
+	Error APIErrorInfo `json:"error"`
+}
+
+type APIErrorInfo struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ChannelStatus struct {
+	Status      string    `json:"status"`
+	Category    string    `json:"category,omitempty"`
+	Message     string    `json:"message"`
+	ResolvedURL string    `json:"resolvedurl,omitempty"`
+	Plugin      string    `json:"plugin,omitempty"`
+	Updated     time.Time `json:"updated"`
+}
+
+// ChannelV2 is a channel or sub channel in the v2 api
+type ChannelV2 struct {
+	ID       string                 `json:"id"`
+	Name     string                 `json:"name"`
+	URL      string                 `json:"url"`
+	M3U8     string                 `json:"m3u8"`
+	Parser   string                 `json:"parser"`
+	Proxy    bool                   `json:"proxy"`
+	ProxyUrl string                 `json:"proxyurl"`
+	TsProxy  string                 `json:"tsproxy"`
+	Category string                 `json:"category"`
+	Logo     string                 `json:"logo"`
+	Extra    string                 `json:"extra"`
+	Identity string                 `json:"identity,omitempty"`
+	Virtual  bool                   `json:"virtual"`
+	Status   ChannelStatus          `json:"status"`
+	Uptime   *service.UptimeSummary `json:"uptime,omitempty"`
+	Children []ChannelV2            `json:"children,omitempty"`
+}
+
+// ChannelInput is the request body to create or update a channel.
+// Sub channels only take name, category, logo and hidden, which are saved as overrides.
+type ChannelInput struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Parser   string `json:"parser"`
+	Proxy    bool   `json:"proxy"`
+	ProxyUrl string `json:"proxyurl"`
+	TsProxy  string `json:"tsproxy"`
+	Category string `json:"category"`
+	Logo     string `json:"logo"`
+	Extra    string `json:"extra"`
+	Hidden   bool   `json:"hidden"`
+}
+
+// ConfigInput is the request body to update settings, fields left out are not changed
+type ConfigInput struct {
+	BaseURL        *string `json:"baseurl"`
+	Cmd            *string `json:"cmd"`
+	Args           *string `json:"args"`
+	ApiKey         *string `json:"apikey"`
+	Secret         *string `json:"secret"`
+	BackupInterval *string `json:"backupinterval"`
+	BackupKeep     *string `json:"backupkeep"`
+	MetricsToken   *string `json:"metricstoken"`
+	MetricsAllow   *string `json:"metricsallow"`
+	LinkLifetime   *string `json:"linklifetime"`
+	LinkBinding    *string `json:"linkbinding"`
+	LinkLegacy     *string `json:"linklegacy"`
+	SecretGrace    *string `json:"secretgrace"`
+
+	OIDCIssuer        *string `json:"oidcissuer"`
+	OIDCClientID      *string `json:"oidcclientid"`
+	OIDCClientSecret  *string `json:"oidcclientsecret"`
+	OIDCScopes        *string `json:"oidcscopes"`
+	OIDCGroupsClaim   *string `json:"oidcgroupsclaim"`
+	OIDCAllowedGroups *string `json:"oidcallowedgroups"`
+	OIDCAllowedEmails *string `json:"oidcallowedemails"`
+	OIDCRoleMap       *string `json:"oidcrolemap"`
+	OIDCDefaultRole   *string `json:"oidcdefaultrole"`
+
+	DestAllow         *string `json:"destallow"`
+	DestDeny          *string `json:"destdeny"`
+	DestTrustChannels *string `json:"desttrustchannels"`
+
+	GeoIPDB *string `json:"geoipdb"`
+}
+
+type APIKeyInfo struct {
+	ID       int        `json:"id"`
+	Name     string     `json:"name"`
+	Prefix   string     `json:"prefix"`
+	Role     string     `json:"role"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"lastused"`
+	Revoked  bool       `json:"revoked"`
+	Key      string     `json:"key,omitempty"` // only returned on creation
+}
+
+type ChannelStatusEntry struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	ChannelStatus
+}
+
+// StatusReport counts channels by status and lists the status of every channel
+type StatusReport struct {
+	Summary  map[string]int       `json:"summary"`
+	Channels []ChannelStatusEntry `json:"channels"`
+}
+
+// WebhookInfo is a webhook as shown to the admin, without its token
+type WebhookInfo struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	URL       string `json:"url"`
+	HasToken  bool   `json:"hastoken"`
+	ChatID    string `json:"chatid"`
+	Template  string `json:"template"`
+	Events    string `json:"events"`
+	Channels  string `json:"channels"`
+	RateLimit int    `json:"ratelimit"`
+	Enabled   bool   `json:"enabled"`
+}
+
+// SubscriberInfo is a subscriber with the links to their playlists
+type SubscriberInfo struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token"`
+	M3U        string     `json:"m3u"`
+	TXT        string     `json:"txt"`
+	Expires    *time.Time `json:"expires"`
+	Expired    bool       `json:"expired"`
+	MaxStreams int        `json:"maxstreams"`
+	Devices    int        `json:"devices"` // devices playing right now
+	Categories string     `json:"categories"`
+	Channels   string     `json:"channels"`
+	Disabled   bool       `json:"disabled"`
+	Created    time.Time  `json:"created"`
+}
+
+// AccessPolicyInfo is a playback access policy, also the body to save one
+type AccessPolicyInfo struct {
+	ID            int    `json:"id"`
+	Scope         string `json:"scope"`  // global, category or channel
+	Target        string `json:"target"` // the category or the channel id
+	Allow         string `json:"allow"`
+	Deny          string `json:"deny"`
+	Countries     string `json:"countries"`
+	DenyCountries string `json:"denycountries"`
+}
+
+// AccessCheck tells whether an address may play, and which policy refuses it
+type AccessCheck struct {
+	IP      string `json:"ip"`
+	Country string `json:"country"` // empty when it isn't known
+	Allowed bool   `json:"allowed"`
+	Policy  string `json:"policy,omitempty"`
+}
+
+// UserInfo is an admin account, without its credentials
+type UserInfo struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Role      string     `json:"role"`
+	TwoFactor bool       `json:"twofactor"`
+	SSO       bool       `json:"sso"`
+	Disabled  bool       `json:"disabled"`
+	Created   time.Time  `json:"created"`
+	LastLogin *time.Time `json:"lastlogin"`
+}
+
+// AuditEntryInfo is an entry of the audit log with the fields it changed
+type AuditEntryInfo struct {
+	ID         int                   `json:"id"`
+	Time       time.Time             `json:"time"`
+	Actor      string                `json:"actor"`
+	IP         string                `json:"ip"`
+	Action     string                `json:"action"`
+	Target     string                `json:"target"`
+	Changes    []service.AuditChange `json:"changes"`
+	Revertible bool                  `json:"revertible"`
+}
+
+// AuditPage is a page of the audit log, newest first
+type AuditPage struct {
+	Entries []AuditEntryInfo `json:"entries"`
+	Total   int              `json:"total"`
+	Page    int              `json:"page"`
+	Limit   int              `json:"limit"`
 }
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/service"
+)
+
+// the user of a logged in session, nil when nobody is logged in or the user has been disabled or deleted
+func currentUser(c *gin.Context) *model.User {
+	if user, ok := c.Get("user"); ok {
+		return user.(*model.User)
+	}
+	session := sessions.Default(c)
+	if session.Get("logined") != true {
+		return nil
+	}
+	id, ok := session.Get("user").(int)
+	if !ok {
+		return nil
+	}
+	user, err := service.GetUser(id)
+	if err != nil || user.Disabled {
+		return nil
+	}
+	c.Set("user", user)
+	return user
+}
+
+// requireRole lets a request through when its user has the role or a higher one, it replies to the request otherwise
+func requireRole(c *gin.Context, role string) bool {
+	user := currentUser(c)
+	if user == nil {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return false
+	}
+	if !service.RoleAllows(user.Role, role) {
+		c.String(http.StatusForbidden, "Forbidden")
+		return false
+	}
+	return true
+}
+
+// who is making a request, for the logs
+func actor(c *gin.Context) string {
+	if user, ok := c.Get("user"); ok {
+		return user.(*model.User).Name
+	}
+	if key, ok := c.Get("apikey"); ok {
+		return "api key " + key.(*model.APIKey).Name
+	}
+	return "anonymous"
+}
+
+// logAction logs a change along with who made it
+func logAction(c *gin.Context, format string, args ...any) {
+	log.Printf("[%s] %s\n", actor(c), fmt.Sprintf(format, args...))
+}
+
+func toUserInfo(user *model.User) UserInfo {
+	return UserInfo{
+		ID:        user.ID,
+		Name:      user.Name,
+		Role:      user.Role,
+		TwoFactor: user.TOTPSecret != "",
+		SSO:       user.OIDCSubject != "",
+		Disabled:  user.Disabled,
+		Created:   user.Created,
+		LastLogin: user.LastLogin,
+	}
+}
+
+func userError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		c.String(http.StatusNotFound, err.Error())
+	case errors.Is(err, service.ErrUserExists), errors.Is(err, service.ErrLastOwner):
+		c.String(http.StatusConflict, err.Error())
+	default:
+		log.Println(err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+	}
+}
+
+// MeHandler tells the web ui who is logged in
+func MeHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleViewer) {
+		return
+	}
+	c.JSON(http.StatusOK, toUserInfo(currentUser(c)))
+}
+
+func UserListHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleOwner) {
+		return
+	}
+	users, err := service.GetUsers()
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	list := make([]UserInfo, 0, len(users))
+	for i := range users {
+		list = append(list, toUserInfo(&users[i]))
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// SaveUserHandler creates a user, or updates the one of the posted id. An empty password keeps the current one.
+func SaveUserHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleOwner) {
+		return
+	}
+	user := &model.User{}
+	var before *UserInfo
+	if idText := c.PostForm("id"); idText != "" {
+		id, err := strconv.Atoi(idText)
+		if err != nil {
+			c.String(http.StatusBadRequest, "invalid id")
+			return
+		}
+		if user, err = service.GetUser(id); err != nil {
+			userError(c, err)
+			return
+		}
+		info := toUserInfo(user)
+		before = &info
+	}
+	user.Name = global.CleanString(c.PostForm("name"))
+	user.Role = c.PostForm("role")
+	user.Disabled = c.PostForm("disabled") == "true"
+	if err := service.SaveUser(user, c.PostForm("password")); err != nil {
+		userError(c, err)
+		return
+	}
+	logAction(c, "saved user %s (%s)", user.Name, user.Role)
+	after := toUserInfo(user)
+	recordAudit(c, service.AuditUserSave, service.AuditUserTarget(user.Name), before, after)
+	c.JSON(http.StatusOK, after)
+}
+
+func DeleteUserHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleOwner) {
+		return
+	}
+	id, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid id")
+		return
+	}
+	user, err := service.GetUser(id)
+	if err != nil {
+		userError(c, err)
+		return
+	}
+	if err := service.DeleteUser(id); err != nil {
+		userError(c, err)
+		return
+	}
+	logAction(c, "deleted user %d", id)
+	recordAudit(c, service.AuditUserDelete, service.AuditUserTarget(user.Name), toUserInfo(user), nil)
+	c.String(http.StatusOK, "")
+}
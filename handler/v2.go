@@ -0,0 +1,647 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/service"
+)
+
+func apiError(c *gin.Context, status int, code string, message string) {
+	c.AbortWithStatusJSON(status, APIError{APIErrorInfo{Code: code, Message: message}})
+}
+
+func apiInternalError(c *gin.Context, err error) {
+	log.Println(err.Error())
+	apiError(c, http.StatusInternalServerError, "internal_error", err.Error())
+}
+
+// api keys are sent as a bearer token or in the X-API-Key header
+func requestAPIKey(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return strings.TrimSpace(c.GetHeader("X-API-Key"))
+}
+
+// role a logged in user needs for a v2 request: settings and keys are for owners, changes for editors
+func v2Role(c *gin.Context) string {
+	path := strings.TrimPrefix(c.FullPath(), "/api/v2")
+	if strings.HasPrefix(path, "/config") || strings.HasPrefix(path, "/keys") || strings.HasPrefix(path, "/access") {
+		return model.RoleOwner
+	}
+	if c.Request.Method == http.MethodGet && !strings.HasPrefix(path, "/audit") {
+		return model.RoleViewer
+	}
+	return model.RoleEditor
+}
+
+// APIAuthMiddleware lets requests with a valid api key, or of a logged in user with the needed role, through
+func APIAuthMiddleware(c *gin.Context) {
+	if key := requestAPIKey(c); key != "" {
+		row, ok := service.CheckAPIKey(key)
+		if !ok {
+			apiError(c, http.StatusUnauthorized, "invalid_api_key", "API key is invalid or has been revoked")
+			return
+		}
+		if !service.RoleAllows(row.Role, v2Role(c)) {
+			apiError(c, http.StatusForbidden, "forbidden", "The role of this API key doesn't allow this")
+			return
+		}
+		c.Set("apikey", row)
+		c.Next()
+		return
+	}
+	user := currentUser(c)
+	if user == nil {
+		apiError(c, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+	if !service.RoleAllows(user.Role, v2Role(c)) {
+		apiError(c, http.StatusForbidden, "forbidden", "Your role doesn't allow this")
+		return
+	}
+	c.Next()
+}
+
+func bindJSON(c *gin.Context, v any) bool {
+	if err := c.ShouldBindJSON(v); err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_body", err.Error())
+		return false
+	}
+	return true
+}
+
+func channelStatus(url string) ChannelStatus {
+	status := service.GetStatus(url)
+	return ChannelStatus{
+		Status:      service.StatusNames[status.Status],
+		Category:    status.Category,
+		Message:     status.Msg,
+		ResolvedURL: status.ResolvedURL,
+		Plugin:      status.Plugin,
+		Updated:     status.Time,
+	}
+}
+
+func uptimeSummary(url string) *service.UptimeSummary {
+	if summary, ok := service.GetUptimeSummary(url); ok {
+		return &summary
+	}
+	return nil
+}
+
+func toChannelV2(baseUrl string, ch *model.Channel, client global.LinkClient) ChannelV2 {
+	m3u8 := baseUrl + "/live.m3u8?" + service.LiveQuery(ch.Token, ch.ChannelID, client)
+	if ch.CustomQueryString != "" {
+		m3u8 = m3u8 + "&" + ch.CustomQueryString
+	}
+	result := ChannelV2{
+		ID:       ch.ChannelID,
+		Name:     ch.Name,
+		URL:      ch.URL,
+		M3U8:     m3u8,
+		Parser:   ch.Parser,
+		Proxy:    ch.Proxy,
+		ProxyUrl: ch.ProxyUrl,
+		TsProxy:  ch.TsProxy,
+		Category: ch.Category,
+		Logo:     ch.Logo,
+		Extra:    ch.Extra,
+		Identity: ch.Identity,
+		Virtual:  ch.ParentID != ch.ChannelID,
+		Status:   channelStatus(ch.URL),
+		Uptime:   uptimeSummary(ch.URL),
+	}
+	for _, sub := range ch.Children {
+		result.Children = append(result.Children, toChannelV2(baseUrl, sub, client))
+	}
+	return result
+}
+
+// find the channel in the id path param, primary channels come with their sub channels
+func lookupChannel(c *gin.Context) (*model.Channel, bool) {
+	chID, chSubId := getChannelNumbers(c.Param("id"))
+	if chID <= 0 {
+		apiError(c, http.StatusBadRequest, "invalid_id", "invalid channel id")
+		return nil, false
+	}
+	var ch *model.Channel
+	var err error
+	if chSubId >= 0 {
+		ch, err = service.GetChannel(chID, chSubId)
+	} else {
+		ch, err = service.GetChannelTree(chID)
+	}
+	if err != nil {
+		apiError(c, http.StatusNotFound, "not_found", err.Error())
+		return nil, false
+	}
+	return ch, true
+}
+
+func isSubChannel(ch *model.Channel) bool {
+	return ch.ParentID != ch.ChannelID
+}
+
+// validate and copy the input into a primary channel
+func applyChannelInput(c *gin.Context, ch *model.Channel, in *ChannelInput) bool {
+	in.Name = strings.TrimSpace(in.Name)
+	in.URL = global.CleanString(in.URL)
+	in.Parser = global.CleanString(in.Parser)
+	if in.Name == "" || in.URL == "" {
+		apiError(c, http.StatusBadRequest, "incomplete_channel", "Incomplete channel info")
+		return false
+	}
+	if _, err := service.GetPlugin(in.Parser); err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_parser", err.Error())
+		return false
+	}
+	ch.Name = in.Name
+	ch.URL = in.URL
+	ch.Parser = in.Parser
+	ch.Proxy = in.Proxy
+	ch.ProxyUrl = global.CleanString(in.ProxyUrl)
+	ch.TsProxy = global.CleanString(in.TsProxy)
+	ch.Category = global.CleanString(in.Category)
+	ch.Logo = global.CleanString(in.Logo)
+	ch.Extra = in.Extra
+	ch.HasSubChannel = service.ProvidesSubChannels(ch.Parser)
+	return true
+}
+
+// reply with the current state of a primary channel
+func replyChannel(c *gin.Context, status int, chID int) {
+	baseUrl, _ := global.GetConfig("base_url")
+	ch, err := service.GetChannelTree(chID)
+	if err != nil {
+		apiError(c, http.StatusNotFound, "not_found", err.Error())
+		return
+	}
+	c.JSON(status, toChannelV2(baseUrl, ch, linkClient(c)))
+}
+
+func V2ListChannelsHandler(c *gin.Context) {
+	baseUrl, _ := global.GetConfig("base_url")
+	channels, err := service.GetAllChannel()
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	list := make([]ChannelV2, 0, len(channels))
+	for _, ch := range channels {
+		list = append(list, toChannelV2(baseUrl, ch, linkClient(c)))
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func V2GetChannelHandler(c *gin.Context) {
+	ch, ok := lookupChannel(c)
+	if !ok {
+		return
+	}
+	baseUrl, _ := global.GetConfig("base_url")
+	c.JSON(http.StatusOK, toChannelV2(baseUrl, ch, linkClient(c)))
+}
+
+func V2CreateChannelHandler(c *gin.Context) {
+	var in ChannelInput
+	if !bindJSON(c, &in) {
+		return
+	}
+	mch := &model.Channel{}
+	if !applyChannelInput(c, mch, &in) {
+		return
+	}
+	if err := service.SaveChannel(mch); err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	logAction(c, "added channel %d %s", mch.ID, mch.Name)
+	recordAudit(c, service.AuditChannelCreate, service.AuditChannelTarget(strconv.Itoa(mch.ID)), nil, service.ChannelSnapshot(mch))
+	go service.UpdateURLCacheSingle(mch, true)
+	replyChannel(c, http.StatusCreated, mch.ID)
+}
+
+func V2UpdateChannelHandler(c *gin.Context) {
+	ch, ok := lookupChannel(c)
+	if !ok {
+		return
+	}
+	var in ChannelInput
+	if !bindJSON(c, &in) {
+		return
+	}
+	if isSubChannel(ch) {
+		after := &model.Channel{Name: strings.TrimSpace(in.Name), Category: global.CleanString(in.Category), Logo: global.CleanString(in.Logo)}
+		err := service.SaveChannelOverride(ch, after.Name, after.Category, after.Logo, in.Hidden)
+		if err != nil {
+			apiError(c, http.StatusBadRequest, "invalid_override", err.Error())
+			return
+		}
+		logAction(c, "updated sub channel %s %s", ch.ChannelID, in.Name)
+		recordAudit(c, service.AuditSubChannelUpdate, service.AuditChannelTarget(ch.ChannelID), subChannelSnapshot(ch, false), subChannelSnapshot(after, in.Hidden))
+		chID, chSubId := getChannelNumbers(ch.ChannelID)
+		sub, err := service.GetChannel(chID, chSubId)
+		if err != nil {
+			// the sub channel has been hidden
+			c.Status(http.StatusNoContent)
+			return
+		}
+		baseUrl, _ := global.GetConfig("base_url")
+		c.JSON(http.StatusOK, toChannelV2(baseUrl, sub, linkClient(c)))
+		return
+	}
+	before := service.ChannelSnapshot(ch)
+	if !applyChannelInput(c, ch, &in) {
+		return
+	}
+	if err := service.SaveChannel(ch); err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	logAction(c, "updated channel %d %s", ch.ID, ch.Name)
+	recordAudit(c, service.AuditChannelUpdate, service.AuditChannelTarget(strconv.Itoa(ch.ID)), before, service.ChannelSnapshot(ch))
+	go service.UpdateURLCacheSingle(ch, true)
+	replyChannel(c, http.StatusOK, ch.ID)
+}
+
+// deleting a sub channel hides it, as it would come back with the next parse of its provider
+func V2DeleteChannelHandler(c *gin.Context) {
+	ch, ok := lookupChannel(c)
+	if !ok {
+		return
+	}
+	var err error
+	if isSubChannel(ch) {
+		err = service.HideSubChannel(ch)
+	} else {
+		err = deleteChannel(ch.ID)
+	}
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	logAction(c, "deleted channel %s %s", ch.ChannelID, ch.Name)
+	if isSubChannel(ch) {
+		recordAudit(c, service.AuditSubChannelHide, service.AuditChannelTarget(ch.ChannelID), subChannelSnapshot(ch, false), subChannelSnapshot(ch, true))
+	} else {
+		recordAudit(c, service.AuditChannelDelete, service.AuditChannelTarget(ch.ChannelID), service.ChannelSnapshot(ch), nil)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func V2RefreshChannelHandler(c *gin.Context) {
+	ch, ok := lookupChannel(c)
+	if !ok {
+		return
+	}
+	go service.UpdateURLCacheSingle(ch, true)
+	c.Status(http.StatusAccepted)
+}
+
+func V2PromoteChannelHandler(c *gin.Context) {
+	ch, ok := lookupChannel(c)
+	if !ok {
+		return
+	}
+	if !isSubChannel(ch) {
+		apiError(c, http.StatusBadRequest, "not_sub_channel", "not a sub channel")
+		return
+	}
+	mch, err := service.PromoteSubChannel(ch)
+	if mch == nil {
+		apiInternalError(c, err)
+		return
+	}
+	logAction(c, "promoted sub channel %s to channel %d", ch.ChannelID, mch.ID)
+	recordAudit(c, service.AuditChannelCreate, service.AuditChannelTarget(strconv.Itoa(mch.ID)), nil, service.ChannelSnapshot(mch))
+	go service.UpdateURLCacheSingle(mch, true)
+	if err != nil {
+		// the channel exists, but the sub channel is still listed next to it
+		log.Println(err.Error())
+		apiError(c, http.StatusInternalServerError, "hide_failed",
+			fmt.Sprintf("channel %d was created but the sub channel couldn't be hidden: %s", mch.ID, err))
+		return
+	}
+	replyChannel(c, http.StatusCreated, mch.ID)
+}
+
+func V2SubChannelsHandler(c *gin.Context) {
+	ch, ok := lookupChannel(c)
+	if !ok {
+		return
+	}
+	baseUrl, _ := global.GetConfig("base_url")
+	list := make([]ChannelV2, 0, len(ch.Children))
+	for _, sub := range ch.Children {
+		list = append(list, toChannelV2(baseUrl, sub, linkClient(c)))
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func V2OverridesHandler(c *gin.Context) {
+	ch, ok := lookupChannel(c)
+	if !ok {
+		return
+	}
+	overrides, err := service.GetChannelOverrides(ch.ID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, overrides)
+}
+
+func V2DeleteOverrideHandler(c *gin.Context) {
+	ch, ok := lookupChannel(c)
+	if !ok {
+		return
+	}
+	if err := service.DeleteChannelOverride(ch.ID, c.Param("identity")); err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	logAction(c, "deleted override %s of channel %d", c.Param("identity"), ch.ID)
+	c.Status(http.StatusNoContent)
+}
+
+func V2HistoryHandler(c *gin.Context) {
+	ch, ok := lookupChannel(c)
+	if !ok {
+		return
+	}
+	days, _ := strconv.Atoi(c.Query("days"))
+	history, err := service.GetChannelHistory(ch.URL, days, historyLimit(c))
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+func V2StatusHandler(c *gin.Context) {
+	channels, err := service.GetAllChannel()
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	report := StatusReport{
+		Summary:  make(map[string]int),
+		Channels: []ChannelStatusEntry{},
+	}
+	var add func(ch *model.Channel)
+	add = func(ch *model.Channel) {
+		status := channelStatus(ch.URL)
+		report.Summary[status.Status]++
+		report.Channels = append(report.Channels, ChannelStatusEntry{
+			ID:            ch.ChannelID,
+			Name:          ch.Name,
+			URL:           ch.URL,
+			ChannelStatus: status,
+		})
+		for _, sub := range ch.Children {
+			add(sub)
+		}
+	}
+	for _, ch := range channels {
+		add(ch)
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func V2RefreshAllHandler(c *gin.Context) {
+	go service.UpdateURLCache()
+	c.Status(http.StatusAccepted)
+}
+
+func V2GetConfigHandler(c *gin.Context) {
+	conf, err := loadConfig()
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, conf)
+}
+
+func V2UpdateConfigHandler(c *gin.Context) {
+	var in ConfigInput
+	if !bindJSON(c, &in) {
+		return
+	}
+	defer recordConfigChange(c, service.ConfigSnapshot())
+	if in.BaseURL != nil {
+		*in.BaseURL = strings.TrimSuffix(strings.TrimSpace(*in.BaseURL), "/")
+	}
+	changes := []struct {
+		key     string
+		value   *string
+		numeric bool
+	}{
+		{"ytdl_cmd", in.Cmd, false},
+		{"ytdl_args", in.Args, false},
+		{"base_url", in.BaseURL, false},
+		{"apiKey", in.ApiKey, false},
+		{"backup_interval", in.BackupInterval, true},
+		{"backup_keep", in.BackupKeep, true},
+		{"metrics_token", in.MetricsToken, false},
+		{"metrics_allow", in.MetricsAllow, false},
+		{"link_lifetime", in.LinkLifetime, true},
+		{"link_binding", in.LinkBinding, false},
+		{"link_legacy", in.LinkLegacy, false},
+		{"secret_grace", in.SecretGrace, true},
+		{"oidc_issuer", in.OIDCIssuer, false},
+		{"oidc_client_id", in.OIDCClientID, false},
+		{"oidc_client_secret", in.OIDCClientSecret, false},
+		{"oidc_scopes", in.OIDCScopes, false},
+		{"oidc_groups_claim", in.OIDCGroupsClaim, false},
+		{"oidc_allowed_groups", in.OIDCAllowedGroups, false},
+		{"oidc_allowed_emails", in.OIDCAllowedEmails, false},
+		{"oidc_role_map", in.OIDCRoleMap, false},
+		{"oidc_default_role", in.OIDCDefaultRole, false},
+		{"dest_allow", in.DestAllow, false},
+		{"dest_deny", in.DestDeny, false},
+		{"dest_trust_channels", in.DestTrustChannels, false},
+		{"geoip_db", in.GeoIPDB, false},
+	}
+	// validate everything before saving anything
+	if in.LinkBinding != nil && !validLinkBinding(strings.TrimSpace(*in.LinkBinding)) {
+		apiError(c, http.StatusBadRequest, "invalid_config", "link_binding must be empty, ip or session")
+		return
+	}
+	if in.LinkLegacy != nil {
+		*in.LinkLegacy = strconv.FormatBool(strings.TrimSpace(*in.LinkLegacy) != "false")
+	}
+	if in.DestTrustChannels != nil {
+		*in.DestTrustChannels = strconv.FormatBool(strings.TrimSpace(*in.DestTrustChannels) != "false")
+	}
+	for _, list := range []*string{in.DestAllow, in.DestDeny} {
+		if list == nil {
+			continue
+		}
+		if err := global.CheckDestinationList(*list); err != nil {
+			apiError(c, http.StatusBadRequest, "invalid_config", err.Error())
+			return
+		}
+	}
+	if in.GeoIPDB != nil {
+		if err := service.CheckGeoIPDatabase(strings.TrimSpace(*in.GeoIPDB)); err != nil {
+			apiError(c, http.StatusBadRequest, "invalid_config", "geoip_db: "+err.Error())
+			return
+		}
+	}
+	if err := checkOIDCConfig(map[string]*string{"oidc_role_map": in.OIDCRoleMap, "oidc_default_role": in.OIDCDefaultRole}); err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_config", err.Error())
+		return
+	}
+	for _, change := range changes {
+		if change.value == nil {
+			continue
+		}
+		*change.value = strings.TrimSpace(*change.value)
+		if change.numeric {
+			if _, err := strconv.Atoi(*change.value); err != nil {
+				apiError(c, http.StatusBadRequest, "invalid_config", change.key+" must be a number")
+				return
+			}
+		}
+	}
+	if in.LinkLifetime != nil && !validLinkLifetime(*in.LinkLifetime) {
+		apiError(c, http.StatusBadRequest, "invalid_config", linkLifetimeNeeded)
+		return
+	}
+	for _, change := range changes {
+		if change.value == nil {
+			continue
+		}
+		if err := global.SetConfig(change.key, *change.value); err != nil {
+			apiInternalError(c, err)
+			return
+		}
+	}
+	// after secret_grace, so a new grace window applies to this change already
+	if in.Secret != nil {
+		if err := global.SetSecret(strings.TrimSpace(*in.Secret)); err != nil {
+			apiInternalError(c, err)
+			return
+		}
+	}
+	logAction(c, "updated settings")
+	V2GetConfigHandler(c)
+}
+
+func V2EndRotationHandler(c *gin.Context) {
+	if err := global.EndRotation(); err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	logAction(c, "ended the secret rotation")
+	c.Status(http.StatusNoContent)
+}
+
+func V2CategoriesHandler(c *gin.Context) {
+	categories := global.GetAllCategories()
+	if categories == nil {
+		categories = []string{}
+	}
+	c.JSON(http.StatusOK, categories)
+}
+
+func V2PluginsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, service.GetPluginList())
+}
+
+func toAPIKeyInfo(key *model.APIKey) APIKeyInfo {
+	return APIKeyInfo{
+		ID:       key.ID,
+		Name:     key.Name,
+		Prefix:   key.Prefix,
+		Role:     key.Role,
+		Created:  key.Created,
+		LastUsed: key.LastUsed,
+		Revoked:  key.Revoked,
+	}
+}
+
+func V2ListKeysHandler(c *gin.Context) {
+	keys, err := service.ListAPIKeys()
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	list := make([]APIKeyInfo, 0, len(keys))
+	for i := range keys {
+		list = append(list, toAPIKeyInfo(&keys[i]))
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func V2CreateKeyHandler(c *gin.Context) {
+	var in struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}
+	if !bindJSON(c, &in) {
+		return
+	}
+	if strings.TrimSpace(in.Name) == "" {
+		apiError(c, http.StatusBadRequest, "invalid_name", "a name is required")
+		return
+	}
+	key, row, err := service.CreateAPIKey(in.Name, in.Role)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRole) {
+			apiError(c, http.StatusBadRequest, "invalid_role", err.Error())
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	logAction(c, "created %s api key %s", row.Role, row.Name)
+	info := toAPIKeyInfo(row)
+	info.Key = key
+	c.JSON(http.StatusCreated, info)
+}
+
+func V2RevokeKeyHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_id", "invalid key id")
+		return
+	}
+	if err = service.RevokeAPIKey(id); err != nil {
+		if errors.Is(err, service.ErrAPIKeyNotFound) {
+			apiError(c, http.StatusNotFound, "not_found", err.Error())
+		} else {
+			apiInternalError(c, err)
+		}
+		return
+	}
+	logAction(c, "revoked api key %d", id)
+	c.Status(http.StatusNoContent)
+}
+
+func V2SessionsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, service.GetSessions())
+}
+
+func V2SessionEventsHandler(c *gin.Context) {
+	streamSessions(c)
+}
+
+func V2KillSessionHandler(c *gin.Context) {
+	err := service.KillSession(c.Param("id"))
+	if errors.Is(err, service.ErrSessionNotFound) {
+		apiError(c, http.StatusNotFound, "not_found", err.Error())
+		return
+	}
+	logAction(c, "killed session %s", c.Param("id"))
+	c.Status(http.StatusNoContent)
+}
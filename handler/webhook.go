@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/service"
+)
+
+// tokens are never sent back, a webhook saved with an empty token keeps its current one
+func postedWebhook(c *gin.Context) (*model.Webhook, error) {
+	hook := &model.Webhook{}
+	if id, _ := strconv.Atoi(c.PostForm("id")); id > 0 {
+		if err := global.DB.First(hook, id).Error; err != nil {
+			return nil, err
+		}
+	}
+	hook.Name = strings.TrimSpace(c.PostForm("name"))
+	hook.Kind = global.CleanString(c.PostForm("kind"))
+	hook.URL = global.CleanString(c.PostForm("url"))
+	if token := strings.TrimSpace(c.PostForm("token")); token != "" {
+		hook.Token = token
+	}
+	hook.ChatID = strings.TrimSpace(c.PostForm("chatid"))
+	hook.Template = c.PostForm("template")
+	hook.Events = global.CleanString(c.PostForm("events"))
+	hook.Channels = global.CleanString(c.PostForm("channels"))
+	hook.RateLimit, _ = strconv.Atoi(c.PostForm("ratelimit"))
+	hook.Enabled = c.PostForm("enabled") == "true"
+	return hook, nil
+}
+
+func WebhookListHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleOwner) {
+		return
+	}
+	hooks, err := service.GetWebhooks()
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	list := make([]WebhookInfo, 0, len(hooks))
+	for _, hook := range hooks {
+		list = append(list, WebhookInfo{
+			ID:        hook.ID,
+			Name:      hook.Name,
+			Kind:      hook.Kind,
+			URL:       hook.URL,
+			HasToken:  hook.Token != "",
+			ChatID:    hook.ChatID,
+			Template:  hook.Template,
+			Events:    hook.Events,
+			Channels:  hook.Channels,
+			RateLimit: hook.RateLimit,
+			Enabled:   hook.Enabled,
+		})
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func SaveWebhookHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleOwner) {
+		return
+	}
+	hook, err := postedWebhook(c)
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	if err = service.SaveWebhook(hook); err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	logAction(c, "saved webhook %d %s", hook.ID, hook.Name)
+	c.String(http.StatusOK, strconv.Itoa(hook.ID))
+}
+
+func DeleteWebhookHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleOwner) {
+		return
+	}
+	id, _ := strconv.Atoi(c.Query("id"))
+	if id == 0 {
+		c.String(http.StatusBadRequest, "empty id")
+		return
+	}
+	if err := service.DeleteWebhook(id); err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	logAction(c, "deleted webhook %d", id)
+	c.String(http.StatusOK, "")
+}
+
+// send a test notification with the posted settings, they don't have to be saved first
+func TestWebhookHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleOwner) {
+		return
+	}
+	hook, err := postedWebhook(c)
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	if err = service.ValidateWebhook(hook); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err = service.TestWebhook(hook); err != nil {
+		c.String(http.StatusBadGateway, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "")
+}
+
+func EventTypesHandler(c *gin.Context) {
+	if !requireRole(c, model.RoleViewer) {
+		return
+	}
+	c.JSON(http.StatusOK, service.EventTypes())
+}
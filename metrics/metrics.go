@@ -0,0 +1,307 @@
+// Package metrics keeps counters, gauges and histograms and writes them in the prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Sample is a value reported by a collector
+type Sample struct {
+	Labels []string // label values, in the order of the label names of the collector
+	Value  float64
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	registryLock sync.Mutex
+	registry     = make(map[string]metric)
+)
+
+func register(name string, m metric) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("metrics: " + name + " is registered twice")
+	}
+	registry[name] = m
+}
+
+// WriteText writes every registered metric in the prometheus text exposition format
+func WriteText(w io.Writer) error {
+	registryLock.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	metrics := make([]metric, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		metrics = append(metrics, registry[name])
+	}
+	registryLock.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+func writeHeader(w *bufio.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func writeSample(w *bufio.Writer, name string, names []string, values []string, extra string, value float64) {
+	w.WriteString(name)
+	if len(names) > 0 || extra != "" {
+		w.WriteByte('{')
+		for i, n := range names {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(n)
+			w.WriteString(`="`)
+			w.WriteString(labelEscaper.Replace(values[i]))
+			w.WriteByte('"')
+		}
+		if extra != "" {
+			if len(names) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extra)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// series of a metric by label values
+type seriesMap[T any] struct {
+	lock   sync.Mutex
+	labels []string
+	series map[string]*T
+	keys   map[string][]string
+	create func() *T
+}
+
+func (m *seriesMap[T]) with(values []string) *T {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %d label values given for %d labels", len(values), len(m.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, ok := m.series[key]
+	if !ok {
+		s = m.create()
+		m.series[key] = s
+		m.keys[key] = append([]string{}, values...)
+	}
+	return s
+}
+
+// visit the series sorted by label values
+func (m *seriesMap[T]) each(fn func(values []string, s *T)) {
+	m.lock.Lock()
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	list := make([]*T, len(keys))
+	values := make([][]string, len(keys))
+	for i, key := range keys {
+		list[i] = m.series[key]
+		values[i] = m.keys[key]
+	}
+	m.lock.Unlock()
+	for i := range list {
+		fn(values[i], list[i])
+	}
+}
+
+func newSeriesMap[T any](labels []string, create func() *T) seriesMap[T] {
+	return seriesMap[T]{
+		labels: labels,
+		series: make(map[string]*T),
+		keys:   make(map[string][]string),
+		create: create,
+	}
+}
+
+// Value is a single counter or gauge value
+type Value struct {
+	lock  sync.Mutex
+	value float64
+}
+
+func (v *Value) Add(delta float64) {
+	v.lock.Lock()
+	v.value += delta
+	v.lock.Unlock()
+}
+
+func (v *Value) Inc() { v.Add(1) }
+func (v *Value) Dec() { v.Add(-1) }
+
+func (v *Value) Set(value float64) {
+	v.lock.Lock()
+	v.value = value
+	v.lock.Unlock()
+}
+
+func (v *Value) Get() float64 {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.value
+}
+
+// Vec is a counter or a gauge with labels
+type Vec struct {
+	name string
+	help string
+	kind string
+	seriesMap[Value]
+}
+
+func newVec(name string, help string, kind string, labels []string) *Vec {
+	v := &Vec{name: name, help: help, kind: kind, seriesMap: newSeriesMap(labels, func() *Value { return &Value{} })}
+	register(name, v)
+	return v
+}
+
+// NewCounter registers a counter, values of counters should only go up
+func NewCounter(name string, help string, labels ...string) *Vec {
+	return newVec(name, help, "counter", labels)
+}
+
+// NewGauge registers a gauge
+func NewGauge(name string, help string, labels ...string) *Vec {
+	return newVec(name, help, "gauge", labels)
+}
+
+// With returns the value of the given label values, it is created on first use
+func (v *Vec) With(values ...string) *Value {
+	return v.with(values)
+}
+
+func (v *Vec) write(w *bufio.Writer) {
+	writeHeader(w, v.name, v.help, v.kind)
+	v.each(func(values []string, s *Value) {
+		writeSample(w, v.name, v.labels, values, "", s.Get())
+	})
+}
+
+// DefBuckets are histogram buckets in seconds suitable for network requests
+var DefBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type histogramValue struct {
+	lock   sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Histogram counts observations into buckets
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	seriesMap[histogramValue]
+}
+
+// NewHistogram registers a histogram, buckets are upper bounds in increasing order
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, buckets: buckets}
+	h.seriesMap = newSeriesMap(labels, func() *histogramValue {
+		return &histogramValue{counts: make([]uint64, len(buckets))}
+	})
+	register(name, h)
+	return h
+}
+
+// Observe records a value for the given label values
+func (h *Histogram) Observe(value float64, labels ...string) {
+	s := h.with(labels)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.each(func(values []string, s *histogramValue) {
+		s.lock.Lock()
+		counts := append([]uint64{}, s.counts...)
+		count, sum := s.count, s.sum
+		s.lock.Unlock()
+		for i, bound := range h.buckets {
+			writeSample(w, h.name+"_bucket", h.labels, values, `le="`+formatFloat(bound)+`"`, float64(counts[i]))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, values, `le="+Inf"`, float64(count))
+		writeSample(w, h.name+"_sum", h.labels, values, "", sum)
+		writeSample(w, h.name+"_count", h.labels, values, "", float64(count))
+	})
+}
+
+// Func is a metric whose samples are computed on every scrape
+type Func struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	collect func() []Sample
+}
+
+// NewGaugeFunc registers a gauge computed by collect on every scrape
+func NewGaugeFunc(name string, help string, collect func() []Sample, labels ...string) {
+	register(name, &Func{name, help, "gauge", labels, collect})
+}
+
+// NewCounterFunc registers a counter computed by collect on every scrape
+func NewCounterFunc(name string, help string, collect func() []Sample, labels ...string) {
+	register(name, &Func{name, help, "counter", labels, collect})
+}
+
+func (f *Func) write(w *bufio.Writer) {
+	writeHeader(w, f.name, f.help, f.kind)
+	samples := f.collect()
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].Labels, "\xff") < strings.Join(samples[j].Labels, "\xff")
+	})
+	for _, s := range samples {
+		writeSample(w, f.name, f.labels, s.Labels, "", s.Value)
+	}
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"runtime"
+	"time"
+)
+
+var startTime = time.Now()
+
+func one(value float64) []Sample {
+	return []Sample{{Value: value}}
+}
+
+func memStats() *runtime.MemStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return &ms
+}
+
+func init() {
+	NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() []Sample {
+		return one(float64(runtime.NumGoroutine()))
+	})
+	NewGaugeFunc("go_threads", "Number of OS threads created.", func() []Sample {
+		n, _ := runtime.ThreadCreateProfile(nil)
+		return one(float64(n))
+	})
+	NewGaugeFunc("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", func() []Sample {
+		return one(float64(memStats().Alloc))
+	})
+	NewGaugeFunc("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", func() []Sample {
+		return one(float64(memStats().HeapInuse))
+	})
+	NewGaugeFunc("go_memstats_sys_bytes", "Number of bytes obtained from system.", func() []Sample {
+		return one(float64(memStats().Sys))
+	})
+	NewCounterFunc("go_memstats_mallocs_total", "Total number of mallocs.", func() []Sample {
+		return one(float64(memStats().Mallocs))
+	})
+	NewCounterFunc("go_gc_cycles_total", "Number of completed GC cycles.", func() []Sample {
+		return one(float64(memStats().NumGC))
+	})
+	NewCounterFunc("go_gc_pause_seconds_total", "Total time the program was paused by the GC.", func() []Sample {
+		return one(float64(memStats().PauseTotalNs) / 1e9)
+	})
+	NewGaugeFunc("process_start_time_seconds", "Start time of the process since unix epoch in seconds.", func() []Sample {
+		return one(float64(startTime.Unix()))
+	})
+}
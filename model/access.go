@@ -0,0 +1,13 @@
+package model
+
+// AccessPolicy limits the clients that may play, by address and country. Every policy that applies to a
+// channel has to let a client through: the global one, the one of its category and its own.
+type AccessPolicy struct {
+	ID            int    `gorm:"primary_key"`
+	Scope         string `gorm:"unique_index:idx_access_scope"` // global, category or channel
+	Target        string `gorm:"unique_index:idx_access_scope"` // the category or the channel id, sub channels are included in their parent. Empty for the global policy
+	Allow         string `gorm:"type:text"`                     // comma separated networks and addresses that may play
+	Deny          string `gorm:"type:text"`                     // comma separated networks and addresses that may not
+	Countries     string // comma separated iso country codes that may play. Anyone not denied may play when neither list is set
+	DenyCountries string // comma separated iso country codes that may not
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// APIKey grants access to the v2 api. Only a hash of the key is stored, the key itself is shown once on creation.
+type APIKey struct {
+	ID       int `gorm:"primary_key"`
+	Name     string
+	Prefix   string // first characters of the key to tell keys apart
+	Hash     string `gorm:"unique_index"`
+	Role     string // what the key may do, like a user of that role
+	Created  time.Time
+	LastUsed *time.Time
+	Revoked  bool
+}
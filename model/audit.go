@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// AuditEntry records an administrative change, with the state of what was changed before and after it
+type AuditEntry struct {
+	ID     int       `gorm:"primary_key"`
+	Time   time.Time `gorm:"index"`
+	Actor  string    // user or api key that made the change
+	IP     string
+	Action string `gorm:"index"`     // e.g. channel.update
+	Target string `gorm:"index"`     // what was changed, e.g. channel 3
+	Before string `gorm:"type:text"` // json snapshot with secrets redacted, empty when the target didn't exist
+	After  string `gorm:"type:text"` // json snapshot with secrets redacted, empty when the target was deleted
+}
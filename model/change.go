@@ -0,0 +1,11 @@
+package model
+
+// ChangeVersion is bumped whenever shared state of a scope changes, so that other instances sharing the database can drop their caches
+type ChangeVersion struct {
+	Scope   string `gorm:"primary_key"`
+	Version int64
+}
+
+func (ChangeVersion) TableName() string {
+	return "change_version"
+}
@@ -9,19 +9,21 @@ type Channel struct {
 	ID                int    `gorm:"primary_key"`
 	ParentID          string `gorm:"-:all"`
 	ChannelID         string `gorm:"-:all"`
+	Identity          string `gorm:"-:all"` // stable identity of a sub channel inside its provider playlist
 	Name              string
-	Logo              string
-	URL               string
+	Logo              string `gorm:"type:text"`
+	URL               string `gorm:"type:text"`
 	Parser            string
 	Proxy             bool
-	TsProxy           string     // new field for customized live.ts server
-	ProxyUrl          string     // proxy for server connection
-	Token             string     `gorm:"-:all"`
-	CustomQueryString string     `gorm:"-:all"` // custom extra url query param
-	Category          string     `gorm:"index"`
-	HasSubChannel     bool       `gorm:"hassubchn"`
-	Extra             string     // same as information returned from redirection page
-	Children          []*Channel `gorm:"-:all"` // sub channel list
+	TsProxy           string            // new field for customized live.ts server
+	ProxyUrl          string            // proxy for server connection
+	Token             string            `gorm:"-:all"`
+	CustomQueryString string            `gorm:"-:all"` // custom extra url query param
+	Category          string            `gorm:"index"`
+	HasSubChannel     bool              `gorm:"hassubchn"`
+	Extra             string            `gorm:"type:text"` // same as information returned from redirection page
+	Children          []*Channel        `gorm:"-:all"`     // sub channel list
+	Attrs             map[string]string `gorm:"-:all"`     // playlist attributes of a sub channel, e.g. tvg-id
 }
 
 func (c *Channel) Digest() string {
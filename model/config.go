@@ -2,5 +2,5 @@ package model
 
 type Config struct {
 	Name string `gorm:"primary_key"`
-	Data string
+	Data string `gorm:"type:text"`
 }
@@ -0,0 +1,10 @@
+package model
+
+// FilterRule keeps or drops the entries of a provider playlist before its sub channels are created
+type FilterRule struct {
+	ID        int    `gorm:"primary_key"`
+	ChannelID int    `gorm:"index"`
+	Action    string // include or exclude
+	Field     string // group, name, url, host or any tvg attribute like tvg-id
+	Pattern   string `gorm:"type:text"` // case insensitive regular expression
+}
@@ -0,0 +1,12 @@
+package model
+
+// ChannelOverride customizes a sub channel provided by a playlist parser, it is keyed by the stable identity of the sub channel
+type ChannelOverride struct {
+	ID       int    `gorm:"primary_key"`
+	ParentID int    `gorm:"unique_index:idx_channel_override"`
+	Identity string `gorm:"unique_index:idx_channel_override"`
+	Name     string
+	Category string
+	Logo     string `gorm:"type:text"`
+	Hidden   bool
+}
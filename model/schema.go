@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// SchemaVersion records a database migration that has been applied
+type SchemaVersion struct {
+	Version   int `gorm:"primary_key;auto_increment:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaVersion) TableName() string {
+	return "schema_version"
+}
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// StatusEvent records a status change of a channel url
+type StatusEvent struct {
+	ID          int       `gorm:"primary_key"`
+	URLHash     string    `gorm:"index"` // urls are too long to be indexed by every database
+	URL         string    `gorm:"type:text"`
+	Time        time.Time `gorm:"index"`
+	Status      int
+	Category    string // category of the error, empty when the channel is fine
+	Message     string `gorm:"type:text"`
+	ResolvedURL string `gorm:"type:text"` // the live url the parser came up with
+	Plugin      string
+}
@@ -0,0 +1,9 @@
+package model
+
+// SubChannel pins the number of a provider playlist entry, so the entry keeps its id when the upstream list is reordered
+type SubChannel struct {
+	ID       int    `gorm:"primary_key"`
+	ParentID int    `gorm:"unique_index:idx_sub_channel,idx_sub_channel_number"`
+	Identity string `gorm:"unique_index:idx_sub_channel"`
+	Number   int    `gorm:"unique_index:idx_sub_channel_number"` // two instances sharing the database can't hand out the same number
+}
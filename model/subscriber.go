@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// Subscriber is someone the playlists are shared with, with a token of their own
+type Subscriber struct {
+	ID         int `gorm:"primary_key"`
+	Name       string
+	Token      string     `gorm:"unique_index"`
+	Expires    *time.Time // nil when the subscription never expires
+	MaxStreams int        // devices playing at the same time, 0 for no limit
+	Categories string     `gorm:"type:text"` // comma separated categories the subscriber may watch
+	Channels   string     `gorm:"type:text"` // comma separated channel ids, sub channels are included in their parent. Everything is allowed when both lists are empty
+	Disabled   bool
+	Created    time.Time
+}
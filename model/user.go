@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// User is an account of the admin interface
+type User struct {
+	ID            int    `gorm:"primary_key"`
+	Name          string `gorm:"unique_index"`
+	Password      string // scrypt hash
+	Role          string // viewer, editor or owner
+	TOTPSecret    string // authenticator app secret, two-factor authentication is on when set
+	TOTPPending   string // secret of an enrolment that hasn't been confirmed yet
+	TOTPLast      int64  // time step of the last code used, codes can't be used twice
+	RecoveryCodes string `gorm:"type:text"`                 // comma separated hashes of unused recovery codes
+	OIDCSubject   string `gorm:"column:oidc_subject;index"` // issuer and subject of a user who logs in with single sign-on
+	Disabled      bool
+	Created       time.Time
+	LastLogin     *time.Time
+}
+
+// roles of users, each one can do everything the ones before it can
+const (
+	RoleViewer = "viewer" // sees channels, status and logs
+	RoleEditor = "editor" // adds, edits and deletes channels
+	RoleOwner  = "owner"  // changes settings, secrets and users
+)
@@ -0,0 +1,16 @@
+package model
+
+// Webhook sends events to a notification service
+type Webhook struct {
+	ID        int `gorm:"primary_key"`
+	Name      string
+	Kind      string // json, telegram, ntfy or gotify
+	URL       string `gorm:"type:text"`
+	Token     string // bot token for telegram, app token for gotify, access token for ntfy
+	ChatID    string // telegram chat
+	Template  string `gorm:"type:text"` // body of json hooks as a go template of the event, the event itself when empty
+	Events    string // comma separated event types, all events when empty
+	Channels  string // comma separated channel ids, sub channels are included in their parent, all channels when empty
+	RateLimit int    // notifications per minute, 0 for the default
+	Enabled   bool
+}
@@ -15,6 +15,7 @@ import (
 
 // Playlist is a type that represents an m3u playlist containing 0 or more tracks or streams
 type Playlist struct {
+	Header         []Tag // attributes of the #EXTM3U line, e.g. url-tvg
 	Tracks         []Track
 	VariantStreams []VariantStream
 }
@@ -27,10 +28,34 @@ type Tag struct {
 
 // Track represents an m3u track with a Name, Lengh, URI and a set of tags
 type Track struct {
-	Name   string
-	Length int
-	URI    string
-	Tags   []Tag
+	Name       string
+	Length     float64
+	URI        string
+	Tags       []Tag    // every attribute of the #EXTINF line in their original order
+	Group      string   // #EXTGRP
+	VLCOpts    []Tag    // #EXTVLCOPT:key=value
+	KodiProps  []Tag    // #KODIPROP:key=value
+	Directives []string // any other directive lines attached to the track, kept verbatim
+}
+
+// Tag returns the value of an attribute, names are case insensitive
+func (t *Track) Tag(name string) string {
+	for _, tag := range t.Tags {
+		if strings.EqualFold(tag.Name, name) {
+			return tag.Value
+		}
+	}
+	return ""
+}
+
+// VLCOpt returns the value of a #EXTVLCOPT option, names are case insensitive
+func (t *Track) VLCOpt(name string) string {
+	for _, opt := range t.VLCOpts {
+		if strings.EqualFold(opt.Name, name) {
+			return opt.Value
+		}
+	}
+	return ""
 }
 
 type VariantStream struct {
@@ -48,40 +73,108 @@ type VariantStream struct {
 	URI             string
 }
 
+var tagsRegExp = regexp.MustCompile(`([a-zA-Z0-9_:.-]+)=(?:"([^"]*)"|'([^']*)'|([^\s,"']+))`)
+
+// parse all key=value attributes of a directive line
+func parseTags(line string) []Tag {
+	var tags []Tag
+	for _, m := range tagsRegExp.FindAllStringSubmatch(line, -1) {
+		value := m[2]
+		if value == "" {
+			value = m[3]
+		}
+		if value == "" {
+			value = m[4]
+		}
+		tags = append(tags, Tag{m[1], value})
+	}
+	return tags
+}
+
+// split a key=value option of #EXTVLCOPT or #KODIPROP
+func parseOption(option string) Tag {
+	name, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+	return Tag{strings.TrimSpace(name), strings.TrimSpace(value)}
+}
+
+// find the comma separating the attributes from the track name, commas inside quoted values are skipped
+func nameSeparator(line string) int {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ',':
+			return i
+		}
+	}
+	return -1
+}
+
 func ParseFromReader(f io.Reader) (Playlist, error) {
 	onFirstLine := true
 	scanner := bufio.NewScanner(f)
-	tagsRegExp, _ := regexp.Compile("([a-zA-Z0-9-]+?)=\"([^\"]+)\"")
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
 	playlist := Playlist{}
+	var (
+		current *Track // track waiting for its uri
+		pending Track  // directives seen before an #EXTINF line
+	)
+	attach := func() *Track {
+		if current != nil {
+			return current
+		}
+		return &pending
+	}
 
 	for scanner.Scan() {
-		line := scanner.Text()
-		if onFirstLine && !strings.HasPrefix(line, "#EXTM3U") {
-			return Playlist{},
-				errors.New("invalid m3u file format. Expected #EXTM3U file header")
+		line := strings.TrimSpace(scanner.Text())
+		if onFirstLine {
+			line = strings.TrimPrefix(line, "\uFEFF")
+			if !strings.HasPrefix(line, "#EXTM3U") {
+				return Playlist{},
+					errors.New("invalid m3u file format. Expected #EXTM3U file header")
+			}
+			onFirstLine = false
+			playlist.Header = parseTags(strings.TrimPrefix(line, "#EXTM3U"))
+			continue
 		}
 
-		onFirstLine = false
-
 		if strings.HasPrefix(line, "#EXTINF") {
-			line := strings.Replace(line, "#EXTINF:", "", -1)
-			trackInfo := strings.Split(line, ",")
-			if len(trackInfo) < 2 {
+			line := strings.TrimPrefix(line, "#EXTINF:")
+			sep := nameSeparator(line)
+			if sep < 0 {
 				return Playlist{},
 					errors.New("invalid m3u file format. Expected EXTINF metadata to contain track length and name data")
 			}
-			length, parseErr := strconv.Atoi(strings.Split(trackInfo[0], " ")[0])
-			if parseErr != nil {
+			info, name := line[:sep], line[sep+1:]
+			lengthField := strings.Fields(info)
+			if len(lengthField) == 0 {
 				return Playlist{}, errors.New("unable to parse length")
 			}
-			track := &Track{strings.Trim(trackInfo[1], " "), length, "", nil}
-			tagList := tagsRegExp.FindAllString(line, -1)
-			for i := range tagList {
-				tagInfo := strings.Split(tagList[i], "=")
-				tag := &Tag{tagInfo[0], strings.Replace(tagInfo[1], "\"", "", -1)}
-				track.Tags = append(track.Tags, *tag)
+			length, parseErr := strconv.ParseFloat(lengthField[0], 64)
+			if parseErr != nil {
+				return Playlist{}, errors.New("unable to parse length")
 			}
-			playlist.Tracks = append(playlist.Tracks, *track)
+			track := pending
+			pending = Track{}
+			track.Name = strings.TrimSpace(name)
+			track.Length = length
+			track.Tags = parseTags(strings.TrimPrefix(info, lengthField[0]))
+			playlist.Tracks = append(playlist.Tracks, track)
+			current = &playlist.Tracks[len(playlist.Tracks)-1]
+		} else if strings.HasPrefix(line, "#EXTGRP:") {
+			attach().Group = strings.TrimSpace(strings.TrimPrefix(line, "#EXTGRP:"))
+		} else if strings.HasPrefix(line, "#EXTVLCOPT:") {
+			t := attach()
+			t.VLCOpts = append(t.VLCOpts, parseOption(strings.TrimPrefix(line, "#EXTVLCOPT:")))
+		} else if strings.HasPrefix(line, "#KODIPROP:") {
+			t := attach()
+			t.KodiProps = append(t.KodiProps, parseOption(strings.TrimPrefix(line, "#KODIPROP:")))
 		} else if strings.HasPrefix(line, "#EXT-X-STREAM-INF") {
 			line := strings.Replace(line, "#EXT-X-STREAM-INF:", "", -1)
 			streamInfo := strings.Split(line, ",")
@@ -149,16 +242,23 @@ func ParseFromReader(f io.Reader) (Playlist, error) {
 				}
 			}
 			playlist.VariantStreams = append(playlist.VariantStreams, *stream)
-		} else if strings.HasPrefix(line, "#") || line == "" {
+		} else if line == "" || strings.HasPrefix(line, "#EXTM3U") {
+			continue
+		} else if strings.HasPrefix(line, "#EXT") || strings.HasPrefix(line, "#PLAYLIST") {
+			// keep unknown directives with their track so that they survive a round-trip
+			t := attach()
+			t.Directives = append(t.Directives, line)
+		} else if strings.HasPrefix(line, "#") {
 			continue
 		} else if len(playlist.Tracks) == 0 && len(playlist.VariantStreams) == 0 {
 			return Playlist{},
 				errors.New("URI provided for playlist with no tracks or streams")
 
 		} else if playlist.VariantStreams != nil {
-			playlist.VariantStreams[len(playlist.VariantStreams)-1].URI = strings.Trim(line, " ")
-		} else if playlist.VariantStreams == nil {
-			playlist.Tracks[len(playlist.Tracks)-1].URI = strings.Trim(line, " ")
+			playlist.VariantStreams[len(playlist.VariantStreams)-1].URI = line
+		} else if current != nil {
+			current.URI = line
+			current = nil
 		}
 	}
 
@@ -199,22 +299,45 @@ func Marshall(p Playlist) (io.Reader, error) {
 	return buf, nil
 }
 
+// write attributes as key="value" pairs, each prefixed with a space.
+// Values with double quotes are put in single quotes, only a value with both kinds loses its double quotes.
+func writeTags(tags []Tag, into *bufio.Writer) {
+	for _, tag := range tags {
+		quote := "\""
+		value := tag.Value
+		if strings.Contains(value, quote) {
+			if strings.Contains(value, "'") {
+				value = strings.ReplaceAll(value, quote, "'")
+			} else {
+				quote = "'"
+			}
+		}
+		into.WriteString(" " + tag.Name + "=" + quote + value + quote)
+	}
+}
+
 // MarshallInto a *bufio.Writer a Playlist.
 func MarshallInto(p Playlist, into *bufio.Writer) error {
-	into.WriteString("#EXTM3U\n")
+	into.WriteString("#EXTM3U")
+	writeTags(p.Header, into)
+	into.WriteString("\n")
 	for _, track := range p.Tracks {
-		into.WriteString("#EXTINF:")
-		into.WriteString(fmt.Sprintf("%d ", track.Length))
-		for i := range track.Tags {
-			if i == len(track.Tags)-1 {
-				into.WriteString(fmt.Sprintf("%s=%q", track.Tags[i].Name, track.Tags[i].Value))
-				continue
-			}
-			into.WriteString(fmt.Sprintf("%s=%q ", track.Tags[i].Name, track.Tags[i].Value))
+		into.WriteString("#EXTINF:" + strconv.FormatFloat(track.Length, 'f', -1, 64))
+		writeTags(track.Tags, into)
+		into.WriteString("," + track.Name + "\n")
+		if track.Group != "" {
+			into.WriteString("#EXTGRP:" + track.Group + "\n")
 		}
-		into.WriteString(", ")
-
-		into.WriteString(fmt.Sprintf("%s\n%s\n", track.Name, track.URI))
+		for _, prop := range track.KodiProps {
+			into.WriteString("#KODIPROP:" + prop.Name + "=" + prop.Value + "\n")
+		}
+		for _, opt := range track.VLCOpts {
+			into.WriteString("#EXTVLCOPT:" + opt.Name + "=" + opt.Value + "\n")
+		}
+		for _, directive := range track.Directives {
+			into.WriteString(directive + "\n")
+		}
+		into.WriteString(track.URI + "\n")
 	}
 
 	return into.Flush()
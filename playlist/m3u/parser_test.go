@@ -0,0 +1,96 @@
+package m3u
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const roundTripPlaylist = `#EXTM3U url-tvg="http://epg.example.com/guide.xml" tvg-shift=2
+#EXTINF:-1 tvg-id=news.de tvg-name="News, Weather & Sport" group-title='Info',News, Weather & Sport
+#EXTGRP:Germany
+#EXTVLCOPT:http-user-agent=Mozilla/5.0 (X11; Linux)
+#EXTVLCOPT:http-referrer=https://example.com/
+http://example.com/news.m3u8
+#EXTINF:10.5 tvg-logo="http://example.com/logo.png?a=1,b=2" title='Say "hi"',Movie
+#KODIPROP:inputstream.adaptive.license_type=com.widevine.alpha
+#KODIPROP:inputstream.adaptive.license_key=https://license.example.com/?id=1
+#EXT-X-APP:anything
+http://example.com/movie.mpd
+#EXTINF:0,Radio
+http://example.com/radio.mp3
+`
+
+func TestRoundTrip(t *testing.T) {
+	first, err := ParseFromReader(strings.NewReader(roundTripPlaylist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Tracks) != 3 {
+		t.Fatalf("parsed %d tracks, want 3", len(first.Tracks))
+	}
+	news, movie := first.Tracks[0], first.Tracks[1]
+	checks := []struct {
+		what, got, want string
+	}{
+		{"unquoted attribute", news.Tag("tvg-id"), "news.de"},
+		{"comma in a quoted attribute", news.Tag("tvg-name"), "News, Weather & Sport"},
+		{"single quoted attribute", news.Tag("group-title"), "Info"},
+		{"name with commas", news.Name, "News, Weather & Sport"},
+		{"group", news.Group, "Germany"},
+		{"vlc option", news.VLCOpt("http-user-agent"), "Mozilla/5.0 (X11; Linux)"},
+		{"double quotes in single quotes", movie.Tag("title"), `Say "hi"`},
+		{"comma in a url attribute", movie.Tag("tvg-logo"), "http://example.com/logo.png?a=1,b=2"},
+		{"kodi property", movie.KodiProps[1].Value, "https://license.example.com/?id=1"},
+		{"header attribute", first.Header[1].Value, "2"},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %q, want %q", c.what, c.got, c.want)
+		}
+	}
+	if news.Length != -1 || movie.Length != 10.5 {
+		t.Errorf("lengths %v and %v, want -1 and 10.5", news.Length, movie.Length)
+	}
+
+	var buf bytes.Buffer
+	if err := MarshallInto(first, bufio.NewWriter(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	second, err := ParseFromReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("playlist changed in a round trip\nfirst:  %+v\nsecond: %+v", first, second)
+	}
+	// playlists from windows have CRLF line endings
+	crlf, err := ParseFromReader(strings.NewReader(strings.ReplaceAll(roundTripPlaylist, "\n", "\r\n")))
+	if err != nil || !reflect.DeepEqual(first, crlf) {
+		t.Errorf("CRLF playlist parsed differently: %v\n%+v", err, crlf)
+	}
+}
+
+func TestWriteTags(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"plain", ` a="plain"`},
+		{"a, b", ` a="a, b"`},
+		{"it's", ` a="it's"`},
+		{`say "hi"`, ` a='say "hi"'`},
+		{`it's "hi"`, ` a="it's 'hi'"`}, // both kinds of quotes can't be written
+		{"", ` a=""`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		writeTags([]Tag{{"a", tt.value}}, w)
+		w.Flush()
+		if buf.String() != tt.want {
+			t.Errorf("writeTags(%q) = %s, want %s", tt.value, buf.String(), tt.want)
+		}
+	}
+}
@@ -52,7 +52,7 @@ func (p *ParserDetector) Detect(channel *model.Channel) (string, error) {
 	previousExtraInfo := channel.Extra
 	client := http.Client{
 		Timeout:   time.Second * 10,
-		Transport: global.TransportWithProxy(channel.ProxyUrl),
+		Transport: global.UpstreamTransport(channel.ProxyUrl),
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
@@ -63,15 +63,19 @@ func (p *ParserDetector) Detect(channel *model.Channel) (string, error) {
 		return "", errInvalid
 	}
 	req.Header.Set("User-Agent", service.DefaultUserAgent)
+	// unpack previousExtraInfo
+	var pei service.UrlInfo
+	json.Unmarshal([]byte(previousExtraInfo), &pei)
+	// some feeds only answer to the headers given by their playlist
+	for k, v := range pei.Headers {
+		req.Header.Set(k, v)
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return "", err
 	}
 	defer global.CloseBody(resp)
 	redir := resp.Header.Get("Location")
-	// unpack previousExtraInfo
-	var pei service.UrlInfo
-	json.Unmarshal([]byte(previousExtraInfo), &pei)
 	if redir != "" {
 		if pei.RedirectCounter > 5 {
 			return "", errors.New("Too many redirections")
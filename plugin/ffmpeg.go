@@ -58,7 +58,7 @@ func (p *TranscodeParser) Parse(channel *model.Channel, prevLiveInfo *model.Live
 	if err != nil || !strings.EqualFold(u.Scheme, "rtmp") {
 		client := http.Client{
 			Timeout:   time.Second * 10,
-			Transport: global.TransportWithProxy(channel.ProxyUrl),
+			Transport: global.UpstreamTransport(channel.ProxyUrl),
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
 				return http.ErrUseLastResponse
 			},
@@ -30,7 +30,7 @@ func (p *URLM3U8Parser) Parse(channel *model.Channel, prevLiveInfo *model.LiveIn
 
 	client := http.Client{
 		Timeout:   time.Second * 10,
-		Transport: global.TransportWithProxy(channel.ProxyUrl),
+		Transport: global.UpstreamTransport(channel.ProxyUrl),
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
@@ -17,31 +17,27 @@ type M3USearcher struct {
 	M3UParser
 }
 
+// index the entries of a parsed playlist by their digest, hidden ones are left out
+func searchIndex(channel *model.Channel, li *model.LiveInfo) *syncx.HashedSlice[*model.Channel] {
+	var parsedList []ParsedChannel
+	json.Unmarshal([]byte(li.ExtraInfo), &parsedList)
+	chMap := syncx.NewHashedSlice[*model.Channel]()
+	for i := range parsedList {
+		sub := newSubChannel(channel, &parsedList[i])
+		if service.SubChannelHidden(channel.ID, sub.Identity) {
+			continue
+		}
+		sub.CustomQueryString = fmt.Sprintf("sid=%s", sub.Digest())
+		chMap.Add(sub)
+	}
+	return chMap
+}
+
 func (p *M3USearcher) Parse(channel *model.Channel, prevLiveInfo *model.LiveInfo) (*model.LiveInfo, error) {
 	li, err := p.M3UParser.Parse(channel, prevLiveInfo)
-	if err == nil {
-		var parsedList []ParsedChannel
-		json.Unmarshal([]byte(li.ExtraInfo), &parsedList)
-		chMap := syncx.NewHashedSlice[*model.Channel]()
-		for _, it := range parsedList {
-			channel := &model.Channel{
-				ID:        it.ID,
-				ParentID:  channel.ChannelID,
-				ChannelID: fmt.Sprintf("%d-%d", channel.ID, it.ID),
-				Category:  it.Category,
-				Name:      it.Name,
-				Logo:      it.Logo,
-				Parser:    "auto",
-				URL:       it.URL,
-				ProxyUrl:  channel.ProxyUrl,
-				Proxy:     channel.Proxy,
-				TsProxy:   channel.TsProxy,
-				Extra:     channel.Extra,
-			}
-			channel.CustomQueryString = fmt.Sprintf("sid=%s", channel.Digest())
-			chMap.Add(channel)
-		}
-		channelIndex.Store(channel.ChannelID, chMap)
+	// unsaved channels are parse tests, they must not replace the index of a saved one
+	if err == nil && channel.ID != 0 {
+		channelIndex.Store(channel.ChannelID, searchIndex(channel, li))
 	}
 	return li, err
 }
@@ -53,7 +49,8 @@ func (p *M3USearcher) ParseChannelUrl(chUrl string, mainChannelInfo *model.Chann
 	}
 	searchId := u.Query().Get("sid")
 	if channelMap, ok := channelIndex.Load(mainChannelInfo.ChannelID); ok {
-		if ch, ok := channelMap.GetByDigest(searchId); ok {
+		// entries hidden after the playlist was parsed are still in the index
+		if ch, ok := channelMap.GetByDigest(searchId); ok && !service.SubChannelHidden(mainChannelInfo.ID, ch.Identity) {
 			return ch
 		}
 	}
@@ -62,6 +59,9 @@ func (p *M3USearcher) ParseChannelUrl(chUrl string, mainChannelInfo *model.Chann
 
 // channel provider
 func (p *M3USearcher) Channels(parentChannel *model.Channel, liveInfo *model.LiveInfo) (channels []*model.Channel) {
+	if parentChannel.ID == 0 {
+		return searchIndex(parentChannel, liveInfo).AsSlice()
+	}
 	if channelMap, ok := channelIndex.Load(parentChannel.ChannelID); ok {
 		return channelMap.AsSlice()
 	}
@@ -2,6 +2,8 @@ package plugin
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +11,8 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/snowie2000/livetv/global"
@@ -28,12 +32,88 @@ type ParsedChannel struct {
 	TsProxy  string
 	ProxyUrl string
 	Category string
+	TvgID    string
+	Identity string            // stable identity used to pin the sub channel number
+	Attrs    map[string]string `json:",omitempty"` // all attributes of the #EXTINF line
+	Headers  map[string]string `json:",omitempty"` // request headers from #EXTVLCOPT and #KODIPROP
+}
+
+// request headers that players accept as #EXTVLCOPT options
+var vlcOptHeaders = map[string]string{
+	"http-user-agent": "User-Agent",
+	"http-referrer":   "Referer",
+	"http-referer":    "Referer",
+	"http-origin":     "Origin",
+	"http-cookie":     "Cookie",
+}
+
+// collect the per-entry request headers of a track
+func trackHeaders(track *m3u.Track) map[string]string {
+	headers := make(map[string]string)
+	for _, opt := range track.VLCOpts {
+		if header, ok := vlcOptHeaders[strings.ToLower(opt.Name)]; ok && opt.Value != "" {
+			headers[header] = opt.Value
+		}
+	}
+	for _, prop := range track.KodiProps {
+		// inputstream.adaptive.stream_headers=User-Agent=xxx&Referer=yyy
+		if strings.EqualFold(prop.Name, "inputstream.adaptive.stream_headers") {
+			for _, pair := range strings.Split(prop.Value, "&") {
+				if name, value, ok := strings.Cut(pair, "="); ok {
+					if unescaped, err := url.QueryUnescape(value); err == nil {
+						value = unescaped
+					}
+					headers[name] = value
+				}
+			}
+		}
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	return headers
 }
 
 type M3UPlayList struct {
 	Channels []ParsedChannel
 }
 
+// build a stable identity for a playlist entry: tvg-id if present, otherwise the normalised name and group
+func entryIdentity(ch *ParsedChannel) string {
+	if id := strings.TrimSpace(ch.TvgID); id != "" {
+		return "id:" + id
+	}
+	normalize := func(s string) string {
+		return strings.ToLower(strings.Join(strings.Fields(s), " "))
+	}
+	hash := md5.Sum([]byte(normalize(ch.Category) + "\x00" + normalize(ch.Name)))
+	return "name:" + hex.EncodeToString(hash[:])
+}
+
+// replace the index based IDs of the parsed list with numbers that survive upstream insertions and removals
+func assignStableIDs(parent *model.Channel, list []ParsedChannel) error {
+	seen := make(map[string]int)
+	identities := make([]string, len(list))
+	for i := range list {
+		identity := entryIdentity(&list[i])
+		// the same channel may appear more than once (e.g. multiple sources), number them by occurrence
+		seen[identity]++
+		if n := seen[identity]; n > 1 {
+			identity += "#" + strconv.Itoa(n)
+		}
+		list[i].Identity = identity
+		identities[i] = identity
+	}
+	numbers, err := service.AssignSubChannelNumbers(parent.ID, identities)
+	if err != nil {
+		return err
+	}
+	for i := range list {
+		list[i].ID = numbers[i]
+	}
+	return nil
+}
+
 func (p *M3UParser) Transform(req *http.Request, info *model.LiveInfo) error {
 	var ui service.UrlInfo
 	json.Unmarshal([]byte(info.ExtraInfo), &ui)
@@ -43,16 +123,23 @@ func (p *M3UParser) Transform(req *http.Request, info *model.LiveInfo) error {
 	return nil
 }
 
-// func (p *M3UParser) Parse(liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
-func (p *M3UParser) Parse(channel *model.Channel, prevLiveInfo *model.LiveInfo) (*model.LiveInfo, error) {
+// download the raw content of a playlist
+func (p *M3UParser) fetch(channel *model.Channel) ([]byte, error) {
 	_, err := url.Parse(channel.URL)
 	if err != nil {
 		return nil, err
 	}
+	// hand-curated playlists on the local disk
+	if path, ok, err := service.LocalFilePath(channel.URL); ok {
+		if err != nil {
+			return nil, err
+		}
+		return service.ReadLocalFile(path)
+	}
 
 	client := http.Client{
 		Timeout:   time.Second * 10,
-		Transport: global.TransportWithProxy(channel.ProxyUrl),
+		Transport: global.UpstreamTransport(channel.ProxyUrl),
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
@@ -80,9 +167,26 @@ func (p *M3UParser) Parse(channel *model.Channel, prevLiveInfo *model.LiveInfo)
 	if err != nil {
 		return nil, service.RetryOutdated
 	}
+	return content, nil
+}
+
+// parse playlist content in m3u or DIYP format, entries rejected by the filter are returned separately
+func (p *M3UParser) parseContent(channel *model.Channel, content []byte, filter *service.EntryFilter) (kept []ParsedChannel, dropped []ParsedChannel, err error) {
+	keep := func(ch ParsedChannel) {
+		entry := &service.FilterEntry{
+			Name:  ch.Name,
+			Group: ch.Category,
+			URL:   ch.URL,
+			Attrs: ch.Attrs,
+		}
+		if filter.Keep(entry) {
+			kept = append(kept, ch)
+		} else {
+			dropped = append(dropped, ch)
+		}
+	}
 
 	if playlist, err := m3u.ParseFromReader(bytes.NewBuffer(content)); err == nil {
-		parsedList := []ParsedChannel{}
 		for i, track := range playlist.Tracks {
 			channel := ParsedChannel{
 				Category: "",
@@ -92,8 +196,10 @@ func (p *M3UParser) Parse(channel *model.Channel, prevLiveInfo *model.LiveInfo)
 				Proxy:    false,
 				ProxyUrl: channel.ProxyUrl,
 				Logo:     "",
+				Attrs:    make(map[string]string),
 			}
 			for _, tag := range track.Tags {
+				channel.Attrs[strings.ToLower(tag.Name)] = tag.Value
 				switch tag.Name {
 				case "tvg-logo":
 					channel.Logo = tag.Value
@@ -101,22 +207,21 @@ func (p *M3UParser) Parse(channel *model.Channel, prevLiveInfo *model.LiveInfo)
 					channel.Name = tag.Value
 				case "group-title":
 					channel.Category = tag.Value
+				case "tvg-id":
+					channel.TvgID = tag.Value
 				}
 			}
-			parsedList = append(parsedList, channel)
+			if channel.Category == "" {
+				channel.Category = track.Group
+			}
+			channel.Headers = trackHeaders(&track)
+			keep(channel)
 		}
-
-		// save parsed channel list into liveinfo
-		js, _ := json.Marshal(parsedList)
-		li := &model.LiveInfo{}
-		li.LiveUrl = ""
-		li.ExtraInfo = string(js)
-		return li, nil
+		return kept, dropped, nil
 	}
 
 	// try as DIYP format
 	if playlist, err := diyp.ParseChannelFromReader(bytes.NewBuffer(content)); err == nil {
-		parsedList := []ParsedChannel{}
 		i := 0
 		for _, group := range playlist.Groups {
 			for _, track := range group.Channels {
@@ -130,42 +235,113 @@ func (p *M3UParser) Parse(channel *model.Channel, prevLiveInfo *model.LiveInfo)
 						ProxyUrl: channel.ProxyUrl,
 						Logo:     "",
 					}
-					parsedList = append(parsedList, channel)
+					keep(channel)
 					i++
 				}
 			}
 		}
+		return kept, dropped, nil
+	}
+	return nil, nil, errors.New("Unsupported playlist format")
+}
 
-		// save parsed channel list into liveinfo
-		js, _ := json.Marshal(parsedList)
-		li := &model.LiveInfo{}
-		li.LiveUrl = ""
-		li.ExtraInfo = string(js)
-		return li, nil
+// func (p *M3UParser) Parse(liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+func (p *M3UParser) Parse(channel *model.Channel, prevLiveInfo *model.LiveInfo) (*model.LiveInfo, error) {
+	filter, err := service.GetChannelFilter(channel.ID)
+	if err != nil {
+		return nil, err
 	}
-	return nil, errors.New("Unsupported playlist format")
+	content, err := p.fetch(channel)
+	if err != nil {
+		return nil, err
+	}
+	parsedList, _, err := p.parseContent(channel, content, filter)
+	if err != nil {
+		return nil, err
+	}
+	if err = assignStableIDs(channel, parsedList); err != nil {
+		return nil, err
+	}
+
+	// save parsed channel list into liveinfo
+	js, _ := json.Marshal(parsedList)
+	li := &model.LiveInfo{}
+	li.LiveUrl = ""
+	li.ExtraInfo = string(js)
+	return li, nil
+}
+
+// filter previewer
+func (p *M3UParser) PreviewFilter(channel *model.Channel, rules []model.FilterRule) (kept []*model.Channel, dropped []*model.Channel, err error) {
+	filter, err := service.NewEntryFilter(rules)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := p.fetch(channel)
+	if err != nil {
+		return nil, nil, err
+	}
+	keptList, droppedList, err := p.parseContent(channel, content, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	convert := func(list []ParsedChannel) []*model.Channel {
+		channels := make([]*model.Channel, 0, len(list))
+		for _, it := range list {
+			channels = append(channels, &model.Channel{
+				Name:     it.Name,
+				Logo:     it.Logo,
+				URL:      it.URL,
+				Category: it.Category,
+			})
+		}
+		return channels
+	}
+	return convert(keptList), convert(droppedList), nil
+}
+
+// build a sub channel from a parsed playlist entry
+func newSubChannel(parentChannel *model.Channel, it *ParsedChannel) *model.Channel {
+	channel := &model.Channel{
+		ID:        it.ID,
+		ParentID:  parentChannel.ChannelID,
+		ChannelID: fmt.Sprintf("%d-%d", parentChannel.ID, it.ID),
+		Identity:  it.Identity,
+		Category:  it.Category,
+		Name:      it.Name,
+		Logo:      it.Logo,
+		Parser:    "auto",
+		URL:       it.URL,
+		ProxyUrl:  parentChannel.ProxyUrl,
+		Proxy:     parentChannel.Proxy,
+		TsProxy:   parentChannel.TsProxy,
+		Extra:     parentChannel.Extra,
+		Attrs:     it.Attrs,
+	}
+	if len(it.Headers) > 0 {
+		// headers of the entry take precedence over the headers of the playlist channel
+		var ui service.UrlInfo
+		json.Unmarshal([]byte(parentChannel.Extra), &ui)
+		headers := make(map[string]string, len(ui.Headers)+len(it.Headers))
+		for k, v := range ui.Headers {
+			headers[k] = v
+		}
+		for k, v := range it.Headers {
+			headers[k] = v
+		}
+		ui.Headers = headers
+		js, _ := json.Marshal(ui)
+		channel.Extra = string(js)
+	}
+	return channel
 }
 
 // channel provider
 func (p *M3UParser) Channels(parentChannel *model.Channel, liveInfo *model.LiveInfo) (channels []*model.Channel) {
 	var parsedList []ParsedChannel
 	json.Unmarshal([]byte(liveInfo.ExtraInfo), &parsedList)
-	for _, it := range parsedList {
-		channel := &model.Channel{
-			ID:        it.ID,
-			ParentID:  parentChannel.ChannelID,
-			ChannelID: fmt.Sprintf("%d-%d", parentChannel.ID, it.ID),
-			Category:  it.Category,
-			Name:      it.Name,
-			Logo:      it.Logo,
-			Parser:    "auto",
-			URL:       it.URL,
-			ProxyUrl:  parentChannel.ProxyUrl,
-			Proxy:     parentChannel.Proxy,
-			TsProxy:   parentChannel.TsProxy,
-			Extra:     parentChannel.Extra,
-		}
-		channels = append(channels, channel)
+	for i := range parsedList {
+		channels = append(channels, newSubChannel(parentChannel, &parsedList[i]))
 	}
 	return channels
 }
@@ -36,7 +36,7 @@ func (p *RepeaterParser) Parse(channel *model.Channel, prevLiveInfo *model.LiveI
 
 	client := http.Client{
 		Timeout:   time.Second * 10,
-		Transport: global.TransportWithProxy(channel.ProxyUrl),
+		Transport: global.UpstreamTransport(channel.ProxyUrl),
 		Jar:       global.CookieJar,
 	}
 	req, err := http.NewRequest("GET", channel.URL, nil)
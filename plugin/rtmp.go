@@ -58,7 +58,7 @@ func (p *RTMPParser) Parse(channel *model.Channel, prevLiveInfo *model.LiveInfo)
 	if err != nil || !strings.EqualFold(u.Scheme, "rtmp") {
 		client := http.Client{
 			Timeout:   time.Second * 10,
-			Transport: global.TransportWithProxy(channel.ProxyUrl),
+			Transport: global.UpstreamTransport(channel.ProxyUrl),
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
 				return http.ErrUseLastResponse
 			},
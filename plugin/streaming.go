@@ -1,7 +1,6 @@
 package plugin
 
 import (
-	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/snowie2000/livetv/global"
 	"github.com/snowie2000/livetv/model"
@@ -21,7 +20,13 @@ func (p *StreamingParser) Host(c *gin.Context, info *model.LiveInfo, chInfo *mod
 	proxyTarget := info.LiveUrl
 	if chInfo.Proxy {
 		// if proxy stream is enabled, redirect to the universal reverse proxy with our secret
-		proxyTarget = fmt.Sprintf("/proxy?token=%s&k=%s&proxy=%s", global.GetLiveToken(), util.CompressString(info.LiveUrl), url.QueryEscape(chInfo.ProxyUrl))
+		query := url.Values{}
+		query.Set("token", global.GetLiveToken())
+		query.Set("k", util.CompressString(info.LiveUrl))
+		query.Set("proxy", chInfo.ProxyUrl)
+		query.Set("c", chInfo.ChannelID)
+		global.SignLink("proxy", query, global.LinkClient{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()})
+		proxyTarget = "/proxy?" + query.Encode()
 		if chInfo.TsProxy == "" {
 			proxyTarget = path.Join(chInfo.TsProxy, proxyTarget)
 		}
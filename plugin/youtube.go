@@ -76,7 +76,7 @@ type YoutubeExtraInfo struct {
 func isLive(m3u8Url string, proxyUrl string) bool {
 	client := http.Client{
 		Timeout:   time.Second * 10,
-		Transport: global.TransportWithProxy(proxyUrl),
+		Transport: global.UpstreamTransport(proxyUrl),
 		Jar:       global.CookieJar,
 	}
 	req, err := http.NewRequest("GET", m3u8Url, nil)
@@ -136,7 +136,7 @@ func makeRequestBody(videoId string) *VideoRequest {
 func parseUrl(liveUrl string, proxyUrl string) (*model.LiveInfo, error) {
 	client := http.Client{
 		Timeout:   time.Second * 10,
-		Transport: global.TransportWithProxy(proxyUrl),
+		Transport: global.UpstreamTransport(proxyUrl),
 		Jar:       global.CookieJar,
 	}
 	req, err := http.NewRequest("GET", liveUrl, nil)
@@ -12,10 +12,16 @@ import (
 	"github.com/snowie2000/livetv/model"
 
 	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/metrics"
 )
 
 type YtDlpParser struct{}
 
+var (
+	ytdlpRuns    = metrics.NewCounter("livetv_ytdlp_runs_total", "yt-dlp subprocesses started, by result.", "result")
+	ytdlpRunning = metrics.NewGauge("livetv_ytdlp_running", "yt-dlp subprocesses currently running.")
+)
+
 func (p *YtDlpParser) Parse(channel *model.Channel, prevLiveInfo *model.LiveInfo) (*model.LiveInfo, error) {
 	YtdlCmd, err := global.GetConfig("ytdl_cmd")
 	if err != nil {
@@ -41,7 +47,17 @@ func (p *YtDlpParser) Parse(channel *model.Channel, prevLiveInfo *model.LiveInfo
 		ctx, cancelFunc := context.WithTimeout(context.Background(), global.HttpClientTimeout)
 		defer cancelFunc()
 		cmd := exec.CommandContext(ctx, YtdlCmd, ytdlArgs...)
+		ytdlpRunning.With().Inc()
 		out, err := cmd.CombinedOutput()
+		ytdlpRunning.With().Dec()
+		switch {
+		case ctx.Err() != nil:
+			ytdlpRuns.With("timeout").Inc()
+		case err != nil:
+			ytdlpRuns.With("error").Inc()
+		default:
+			ytdlpRuns.With("ok").Inc()
+		}
 		output := strings.TrimSpace(string(out))
 		lines := strings.Split(output, "\n")
 		cleanLines := []string(nil)
@@ -15,23 +15,101 @@ func Register(r *gin.Engine) {
 	r.GET("/live.ts", handler.TsProxyHandler)
 	r.GET("/playlist.m3u8", handler.M3U8ProxyHandler)
 	r.GET("/cache.txt", handler.CacheHandler)
+	r.GET("/metrics", handler.MetricsHandler)
 
 	r.GET("/api/channels", handler.ChannelListHandler)
+	r.GET("/api/channels/export", handler.ExportChannelsHandler)
+	r.GET("/api/channels/history", handler.ChannelHistoryHandler)
+	r.POST("/api/channels/import", handler.ImportChannelsHandler)
+	r.GET("/api/backup", handler.BackupHandler)
 	r.GET("/api/plugins", handler.PluginListHandler)
 	r.GET("/api/crsf", handler.CRSFHandler)
 	r.POST("/api/newchannel", handler.NewChannelHandler)
 	r.POST("/api/updatechannel", handler.UpdateChannelHandler)
 	r.GET("/api/getconfig", handler.GetConfigHandler)
 	r.GET("/api/delchannel", handler.DeleteChannelHandler)
+	r.GET("/api/promotechannel", handler.PromoteChannelHandler)
+	r.GET("/api/overrides", handler.ChannelOverridesHandler)
+	r.GET("/api/deloverride", handler.DeleteOverrideHandler)
+	r.GET("/api/filters", handler.FilterListHandler)
+	r.POST("/api/filters", handler.UpdateFiltersHandler)
+	r.POST("/api/filters/preview", handler.FilterPreviewHandler)
+	r.POST("/api/parse/test", handler.ParseTestHandler)
+	r.GET("/api/webhooks", handler.WebhookListHandler)
+	r.POST("/api/webhooks", handler.SaveWebhookHandler)
+	r.POST("/api/webhooks/test", handler.TestWebhookHandler)
+	r.GET("/api/delwebhook", handler.DeleteWebhookHandler)
+	r.GET("/api/events", handler.EventTypesHandler)
+	r.GET("/api/sessions", handler.SessionListHandler)
+	r.GET("/api/sessions/events", handler.SessionEventsHandler)
+	r.GET("/api/killsession", handler.KillSessionHandler)
+	r.GET("/api/subscribers", handler.SubscriberListHandler)
+	r.POST("/api/subscribers", handler.SaveSubscriberHandler)
+	r.GET("/api/delsubscriber", handler.DeleteSubscriberHandler)
+	r.GET("/api/access", handler.AccessPolicyListHandler)
+	r.POST("/api/access", handler.SaveAccessPolicyHandler)
+	r.GET("/api/access/check", handler.AccessCheckHandler)
+	r.GET("/api/delaccess", handler.DeleteAccessPolicyHandler)
+	r.GET("/api/playlists", handler.PlaylistFilesHandler)
+	r.POST("/api/playlists/upload", handler.UploadPlaylistHandler)
+	r.GET("/api/delplaylist", handler.DeletePlaylistFileHandler)
 	r.POST("/api/updconfig", handler.UpdateConfigHandler)
+	r.GET("/api/endrotation", handler.EndRotationHandler)
 	r.GET("/api/auth", handler.AuthProbeHandler)
 	r.GET("/api/category", handler.CategoryHandler)
 	r.GET("/log", handler.LogHandler)
+
+	v2 := r.Group("/api/v2", handler.APIAuthMiddleware)
+	v2.GET("/channels", handler.V2ListChannelsHandler)
+	v2.POST("/channels", handler.V2CreateChannelHandler)
+	v2.GET("/channels/:id", handler.V2GetChannelHandler)
+	v2.PUT("/channels/:id", handler.V2UpdateChannelHandler)
+	v2.DELETE("/channels/:id", handler.V2DeleteChannelHandler)
+	v2.POST("/channels/:id/refresh", handler.V2RefreshChannelHandler)
+	v2.POST("/channels/:id/promote", handler.V2PromoteChannelHandler)
+	v2.GET("/channels/:id/subchannels", handler.V2SubChannelsHandler)
+	v2.GET("/channels/:id/history", handler.V2HistoryHandler)
+	v2.GET("/channels/:id/overrides", handler.V2OverridesHandler)
+	v2.DELETE("/channels/:id/overrides/:identity", handler.V2DeleteOverrideHandler)
+	v2.GET("/status", handler.V2StatusHandler)
+	v2.GET("/sessions", handler.V2SessionsHandler)
+	v2.GET("/sessions/events", handler.V2SessionEventsHandler)
+	v2.DELETE("/sessions/:id", handler.V2KillSessionHandler)
+	v2.POST("/refresh", handler.V2RefreshAllHandler)
+	v2.GET("/config", handler.V2GetConfigHandler)
+	v2.PATCH("/config", handler.V2UpdateConfigHandler)
+	v2.DELETE("/config/rotation", handler.V2EndRotationHandler)
+	v2.GET("/categories", handler.V2CategoriesHandler)
+	v2.GET("/plugins", handler.V2PluginsHandler)
+	v2.GET("/keys", handler.V2ListKeysHandler)
+	v2.POST("/keys", handler.V2CreateKeyHandler)
+	v2.DELETE("/keys/:id", handler.V2RevokeKeyHandler)
+	v2.GET("/access", handler.V2AccessPoliciesHandler)
+	v2.PUT("/access", handler.V2SaveAccessPolicyHandler)
+	v2.GET("/access/check", handler.V2AccessCheckHandler)
+	v2.DELETE("/access/:id", handler.V2DeleteAccessPolicyHandler)
+	v2.GET("/audit", handler.V2AuditHandler)
+	v2.POST("/audit/:id/revert", handler.V2RevertAuditHandler)
+
 	// r.GET("/login", handler.LoginViewHandler)
 	r.POST("/api/login", handler.LoginActionHandler)
 	r.GET("/api/logout", handler.LogoutHandler)
 	r.POST("/api/changepwd", handler.ChangePasswordHandler)
+	r.GET("/api/me", handler.MeHandler)
+	r.GET("/api/users", handler.UserListHandler)
+	r.POST("/api/users", handler.SaveUserHandler)
+	r.GET("/api/deluser", handler.DeleteUserHandler)
+	r.GET("/api/audit", handler.AuditLogHandler)
+	r.POST("/api/audit/revert", handler.RevertAuditHandler)
+	r.GET("/api/2fa", handler.TwoFactorStatusHandler)
+	r.POST("/api/2fa/enroll", handler.EnrollTwoFactorHandler)
+	r.POST("/api/2fa/confirm", handler.ConfirmTwoFactorHandler)
+	r.POST("/api/2fa/recovery", handler.RecoveryCodesHandler)
+	r.POST("/api/2fa/disable", handler.DisableTwoFactorHandler)
 	r.GET("/api/captcha", handler.CaptchaHandler)
+	r.GET("/api/oidc", handler.OIDCInfoHandler)
+	r.GET("/api/oidc/login", handler.OIDCLoginHandler)
+	r.GET("/api/oidc/callback", handler.OIDCCallbackHandler)
 	r.GET("/", handler.IndexHandler)
 	r.Any("/fetch", handler.FetchHandler)
 	r.GET("/:path", handler.IndexHandler)
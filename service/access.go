@@ -0,0 +1,392 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/metrics"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/util"
+)
+
+// Access policies limit playback by the address and the country of the client, on top of the tokens, so a
+// leaked token is of no use elsewhere. Countries come from the MaxMind DB file given by geoip_db, the free
+// GeoLite2 Country and DB-IP Country Lite databases work.
+
+const (
+	AccessGlobal   = "global"
+	AccessCategory = "category"
+	AccessChannel  = "channel"
+)
+
+var (
+	ErrAccessPolicyNotFound = errors.New("Access policy not found")
+	ErrPlaybackRefused      = errors.New("Playback isn't allowed from this address")
+	ErrPolicyNeedsSigning   = errors.New("Category and channel policies need signed links, set link_lifetime first")
+
+	refusedPlayback = metrics.NewCounter("livetv_refused_playback_total",
+		"Playback requests refused by the access policies, by the scope of the refusing policy.", "scope")
+
+	accessLock  sync.Mutex
+	accessRules []*accessRule // nil when they need to be loaded
+
+	geoLock    sync.Mutex
+	geoDB      *util.MMDB
+	geoPath    string
+	geoModTime time.Time
+	geoChecked time.Time
+)
+
+// a policy with its lists parsed
+type accessRule struct {
+	policy                   model.AccessPolicy
+	allowNets, denyNets      []*net.IPNet
+	countries, denyCountries []string
+	refuseAll                bool // the policy can't be parsed, it lets nobody through rather than everybody
+}
+
+// parse a comma separated list of networks and addresses
+func parseNetworks(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range splitList(list) {
+		if _, n, err := net.ParseCIDR(item); err == nil {
+			nets = append(nets, n)
+		} else if ip := net.ParseIP(item); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		} else {
+			return nil, fmt.Errorf("%q isn't a network or an address", item)
+		}
+	}
+	return nets, nil
+}
+
+// parse a comma separated list of two letter country codes, they are upper cased
+func parseCountries(list string) ([]string, error) {
+	var countries []string
+	for _, item := range splitList(list) {
+		code := strings.ToUpper(item)
+		if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+			return nil, fmt.Errorf("%q isn't a two letter country code", item)
+		}
+		countries = append(countries, code)
+	}
+	return countries, nil
+}
+
+func newAccessRule(policy *model.AccessPolicy) (*accessRule, error) {
+	rule := &accessRule{policy: *policy}
+	var err error
+	if rule.allowNets, err = parseNetworks(policy.Allow); err != nil {
+		return nil, err
+	}
+	if rule.denyNets, err = parseNetworks(policy.Deny); err != nil {
+		return nil, err
+	}
+	if rule.countries, err = parseCountries(policy.Countries); err != nil {
+		return nil, err
+	}
+	if rule.denyCountries, err = parseCountries(policy.DenyCountries); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// ValidateAccessPolicy checks a policy before it is saved and cleans up its lists
+func ValidateAccessPolicy(policy *model.AccessPolicy) error {
+	policy.Target = strings.TrimSpace(policy.Target)
+	switch policy.Scope {
+	case AccessGlobal:
+		policy.Target = ""
+	case AccessCategory:
+		if policy.Target == "" {
+			return errors.New("A category policy needs a category")
+		}
+	case AccessChannel:
+		if id, err := strconv.Atoi(policy.Target); err != nil || id <= 0 {
+			return errors.New("A channel policy needs the id of a channel")
+		}
+	default:
+		return errors.New("Scope must be global, category or channel")
+	}
+	rule, err := newAccessRule(policy)
+	if err != nil {
+		return err
+	}
+	policy.Allow = strings.Join(splitList(policy.Allow), ",")
+	policy.Deny = strings.Join(splitList(policy.Deny), ",")
+	policy.Countries = strings.Join(rule.countries, ",")
+	policy.DenyCountries = strings.Join(rule.denyCountries, ",")
+	return nil
+}
+
+func GetAccessPolicies() (policies []model.AccessPolicy, err error) {
+	policies = []model.AccessPolicy{}
+	err = global.DB.Order("scope, target").Find(&policies).Error
+	return
+}
+
+func GetAccessPolicy(id int) (*model.AccessPolicy, error) {
+	var policy model.AccessPolicy
+	if err := global.DB.First(&policy, id).Error; err != nil {
+		return nil, ErrAccessPolicyNotFound
+	}
+	return &policy, nil
+}
+
+// FindAccessPolicy returns the policy of a scope and target, nil when there is none
+func FindAccessPolicy(scope string, target string) *model.AccessPolicy {
+	var policy model.AccessPolicy
+	if err := global.DB.Where("scope = ? AND target = ?", scope, target).First(&policy).Error; err != nil {
+		return nil
+	}
+	return &policy
+}
+
+// SaveAccessPolicy creates or updates a policy, a new one replaces the policy its scope and target already have
+func SaveAccessPolicy(policy *model.AccessPolicy) error {
+	if err := ValidateAccessPolicy(policy); err != nil {
+		return err
+	}
+	if policy.Scope != AccessGlobal && global.LinkLifetime() == 0 {
+		return ErrPolicyNeedsSigning
+	}
+	if policy.ID == 0 {
+		if existing := FindAccessPolicy(policy.Scope, policy.Target); existing != nil {
+			policy.ID = existing.ID
+		}
+	}
+	if err := global.DB.Save(policy).Error; err != nil {
+		return err
+	}
+	invalidateAccessPolicies()
+	global.NotifyChange(global.ScopeAccess)
+	return nil
+}
+
+func DeleteAccessPolicy(id int) error {
+	res := global.DB.Delete(model.AccessPolicy{}, "id = ?", id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrAccessPolicyNotFound
+	}
+	invalidateAccessPolicies()
+	global.NotifyChange(global.ScopeAccess)
+	return nil
+}
+
+func invalidateAccessPolicies() {
+	accessLock.Lock()
+	accessRules = nil
+	accessLock.Unlock()
+}
+
+func loadedAccessRules() []*accessRule {
+	accessLock.Lock()
+	defer accessLock.Unlock()
+	if accessRules == nil {
+		var policies []model.AccessPolicy
+		if err := global.DB.Find(&policies).Error; err != nil {
+			log.Println("failed to load access policies:", err)
+			return nil
+		}
+		accessRules = []*accessRule{}
+		for i := range policies {
+			rule, err := newAccessRule(&policies[i])
+			if err != nil {
+				log.Printf("%s: %s\n", AccessPolicyName(&policies[i]), err)
+				rule = &accessRule{policy: policies[i], refuseAll: true}
+			}
+			accessRules = append(accessRules, rule)
+		}
+	}
+	return accessRules
+}
+
+// ChannelPoliciesExist tells whether there are category or channel policies, the relays then only
+// trust the channel of a signed link
+func ChannelPoliciesExist() bool {
+	for _, rule := range loadedAccessRules() {
+		if rule.policy.Scope != AccessGlobal {
+			return true
+		}
+	}
+	return false
+}
+
+// whether a policy covers a channel, or only the global one when ch is nil
+func (r *accessRule) applies(ch *model.Channel) bool {
+	switch r.policy.Scope {
+	case AccessGlobal:
+		return true
+	case AccessCategory:
+		return ch != nil && strings.EqualFold(r.policy.Target, ch.Category)
+	case AccessChannel:
+		return ch != nil && (r.policy.Target == ch.ChannelID || r.policy.Target == ch.ParentID)
+	}
+	return false
+}
+
+// denied addresses and countries are refused, then anyone is let through when the policy has no
+// allowed ones, or else those in an allowed network or country
+func (r *accessRule) allows(ip net.IP, country func() string) bool {
+	if r.refuseAll || netsContain(r.denyNets, ip) || (len(r.denyCountries) > 0 && slices.Contains(r.denyCountries, country())) {
+		return false
+	}
+	if len(r.allowNets) == 0 && len(r.countries) == 0 {
+		return true
+	}
+	return netsContain(r.allowNets, ip) || (len(r.countries) > 0 && slices.Contains(r.countries, country()))
+}
+
+func netsContain(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RefusingPolicy returns the policy that refuses a client, nil when every policy that applies lets it through
+func RefusingPolicy(clientIP string, ch *model.Channel) *model.AccessPolicy {
+	ip := net.ParseIP(clientIP)
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	country, looked := "", false
+	lookup := func() string {
+		if !looked {
+			country, looked = ClientCountry(ip), true
+		}
+		return country
+	}
+	for _, rule := range loadedAccessRules() {
+		if rule.applies(ch) && !rule.allows(ip, lookup) {
+			return &rule.policy
+		}
+	}
+	return nil
+}
+
+// PlaybackAllowed tells whether a client may play a channel, or get the playlists when ch is nil
+func PlaybackAllowed(clientIP string, ch *model.Channel) bool {
+	return RefusingPolicy(clientIP, ch) == nil
+}
+
+// CheckPlayback is PlaybackAllowed for playback requests, refusals are counted and logged
+func CheckPlayback(clientIP string, ch *model.Channel) error {
+	policy := RefusingPolicy(clientIP, ch)
+	if policy == nil {
+		return nil
+	}
+	refusedPlayback.With(policy.Scope).Inc()
+	what := "the playlists"
+	if ch != nil {
+		what = "channel " + ch.ChannelID
+	}
+	log.Printf("refused %s to %s: %s\n", what, clientIP, AccessPolicyName(policy))
+	return fmt.Errorf("%w: %s", ErrPlaybackRefused, AccessPolicyName(policy))
+}
+
+// AccessPolicyName names a policy in logs and the audit log
+func AccessPolicyName(policy *model.AccessPolicy) string {
+	if policy.Scope == AccessGlobal {
+		return "global access policy"
+	}
+	return "access policy of " + policy.Scope + " " + policy.Target
+}
+
+// a relative path to the GeoIP database is relative to the data dir
+func geoIPPath(path string) string {
+	if path != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(os.Getenv("LIVETV_DATADIR"), path)
+	}
+	return path
+}
+
+// CheckGeoIPDatabase tells whether a geoip_db setting is a MaxMind DB file, empty turns the lookups off
+func CheckGeoIPDatabase(path string) error {
+	if path == "" {
+		return nil
+	}
+	_, err := util.OpenMMDB(geoIPPath(path))
+	return err
+}
+
+// the GeoIP database of the current config, read again when the file has changed
+func geoIPDatabase() *util.MMDB {
+	conf, _ := global.GetConfig("geoip_db")
+	path := geoIPPath(conf)
+	geoLock.Lock()
+	defer geoLock.Unlock()
+	if path == geoPath && time.Since(geoChecked) < time.Minute {
+		return geoDB
+	}
+	geoChecked = time.Now()
+	if path == "" {
+		geoDB, geoPath = nil, ""
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if path != geoPath || geoDB != nil {
+			log.Println("GeoIP database:", err)
+		}
+		geoDB, geoPath = nil, path
+		return nil
+	}
+	if path != geoPath || !info.ModTime().Equal(geoModTime) {
+		db, err := util.OpenMMDB(path)
+		if err != nil {
+			log.Printf("GeoIP database %s: %s\n", path, err)
+		} else {
+			log.Printf("GeoIP database %s loaded (%s, built %s)\n", path, db.DatabaseType,
+				time.Unix(int64(db.BuildEpoch), 0).Format("2006-01-02"))
+		}
+		geoDB, geoPath, geoModTime = db, path, info.ModTime()
+	}
+	return geoDB
+}
+
+// ClientCountry returns the iso code of the country of an address, empty when it isn't known
+func ClientCountry(ip net.IP) string {
+	db := geoIPDatabase()
+	if db == nil || ip == nil {
+		return ""
+	}
+	record, err := db.Lookup(ip)
+	if err != nil {
+		log.Println("GeoIP lookup:", err)
+		return ""
+	}
+	fields, _ := record.(map[string]any)
+	// the country the address is used in, the one it is registered in when that isn't known
+	for _, key := range []string{"country", "registered_country"} {
+		if country, ok := fields[key].(map[string]any); ok {
+			if code, ok := country["iso_code"].(string); ok && code != "" {
+				return code
+			}
+		}
+	}
+	return ""
+}
+
+func init() {
+	global.OnChange(global.ScopeAccess, invalidateAccessPolicies)
+}
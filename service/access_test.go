@@ -0,0 +1,277 @@
+package service
+
+import (
+	"net"
+	"testing"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+)
+
+func testAccessRule(t *testing.T, policy model.AccessPolicy) *accessRule {
+	t.Helper()
+	rule, err := newAccessRule(&policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rule
+}
+
+func TestParseNetworks(t *testing.T) {
+	tests := []struct {
+		list  string
+		count int
+		ok    bool
+	}{
+		{"", 0, true},
+		{"10.0.0.0/8", 1, true},
+		{" 10.0.0.0/8 , 192.0.2.7,2001:db8::/32, ::1 ", 4, true},
+		{"10.0.0.0/33", 0, false},
+		{"10.0.0.300", 0, false},
+		{"example.com", 0, false},
+		{"10.0.0.0/8, nope", 0, false},
+	}
+	for _, tt := range tests {
+		nets, err := parseNetworks(tt.list)
+		if (err == nil) != tt.ok || len(nets) != tt.count {
+			t.Errorf("parseNetworks(%q) = %v, %v, want %d networks, ok = %v", tt.list, nets, err, tt.count, tt.ok)
+		}
+	}
+	// single addresses only match themselves
+	nets, _ := parseNetworks("192.0.2.7, 2001:db8::1")
+	for ip, want := range map[string]bool{"192.0.2.7": true, "192.0.2.8": false, "2001:db8::1": true, "2001:db8::2": false} {
+		if got := netsContain(nets, net.ParseIP(ip)); got != want {
+			t.Errorf("%s in %v = %v, want %v", ip, nets, got, want)
+		}
+	}
+}
+
+func TestParseCountries(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+		ok   bool
+	}{
+		{"", nil, true},
+		{"de", []string{"DE"}, true},
+		{" de, Fr ,US", []string{"DE", "FR", "US"}, true},
+		{"DEU", nil, false},
+		{"d", nil, false},
+		{"D1", nil, false},
+		{"de, ü", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := parseCountries(tt.list)
+		if (err == nil) != tt.ok || len(got) != len(tt.want) {
+			t.Errorf("parseCountries(%q) = %v, %v, want %v, ok = %v", tt.list, got, err, tt.want, tt.ok)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parseCountries(%q) = %v, want %v", tt.list, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestAccessRuleAllows(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  model.AccessPolicy
+		ip      string
+		country string
+		want    bool
+	}{
+		{"empty policy", model.AccessPolicy{}, "203.0.113.9", "DE", true},
+		{"allowed network", model.AccessPolicy{Allow: "10.0.0.0/8"}, "10.1.2.3", "", true},
+		{"outside allowed network", model.AccessPolicy{Allow: "10.0.0.0/8"}, "11.1.2.3", "", false},
+		{"allowed address", model.AccessPolicy{Allow: "192.0.2.7"}, "192.0.2.7", "", true},
+		{"other address", model.AccessPolicy{Allow: "192.0.2.7"}, "192.0.2.8", "", false},
+		{"allowed ipv6", model.AccessPolicy{Allow: "2001:db8::/32"}, "2001:db8::5", "", true},
+		{"ipv4 against ipv6 network", model.AccessPolicy{Allow: "2001:db8::/32"}, "10.1.2.3", "", false},
+		{"denied network", model.AccessPolicy{Deny: "10.0.0.0/8"}, "10.1.2.3", "", false},
+		{"outside denied network", model.AccessPolicy{Deny: "10.0.0.0/8"}, "11.1.2.3", "", true},
+		{"deny wins over allow", model.AccessPolicy{Allow: "10.0.0.0/8", Deny: "10.1.0.0/16"}, "10.1.2.3", "", false},
+		{"allowed next to denied", model.AccessPolicy{Allow: "10.0.0.0/8", Deny: "10.1.0.0/16"}, "10.2.2.3", "", true},
+		{"allowed country", model.AccessPolicy{Countries: "DE,AT"}, "203.0.113.9", "AT", true},
+		{"other country", model.AccessPolicy{Countries: "DE,AT"}, "203.0.113.9", "FR", false},
+		{"unknown country", model.AccessPolicy{Countries: "DE"}, "203.0.113.9", "", false},
+		{"denied country", model.AccessPolicy{DenyCountries: "RU"}, "203.0.113.9", "RU", false},
+		{"not a denied country", model.AccessPolicy{DenyCountries: "RU"}, "203.0.113.9", "DE", true},
+		{"unknown country not denied", model.AccessPolicy{DenyCountries: "RU"}, "203.0.113.9", "", true},
+		{"network or country, by network", model.AccessPolicy{Allow: "10.0.0.0/8", Countries: "DE"}, "10.1.2.3", "FR", true},
+		{"network or country, by country", model.AccessPolicy{Allow: "10.0.0.0/8", Countries: "DE"}, "203.0.113.9", "DE", true},
+		{"network or country, neither", model.AccessPolicy{Allow: "10.0.0.0/8", Countries: "DE"}, "203.0.113.9", "FR", false},
+		{"denied country in allowed network", model.AccessPolicy{Allow: "10.0.0.0/8", DenyCountries: "RU"}, "10.1.2.3", "RU", false},
+		{"denied network in allowed country", model.AccessPolicy{Countries: "DE", Deny: "10.0.0.0/8"}, "10.1.2.3", "DE", false},
+		{"deny list only", model.AccessPolicy{Deny: "10.0.0.0/8", DenyCountries: "RU"}, "203.0.113.9", "FR", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := testAccessRule(t, tt.policy)
+			lookups := 0
+			country := func() string {
+				lookups++
+				return tt.country
+			}
+			if got := rule.allows(net.ParseIP(tt.ip), country); got != tt.want {
+				t.Errorf("allows(%s, %q) = %v, want %v", tt.ip, tt.country, got, tt.want)
+			}
+			if tt.policy.Countries == "" && tt.policy.DenyCountries == "" && lookups > 0 {
+				t.Errorf("country looked up %d times without country lists", lookups)
+			}
+		})
+	}
+
+	// a policy that couldn't be parsed lets nobody through
+	broken := &accessRule{policy: model.AccessPolicy{Allow: "nope"}, refuseAll: true}
+	if broken.allows(net.ParseIP("10.1.2.3"), func() string { return "DE" }) {
+		t.Error("a broken policy let a client through")
+	}
+}
+
+func TestAccessRuleApplies(t *testing.T) {
+	channel := &model.Channel{ChannelID: "7", Category: "News"}
+	sub := &model.Channel{ChannelID: "7-2", ParentID: "7", Category: "Sports"}
+	tests := []struct {
+		name   string
+		policy model.AccessPolicy
+		ch     *model.Channel
+		want   bool
+	}{
+		{"global for a channel", model.AccessPolicy{Scope: AccessGlobal}, channel, true},
+		{"global for the playlists", model.AccessPolicy{Scope: AccessGlobal}, nil, true},
+		{"category", model.AccessPolicy{Scope: AccessCategory, Target: "news"}, channel, true},
+		{"other category", model.AccessPolicy{Scope: AccessCategory, Target: "Movies"}, channel, false},
+		{"category of a sub channel", model.AccessPolicy{Scope: AccessCategory, Target: "Sports"}, sub, true},
+		{"category for the playlists", model.AccessPolicy{Scope: AccessCategory, Target: "News"}, nil, false},
+		{"channel", model.AccessPolicy{Scope: AccessChannel, Target: "7"}, channel, true},
+		{"sub channel of the channel", model.AccessPolicy{Scope: AccessChannel, Target: "7"}, sub, true},
+		{"sub channel itself", model.AccessPolicy{Scope: AccessChannel, Target: "7-2"}, sub, true},
+		{"other channel", model.AccessPolicy{Scope: AccessChannel, Target: "70"}, channel, false},
+		{"channel for the playlists", model.AccessPolicy{Scope: AccessChannel, Target: "7"}, nil, false},
+		{"unknown scope", model.AccessPolicy{Scope: "user", Target: "7"}, channel, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &accessRule{policy: tt.policy}
+			if got := rule.applies(tt.ch); got != tt.want {
+				t.Errorf("applies = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefusingPolicy(t *testing.T) {
+	// no GeoIP database, the rules are set instead of being loaded
+	global.ConfigCache.Store("geoip_db", "")
+	policies := []model.AccessPolicy{
+		{ID: 1, Scope: AccessGlobal, Deny: "203.0.113.0/24"},
+		{ID: 2, Scope: AccessCategory, Target: "Local", Allow: "10.0.0.0/8, 192.168.0.0/16"},
+		{ID: 3, Scope: AccessChannel, Target: "7", Allow: "10.0.0.0/8", Deny: "10.66.0.0/16"},
+		{ID: 4, Scope: AccessChannel, Target: "9", Countries: "DE"},
+		{ID: 5, Scope: AccessChannel, Target: "11", Allow: "not a network"},
+	}
+	rules := []*accessRule{}
+	for i := range policies {
+		rule, err := newAccessRule(&policies[i])
+		if err != nil {
+			rule = &accessRule{policy: policies[i], refuseAll: true}
+		}
+		rules = append(rules, rule)
+	}
+	accessLock.Lock()
+	accessRules = rules
+	accessLock.Unlock()
+	t.Cleanup(func() {
+		invalidateAccessPolicies()
+		global.ConfigCache.Delete("geoip_db")
+	})
+
+	if !ChannelPoliciesExist() {
+		t.Error("ChannelPoliciesExist = false with category and channel policies")
+	}
+
+	local := &model.Channel{ChannelID: "7", Category: "Local"}
+	localSub := &model.Channel{ChannelID: "7-1", ParentID: "7", Category: "Local"}
+	other := &model.Channel{ChannelID: "8", Category: "News"}
+	german := &model.Channel{ChannelID: "9"}
+	broken := &model.Channel{ChannelID: "11"}
+	tests := []struct {
+		name string
+		ip   string
+		ch   *model.Channel
+		want int // id of the refusing policy, 0 when playback is allowed
+	}{
+		{"playlists", "198.51.100.1", nil, 0},
+		{"playlists from a denied network", "203.0.113.5", nil, 1},
+		{"channel without policy", "198.51.100.1", other, 0},
+		{"global deny before the others", "203.0.113.5", local, 1},
+		{"category refuses", "192.0.2.1", local, 2},
+		{"category and channel allow", "10.1.2.3", local, 0},
+		{"category allows, channel doesn't", "192.168.1.2", local, 3},
+		{"channel denies", "10.66.1.1", local, 3},
+		{"channel policy covers sub channels", "10.66.1.1", localSub, 3},
+		{"mapped address", "::ffff:10.66.1.1", local, 3},
+		{"country unknown without database", "10.1.2.3", german, 4},
+		{"broken policy refuses", "10.1.2.3", broken, 5},
+		{"invalid address", "nonsense", local, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := 0
+			if policy := RefusingPolicy(tt.ip, tt.ch); policy != nil {
+				got = policy.ID
+			}
+			if got != tt.want {
+				t.Errorf("RefusingPolicy(%s) = policy %d, want %d", tt.ip, got, tt.want)
+			}
+			if PlaybackAllowed(tt.ip, tt.ch) != (tt.want == 0) {
+				t.Errorf("PlaybackAllowed(%s) = %v", tt.ip, !(tt.want == 0))
+			}
+		})
+	}
+
+	accessLock.Lock()
+	accessRules = rules[:1]
+	accessLock.Unlock()
+	if ChannelPoliciesExist() {
+		t.Error("ChannelPoliciesExist = true with only the global policy")
+	}
+}
+
+func TestValidateAccessPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy model.AccessPolicy
+		ok     bool
+	}{
+		{"global", model.AccessPolicy{Scope: AccessGlobal, Target: "ignored", Allow: "10.0.0.0/8"}, true},
+		{"category", model.AccessPolicy{Scope: AccessCategory, Target: " News "}, true},
+		{"category without name", model.AccessPolicy{Scope: AccessCategory, Target: " "}, false},
+		{"channel", model.AccessPolicy{Scope: AccessChannel, Target: "7"}, true},
+		{"sub channel", model.AccessPolicy{Scope: AccessChannel, Target: "7-1"}, false},
+		{"channel 0", model.AccessPolicy{Scope: AccessChannel, Target: "0"}, false},
+		{"unknown scope", model.AccessPolicy{Scope: "user"}, false},
+		{"bad network", model.AccessPolicy{Scope: AccessGlobal, Deny: "10.0.0.0/40"}, false},
+		{"bad country", model.AccessPolicy{Scope: AccessGlobal, Countries: "Germany"}, false},
+		{"bad denied country", model.AccessPolicy{Scope: AccessGlobal, DenyCountries: "R"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateAccessPolicy(&tt.policy); (err == nil) != tt.ok {
+				t.Errorf("ValidateAccessPolicy error %v, want ok = %v", err, tt.ok)
+			}
+		})
+	}
+
+	// lists are cleaned up before they are saved
+	policy := model.AccessPolicy{Scope: AccessGlobal, Target: "x", Allow: " 10.0.0.0/8 ,, 192.0.2.7 ", Countries: "de, at"}
+	if err := ValidateAccessPolicy(&policy); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Target != "" || policy.Allow != "10.0.0.0/8,192.0.2.7" || policy.Countries != "DE,AT" {
+		t.Errorf("cleaned up policy %+v", policy)
+	}
+}
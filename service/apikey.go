@@ -0,0 +1,82 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+)
+
+const apiKeyPrefix = "ltv_"
+
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// CreateAPIKey generates a new api key with the permissions of a role, the returned key is not stored and can't be shown again
+func CreateAPIKey(name string, role string) (string, *model.APIKey, error) {
+	if roleRanks[role] == 0 {
+		return "", nil, ErrInvalidRole
+	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	row := &model.APIKey{
+		Name:    strings.TrimSpace(name),
+		Prefix:  key[:len(apiKeyPrefix)+6],
+		Hash:    hashAPIKey(key),
+		Role:    role,
+		Created: time.Now(),
+	}
+	if err := global.DB.Create(row).Error; err != nil {
+		return "", nil, err
+	}
+	return key, row, nil
+}
+
+func ListAPIKeys() (keys []model.APIKey, err error) {
+	keys = []model.APIKey{}
+	err = global.DB.Order("id").Find(&keys).Error
+	return
+}
+
+// RevokeAPIKey disables a key for good, the row is kept so that it still shows up in the key list
+func RevokeAPIKey(id int) error {
+	res := global.DB.Model(&model.APIKey{}).Where("id = ?", id).Update("revoked", true)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// CheckAPIKey looks up a key, revoked and unknown keys are rejected.
+// Keys are checked against the database every time so that a revocation applies to all instances at once.
+func CheckAPIKey(key string) (*model.APIKey, bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, false
+	}
+	var row model.APIKey
+	if err := global.DB.Where("hash = ?", hashAPIKey(key)).First(&row).Error; err != nil || row.Revoked {
+		return nil, false
+	}
+	if row.LastUsed == nil || time.Since(*row.LastUsed) > time.Minute {
+		now := time.Now()
+		row.LastUsed = &now
+		global.DB.Model(&row).UpdateColumn("last_used", now)
+	}
+	return &row, true
+}
@@ -0,0 +1,342 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+)
+
+// actions recorded in the audit log
+const (
+	AuditChannelCreate    = "channel.create"
+	AuditChannelUpdate    = "channel.update"
+	AuditChannelDelete    = "channel.delete"
+	AuditChannelRevert    = "channel.revert"
+	AuditSubChannelUpdate = "subchannel.update"
+	AuditSubChannelHide   = "subchannel.hide"
+	AuditConfigUpdate     = "config.update"
+	AuditPasswordChange   = "user.password"
+	AuditUserSave         = "user.save"
+	AuditUserDelete       = "user.delete"
+	AuditAccessSave       = "access.save"
+	AuditAccessDelete     = "access.delete"
+)
+
+const (
+	redacted          = "[redacted]"
+	redactedChanged   = "[redacted, changed]"
+	MaxAuditLimit     = 500
+	auditChannelTitle = "channel "
+
+	// AuditConfigTarget names the settings in the audit log
+	AuditConfigTarget = "config"
+)
+
+var (
+	ErrAuditNotFound = errors.New("Audit entry not found")
+	ErrNotRevertible = errors.New("Only changes of channels with a previous version can be reverted")
+)
+
+// AuditActor is who made a change and from where
+type AuditActor struct {
+	Name string
+	IP   string
+}
+
+// AuditChange is a field that differs between the before and after snapshots of an audit entry
+type AuditChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// ChannelVersion is the state of a channel as recorded in the audit log, what a revert brings back
+type ChannelVersion struct {
+	ID            int
+	Name          string
+	Logo          string
+	URL           string
+	Parser        string
+	Proxy         bool
+	TsProxy       string
+	ProxyUrl      string
+	Category      string
+	HasSubChannel bool
+	Extra         string
+}
+
+// ChannelSnapshot is the stored state of a channel, nil for no channel
+func ChannelSnapshot(ch *model.Channel) *ChannelVersion {
+	if ch == nil {
+		return nil
+	}
+	return &ChannelVersion{
+		ID:            ch.ID,
+		Name:          ch.Name,
+		Logo:          ch.Logo,
+		URL:           ch.URL,
+		Parser:        ch.Parser,
+		Proxy:         ch.Proxy,
+		TsProxy:       ch.TsProxy,
+		ProxyUrl:      ch.ProxyUrl,
+		Category:      ch.Category,
+		HasSubChannel: ch.HasSubChannel,
+		Extra:         ch.Extra,
+	}
+}
+
+// AuditChannelTarget names a channel in the audit log
+func AuditChannelTarget(channelID string) string {
+	return auditChannelTitle + channelID
+}
+
+// ConfigSnapshot is every config value, secrets are redacted when recorded
+func ConfigSnapshot() map[string]string {
+	conf := make(map[string]string)
+	global.ConfigCache.Range(func(key string, value string) bool {
+		conf[key] = value
+		return true
+	})
+	return conf
+}
+
+// turn a snapshot into a map of its fields, nil stays nil
+func snapshotFields(v any) (map[string]any, error) {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Pointer && reflect.ValueOf(v).IsNil()) {
+		return nil, nil
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if err = json.Unmarshal(js, &fields); err != nil {
+		return nil, err
+	}
+	// passwords of proxy urls and the like
+	for key, value := range fields {
+		if text, ok := value.(string); ok && strings.Contains(text, "@") {
+			if u, err := url.Parse(text); err == nil && u.User != nil {
+				fields[key] = u.Redacted()
+			}
+		}
+	}
+	return fields, nil
+}
+
+// replace secrets by a marker that still tells whether they have changed
+func redactSecrets(before map[string]any, after map[string]any) {
+	for _, key := range global.SecretConfigKeys {
+		prev, inBefore := before[key]
+		next, inAfter := after[key]
+		changed := inBefore != inAfter || !reflect.DeepEqual(prev, next)
+		if inBefore {
+			before[key] = redacted
+		}
+		if inAfter {
+			after[key] = redacted
+			if changed {
+				after[key] = redactedChanged
+			}
+		}
+	}
+}
+
+func encodeSnapshot(fields map[string]any) (string, error) {
+	if fields == nil {
+		return "", nil
+	}
+	js, err := json.Marshal(fields)
+	return string(js), err
+}
+
+// RecordAudit adds an entry to the audit log. before and after are snapshots of the target, nil when it didn't exist.
+// A failure is logged but doesn't stop the change, which has been made already.
+func RecordAudit(actor AuditActor, action string, target string, before any, after any) {
+	if err := recordAudit(actor, action, target, before, after); err != nil {
+		log.Println("failed to record audit entry:", err)
+	}
+}
+
+func recordAudit(actor AuditActor, action string, target string, before any, after any) error {
+	beforeFields, err := snapshotFields(before)
+	if err != nil {
+		return err
+	}
+	afterFields, err := snapshotFields(after)
+	if err != nil {
+		return err
+	}
+	redactSecrets(beforeFields, afterFields)
+	entry := model.AuditEntry{
+		Time:   time.Now(),
+		Actor:  actor.Name,
+		IP:     actor.IP,
+		Action: action,
+		Target: target,
+	}
+	if entry.Before, err = encodeSnapshot(beforeFields); err != nil {
+		return err
+	}
+	if entry.After, err = encodeSnapshot(afterFields); err != nil {
+		return err
+	}
+	return global.DB.Create(&entry).Error
+}
+
+// GetAuditLog returns a page of the audit log, newest first, and the number of entries matching the filters.
+// target and actor filter the entries when not empty.
+func GetAuditLog(page int, limit int, target string, actor string) ([]model.AuditEntry, int, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > MaxAuditLimit {
+		limit = MaxAuditLimit
+	}
+	query := global.DB.Model(&model.AuditEntry{})
+	if target != "" {
+		query = query.Where("target = ?", target)
+	}
+	if actor != "" {
+		query = query.Where("actor = ?", actor)
+	}
+	var total int
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	entries := []model.AuditEntry{}
+	err := query.Order("id desc").Offset((page - 1) * limit).Limit(limit).Find(&entries).Error
+	return entries, total, err
+}
+
+// AuditChanges lists the fields an audit entry changed
+func AuditChanges(entry *model.AuditEntry) []AuditChange {
+	var before, after map[string]any
+	if entry.Before != "" {
+		json.Unmarshal([]byte(entry.Before), &before)
+	}
+	if entry.After != "" {
+		json.Unmarshal([]byte(entry.After), &after)
+	}
+	fields := make(map[string]bool)
+	for key := range before {
+		fields[key] = true
+	}
+	for key := range after {
+		fields[key] = true
+	}
+	changes := []AuditChange{}
+	for field := range fields {
+		prev, next := before[field], after[field]
+		if reflect.DeepEqual(prev, next) {
+			continue
+		}
+		changes = append(changes, AuditChange{Field: field, Before: prev, After: next})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// the channel version before the change of an entry, if it can be brought back
+func revertibleVersion(entry *model.AuditEntry) (*ChannelVersion, bool) {
+	switch entry.Action {
+	case AuditChannelUpdate, AuditChannelDelete, AuditChannelRevert:
+	default:
+		return nil, false
+	}
+	if entry.Before == "" || !strings.HasPrefix(entry.Target, auditChannelTitle) {
+		return nil, false
+	}
+	var version ChannelVersion
+	if err := json.Unmarshal([]byte(entry.Before), &version); err != nil || version.ID == 0 {
+		return nil, false
+	}
+	return &version, true
+}
+
+// AuditRevertible tells whether RevertChannel can undo an entry
+func AuditRevertible(entry *model.AuditEntry) bool {
+	_, ok := revertibleVersion(entry)
+	return ok
+}
+
+// RevertChannel brings a channel back to the version it had before the change of an audit entry.
+// A deleted channel comes back with its id, its sub channel overrides and filters are gone though.
+func RevertChannel(entryID int, actor AuditActor) (*model.Channel, error) {
+	var entry model.AuditEntry
+	if err := global.DB.First(&entry, entryID).Error; err != nil {
+		return nil, ErrAuditNotFound
+	}
+	version, ok := revertibleVersion(&entry)
+	if !ok {
+		return nil, ErrNotRevertible
+	}
+	var before *ChannelVersion
+	if current, err := GetChannel(version.ID, -1); err == nil {
+		before = ChannelSnapshot(current)
+		// passwords aren't recorded, the current ones are kept
+		version.URL = keepPassword(version.URL, current.URL)
+		version.ProxyUrl = keepPassword(version.ProxyUrl, current.ProxyUrl)
+		version.TsProxy = keepPassword(version.TsProxy, current.TsProxy)
+	}
+	ch := &model.Channel{
+		ID:            version.ID,
+		Name:          version.Name,
+		Logo:          version.Logo,
+		URL:           version.URL,
+		Parser:        version.Parser,
+		Proxy:         version.Proxy,
+		TsProxy:       version.TsProxy,
+		ProxyUrl:      version.ProxyUrl,
+		Category:      version.Category,
+		HasSubChannel: version.HasSubChannel,
+		Extra:         version.Extra,
+	}
+	if err := SaveChannel(ch); err != nil {
+		return nil, err
+	}
+	RecordAudit(actor, AuditChannelRevert, entry.Target, before, ChannelSnapshot(ch))
+	return ch, nil
+}
+
+// put the current password back into a url whose password has been redacted in the audit log.
+// It is kept when the user name is the same, the url is left as recorded otherwise.
+func keepPassword(recorded string, current string) string {
+	r, err := url.Parse(recorded)
+	if err != nil || r.User == nil {
+		return recorded
+	}
+	if password, ok := r.User.Password(); !ok || password != "xxxxx" {
+		return recorded
+	}
+	c, err := url.Parse(current)
+	if err != nil || c.User == nil || c.User.Username() != r.User.Username() {
+		return recorded
+	}
+	password, _ := c.User.Password()
+	r.User = url.UserPassword(r.User.Username(), password)
+	return r.String()
+}
+
+// AuditUserTarget names a user in the audit log
+func AuditUserTarget(name string) string {
+	return "user " + name
+}
+
+// AuditAccessTarget names an access policy in the audit log
+func AuditAccessTarget(policy *model.AccessPolicy) string {
+	if policy.Scope == AccessGlobal {
+		return "access " + AccessGlobal
+	}
+	return "access " + policy.Scope + " " + policy.Target
+}
@@ -0,0 +1,390 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+)
+
+const (
+	backupVersion   = 1
+	backupManifest  = "manifest.json"
+	backupPlaylists = "playlists/"
+)
+
+var (
+	errInvalidBackup = errors.New("Invalid backup archive")
+	lastBackup       time.Time
+)
+
+type BackupManifest struct {
+	App     string    `json:"app"`
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Secrets bool      `json:"secrets"`
+	Schema  int       `json:"schema"`
+	Tables  []string  `json:"tables"`
+}
+
+// a database table that is part of a backup
+type backupTable interface {
+	file() string
+	dump(tx *gorm.DB, secrets bool) (any, error)
+	validate(data []byte) error
+	restore(tx *gorm.DB, data []byte, secrets bool) error
+}
+
+type modelTable[T any] struct {
+	name string
+}
+
+func (t modelTable[T]) file() string {
+	return t.name + ".json"
+}
+
+func (t modelTable[T]) dump(tx *gorm.DB, secrets bool) (any, error) {
+	rows := []T{}
+	err := tx.Find(&rows).Error
+	return rows, err
+}
+
+func (t modelTable[T]) validate(data []byte) error {
+	var rows []T
+	return json.Unmarshal(data, &rows)
+}
+
+func (t modelTable[T]) restore(tx *gorm.DB, data []byte, secrets bool) error {
+	var rows []T
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return err
+	}
+	if err := tx.Delete(new(T)).Error; err != nil {
+		return err
+	}
+	for i := range rows {
+		if err := tx.Create(&rows[i]).Error; err != nil {
+			return err
+		}
+	}
+	return global.ResetSequence(tx, new(T))
+}
+
+// config needs special care as secrets may be left out
+type configTable struct {
+	modelTable[model.Config]
+}
+
+func (t configTable) dump(tx *gorm.DB, secrets bool) (any, error) {
+	rows := []model.Config{}
+	query := tx
+	if !secrets {
+		query = tx.Where("name NOT IN (?)", global.SecretConfigKeys)
+	}
+	err := query.Find(&rows).Error
+	return rows, err
+}
+
+func (t configTable) restore(tx *gorm.DB, data []byte, secrets bool) error {
+	var rows []model.Config
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return err
+	}
+	query := tx
+	if !secrets {
+		// keep the credentials of this instance when the backup has none
+		query = tx.Where("name NOT IN (?)", global.SecretConfigKeys)
+	}
+	if err := query.Delete(model.Config{}).Error; err != nil {
+		return err
+	}
+	for i := range rows {
+		if err := tx.Save(&rows[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// a table of credentials, it is left out of backups without secrets and kept as is when restoring one
+type secretTable struct {
+	backupTable
+}
+
+func (t secretTable) dump(tx *gorm.DB, secrets bool) (any, error) {
+	if !secrets {
+		return []any{}, nil
+	}
+	return t.backupTable.dump(tx, secrets)
+}
+
+func (t secretTable) restore(tx *gorm.DB, data []byte, secrets bool) error {
+	if !secrets {
+		return nil
+	}
+	return t.backupTable.restore(tx, data, secrets)
+}
+
+var backupTables = []backupTable{
+	configTable{modelTable[model.Config]{"config"}},
+	modelTable[model.Channel]{"channels"},
+	modelTable[model.SubChannel]{"sub_channels"},
+	modelTable[model.ChannelOverride]{"channel_overrides"},
+	modelTable[model.FilterRule]{"filter_rules"},
+	secretTable{modelTable[model.APIKey]{"api_keys"}},
+	secretTable{modelTable[model.Webhook]{"webhooks"}},
+	secretTable{modelTable[model.Subscriber]{"subscribers"}},
+	secretTable{modelTable[model.User]{"users"}},
+	modelTable[model.AuditEntry]{"audit_entries"},
+	modelTable[model.AccessPolicy]{"access_policies"},
+}
+
+// WriteBackup writes a consistent snapshot of the database and the uploaded playlists as a zip archive
+func WriteBackup(w io.Writer, secrets bool) error {
+	manifest := BackupManifest{
+		App:     "livetv",
+		Version: backupVersion,
+		Created: time.Now(),
+		Secrets: secrets,
+	}
+	// read every table inside one transaction so that the snapshot is consistent
+	tx := global.DB.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	var schema model.SchemaVersion
+	if err := tx.Order("version desc").First(&schema).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		tx.Rollback()
+		return err
+	}
+	manifest.Schema = schema.Version
+	dumps := make(map[string][]byte)
+	for _, table := range backupTables {
+		rows, err := table.dump(tx, secrets)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		js, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		dumps[table.file()] = js
+		manifest.Tables = append(manifest.Tables, table.file())
+	}
+	tx.Rollback()
+
+	zw := zip.NewWriter(w)
+	js, _ := json.MarshalIndent(manifest, "", "  ")
+	files := map[string][]byte{backupManifest: js}
+	for name, data := range dumps {
+		files[name] = data
+	}
+	for name, data := range files {
+		if err := addZipFile(zw, name, data, manifest.Created); err != nil {
+			return err
+		}
+	}
+	if playlists, err := ListPlaylistFiles(); err == nil {
+		for _, pl := range playlists {
+			data, err := os.ReadFile(filepath.Join(PlaylistDir(), pl.Name))
+			if err != nil {
+				continue
+			}
+			if err = addZipFile(zw, backupPlaylists+pl.Name, data, pl.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+	return zw.Close()
+}
+
+func addZipFile(zw *zip.Writer, name string, data []byte, modified time.Time) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, 256*1024*1024))
+}
+
+// RestoreBackup validates a backup archive and replaces the database content and playlists with it.
+// It is meant to be run before the server starts, as no cache is refreshed.
+func RestoreBackup(file string) (*BackupManifest, error) {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	mf, ok := files[backupManifest]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing manifest", errInvalidBackup)
+	}
+	data, err := readZipFile(mf)
+	if err != nil {
+		return nil, err
+	}
+	var manifest BackupManifest
+	if err = json.Unmarshal(data, &manifest); err != nil || manifest.App != "livetv" {
+		return nil, fmt.Errorf("%w: bad manifest", errInvalidBackup)
+	}
+	if manifest.Version > backupVersion {
+		return nil, fmt.Errorf("%w: version %d is newer than supported", errInvalidBackup, manifest.Version)
+	}
+	if manifest.Schema > global.LatestSchemaVersion() {
+		return nil, fmt.Errorf("%w: %w", errInvalidBackup, global.ErrSchemaTooNew)
+	}
+
+	// validate everything before touching the database
+	tables := make(map[string][]byte)
+	for _, table := range backupTables {
+		f, ok := files[table.file()]
+		if !ok {
+			continue // tables added after the backup was taken
+		}
+		if data, err = readZipFile(f); err != nil {
+			return nil, err
+		}
+		if err = table.validate(data); err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", errInvalidBackup, table.file(), err.Error())
+		}
+		tables[table.file()] = data
+	}
+
+	tx := global.DB.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	for _, table := range backupTables {
+		if data, ok := tables[table.file()]; ok {
+			if err = table.restore(tx, data, manifest.Secrets); err != nil {
+				tx.Rollback()
+				return nil, fmt.Errorf("%s: %w", table.file(), err)
+			}
+		}
+	}
+	if err = tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	for name, f := range files {
+		if !strings.HasPrefix(name, backupPlaylists) {
+			continue
+		}
+		plName := path.Base(name)
+		plPath, err := playlistFilePath(plName)
+		if err != nil {
+			continue
+		}
+		if data, err = readZipFile(f); err != nil {
+			return &manifest, err
+		}
+		os.MkdirAll(PlaylistDir(), os.ModePerm)
+		if err = os.WriteFile(plPath, data, 0644); err != nil {
+			return &manifest, err
+		}
+	}
+	// run the migrations newer than the backup again to bring restored data up to date
+	if err = global.ResetSchemaVersion(manifest.Schema); err != nil {
+		return &manifest, err
+	}
+	if err = global.MigrateDB(); err != nil {
+		return &manifest, err
+	}
+	global.InvalidateChannelServers()
+	// running instances sharing the database reload everything
+	global.NotifyChange(global.ScopeConfig)
+	global.NotifyChange(global.ScopeChannels)
+	global.NotifyChange(global.ScopeWebhooks)
+	global.NotifyChange(global.ScopeSubscribers)
+	global.NotifyChange(global.ScopeAccess)
+	return &manifest, nil
+}
+
+func BackupDir() string {
+	return filepath.Join(os.Getenv("LIVETV_DATADIR"), "backups")
+}
+
+// ScheduledBackup writes a backup into the data dir when the configured interval has passed and rotates old backups
+func ScheduledBackup() {
+	interval, _ := global.GetConfig("backup_interval")
+	hours, _ := strconv.Atoi(interval)
+	if hours <= 0 {
+		return
+	}
+	dir := BackupDir()
+	if lastBackup.IsZero() {
+		// pick up where we left off before a restart
+		backups, _ := filepath.Glob(filepath.Join(dir, "livetv-*.zip"))
+		for _, b := range backups {
+			if fi, err := os.Stat(b); err == nil && fi.ModTime().After(lastBackup) {
+				lastBackup = fi.ModTime()
+			}
+		}
+	}
+	if time.Since(lastBackup) < time.Duration(hours)*time.Hour {
+		return
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		log.Println("backup:", err)
+		return
+	}
+	name := filepath.Join(dir, "livetv-"+time.Now().Format("20060102-150405")+".zip")
+	f, err := os.Create(name)
+	if err != nil {
+		log.Println("backup:", err)
+		return
+	}
+	err = WriteBackup(f, true)
+	f.Close()
+	if err != nil {
+		log.Println("backup:", err)
+		os.Remove(name)
+		return
+	}
+	lastBackup = time.Now()
+	log.Println("Backup written to", name)
+
+	keepCfg, _ := global.GetConfig("backup_keep")
+	keep, _ := strconv.Atoi(keepCfg)
+	if keep <= 0 {
+		return
+	}
+	backups, _ := filepath.Glob(filepath.Join(dir, "livetv-*.zip"))
+	sort.Strings(backups) // names are timestamps, oldest first
+	for len(backups) > keep {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
@@ -33,7 +33,7 @@ func loadChannel(ch *model.Channel, loadSubChannels bool) {
 		if liveInfo, ok := global.URLCache.Load(ch.URL); ok {
 			if p, err := GetPlugin(ch.Parser); err == nil {
 				if provider, ok := p.(ChannalProvider); ok {
-					ch.Children = provider.Channels(ch, liveInfo)
+					ch.Children = applyChannelOverrides(ch, provider.Channels(ch, liveInfo))
 				}
 			}
 		}
@@ -70,10 +70,25 @@ func SaveChannel(channel *model.Channel) error {
 	channel.Children = []*model.Channel{}
 	err := global.DB.Save(channel).Error
 	channel.Children = children
+	if err == nil {
+		global.InvalidateChannelServers()
+		global.NotifyChange(global.ScopeChannels)
+	}
 	return err
 }
 
 func DeleteChannel(id int) error {
+	forgetChannel(id)
+	err := global.DB.Delete(model.Channel{}, "id = ?", id).Error
+	if err == nil {
+		global.InvalidateChannelServers()
+		global.NotifyChange(global.ScopeChannels)
+	}
+	return err
+}
+
+// cancel the parser of a channel and drop it and its sub channels from the caches
+func forgetChannel(id int) {
 	var keys []string
 	CancelChannelParser(id) // cancel the parser
 	// iterate and delete the channel and all its subchannels
@@ -88,7 +103,6 @@ func DeleteChannel(id int) error {
 	for _, key := range keys {
 		global.ChannelCache.Delete(key)
 	}
-	return global.DB.Delete(model.Channel{}, "id = ?", id).Error
 }
 
 func InvalidateChannelCache(channels ...*model.Channel) {
@@ -136,10 +150,33 @@ func GetChannel(channelNumber int, subNumber int) (*model.Channel, error) {
 	}
 }
 
+// GetChannelTree returns a primary channel together with its sub channels
+func GetChannelTree(channelNumber int) (*model.Channel, error) {
+	var channel model.Channel
+	if err := global.DB.Where("id = ?", channelNumber).First(&channel).Error; err != nil {
+		return nil, errChannelNotFound
+	}
+	loadChannel(&channel, true)
+	return &channel, nil
+}
+
+// ProvidesSubChannels reports whether channels using the parser are playlists with sub channels
+func ProvidesSubChannels(parser string) bool {
+	if p, err := GetPlugin(parser); err == nil {
+		if _, ok := p.(ChannalProvider); ok {
+			return true
+		}
+	}
+	return false
+}
+
 const SALT string = "LiVeTv"
 
 func generateToken(channelNumber string) string {
-	secret := global.GetSecretToken()
+	return channelToken(global.GetSecretToken(), channelNumber)
+}
+
+func channelToken(secret string, channelNumber string) string {
 	if secret == "" {
 		return ""
 	}
@@ -147,3 +184,15 @@ func generateToken(channelNumber string) string {
 	hash := md5.Sum([]byte(text))
 	return base64.URLEncoding.EncodeToString(hash[:])[1:10]
 }
+
+// CheckChannelToken tells whether token lets a client watch a channel, tokens of the previous secret work during a rotation
+func CheckChannelToken(ch *model.Channel, token string) bool {
+	if token == ch.Token {
+		return true
+	}
+	if prev, ok := global.PreviousSecretToken(); ok && token == channelToken(prev, ch.ChannelID) {
+		global.CountOldToken()
+		return true
+	}
+	return false
+}
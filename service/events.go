@@ -0,0 +1,156 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/snowie2000/livetv/model"
+)
+
+// event types
+const (
+	EventStatusChanged  = "status.changed"
+	EventParseFailed    = "parse.failed"
+	EventEntriesChanged = "playlist.entries"
+	EventLogin          = "admin.login"
+	EventLoginFailed    = "admin.loginfailed"
+)
+
+// Event is something that happened to a channel or to the admin interface
+type Event struct {
+	Type      string         `json:"type"`
+	Time      time.Time      `json:"time"`
+	ChannelID string         `json:"channel,omitempty"`
+	Name      string         `json:"name,omitempty"`
+	URL       string         `json:"url,omitempty"`
+	Status    string         `json:"status,omitempty"`
+	Previous  string         `json:"previous,omitempty"`
+	Title     string         `json:"title"`
+	Message   string         `json:"message"`
+	Data      map[string]any `json:"data,omitempty"`
+}
+
+var (
+	eventQueue       = make(chan Event, 256)
+	subscribersLock  sync.RWMutex
+	subscribers      []func(Event)
+	entryCountsLock  sync.Mutex
+	entryCounts      = make(map[int]int) // provider channel id => number of sub channels at the last parse
+	StatusNames      = map[int]string{Unknown: "unknown", Ok: "ok", Warning: "warning", Error: "error", Expired: "expired"}
+	eventDescription = map[string]string{
+		EventStatusChanged:  "channel status changes",
+		EventParseFailed:    "failed parses",
+		EventEntriesChanged: "playlist entry count changes",
+		EventLogin:          "admin logins",
+		EventLoginFailed:    "failed admin logins",
+	}
+)
+
+// EventTypes lists the events that can be subscribed to
+func EventTypes() map[string]string {
+	return eventDescription
+}
+
+// Subscribe registers a function that receives every event, events are delivered one by one from a single goroutine
+func Subscribe(fn func(Event)) {
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+	subscribers = append(subscribers, fn)
+}
+
+// Publish queues an event for the subscribers without blocking the caller
+func Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case eventQueue <- e:
+	default:
+		log.Println("event queue is full, dropping", e.Type)
+	}
+}
+
+func dispatchEvents() {
+	for e := range eventQueue {
+		subscribersLock.RLock()
+		list := subscribers
+		subscribersLock.RUnlock()
+		for _, fn := range list {
+			fn(e)
+		}
+	}
+}
+
+func channelEvent(eventType string, channel *model.Channel, url string) Event {
+	e := Event{Type: eventType, URL: url}
+	if channel != nil {
+		e.ChannelID = channel.ChannelID
+		e.Name = channel.Name
+	}
+	return e
+}
+
+// how a channel is called in notifications
+func (e *Event) channelName() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return e.URL
+}
+
+func publishStatusChange(channel *model.Channel, url string, previous int, status int, msg string) {
+	e := channelEvent(EventStatusChanged, channel, url)
+	e.Status = StatusNames[status]
+	e.Previous = StatusNames[previous]
+	e.Title = "LiveTV: " + e.channelName() + " is " + e.Status
+	if status == Ok && previous != Unknown {
+		e.Title = "LiveTV: " + e.channelName() + " has recovered"
+	}
+	e.Message = msg
+	Publish(e)
+}
+
+func publishParseFailure(channel *model.Channel, err error) {
+	e := channelEvent(EventParseFailed, channel, channel.URL)
+	e.Title = "LiveTV: failed to parse " + e.channelName()
+	e.Message = err.Error()
+	Publish(e)
+}
+
+// publish an event when a provider playlist has a different number of entries than at its last parse
+func checkEntryCount(parent *model.Channel, count int) {
+	entryCountsLock.Lock()
+	previous, known := entryCounts[parent.ID]
+	entryCounts[parent.ID] = count
+	entryCountsLock.Unlock()
+	if !known || previous == count {
+		return
+	}
+	e := channelEvent(EventEntriesChanged, parent, parent.URL)
+	e.Title = "LiveTV: " + e.channelName() + " has changed"
+	e.Message = fmt.Sprintf("%d entries, %d before", count, previous)
+	e.Data = map[string]any{"previous": previous, "current": count}
+	Publish(e)
+}
+
+// PublishLogin tells subscribers about a login attempt to the admin interface
+func PublishLogin(ok bool, user string, ip string) {
+	e := Event{
+		Type:    EventLogin,
+		Title:   "LiveTV: admin login",
+		Message: "login of " + user + " from " + ip,
+		Data:    map[string]any{"ip": ip, "user": user},
+	}
+	if !ok {
+		e.Type = EventLoginFailed
+		e.Title = "LiveTV: failed admin login"
+		e.Message = "failed login of " + user + " from " + ip
+	}
+	Publish(e)
+}
+
+func init() {
+	go dispatchEvents()
+}
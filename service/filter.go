@@ -0,0 +1,180 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/syncx"
+)
+
+const (
+	FilterInclude = "include"
+	FilterExclude = "exclude"
+)
+
+var (
+	filterCache      syncx.Map[int, []model.FilterRule]
+	errInvalidFilter = errors.New("Invalid filter rule")
+)
+
+// FilterEntry is what a filter rule can look at in a playlist entry
+type FilterEntry struct {
+	Name  string
+	Group string
+	URL   string
+	Attrs map[string]string
+}
+
+type compiledRule struct {
+	field string
+	exp   *regexp.Regexp
+}
+
+type EntryFilter struct {
+	includes []compiledRule
+	excludes []compiledRule
+}
+
+func (r *compiledRule) match(entry *FilterEntry) bool {
+	var value string
+	switch r.field {
+	case "name":
+		value = entry.Name
+	case "group":
+		value = entry.Group
+	case "url":
+		value = entry.URL
+	case "host":
+		if u, err := url.Parse(entry.URL); err == nil {
+			value = u.Hostname()
+		}
+	default:
+		value = entry.Attrs[r.field]
+	}
+	return r.exp.MatchString(value)
+}
+
+// NewEntryFilter compiles filter rules, a nil filter keeps everything
+func NewEntryFilter(rules []model.FilterRule) (*EntryFilter, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	f := &EntryFilter{}
+	for _, rule := range rules {
+		field := strings.ToLower(strings.TrimSpace(rule.Field))
+		if field == "" {
+			return nil, fmt.Errorf("%w: empty field", errInvalidFilter)
+		}
+		exp, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidFilter, err.Error())
+		}
+		cr := compiledRule{field: field, exp: exp}
+		switch strings.ToLower(rule.Action) {
+		case FilterInclude:
+			f.includes = append(f.includes, cr)
+		case FilterExclude:
+			f.excludes = append(f.excludes, cr)
+		default:
+			return nil, fmt.Errorf("%w: unknown action %s", errInvalidFilter, rule.Action)
+		}
+	}
+	return f, nil
+}
+
+// Keep reports whether an entry survives the filter.
+// When include rules exist an entry must match one of them, and any matching exclude rule drops it.
+func (f *EntryFilter) Keep(entry *FilterEntry) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.includes) > 0 {
+		included := false
+		for i := range f.includes {
+			if f.includes[i].match(entry) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for i := range f.excludes {
+		if f.excludes[i].match(entry) {
+			return false
+		}
+	}
+	return true
+}
+
+func GetFilterRules(channelID int) ([]model.FilterRule, error) {
+	if rules, ok := filterCache.Load(channelID); ok {
+		return rules, nil
+	}
+	var rules []model.FilterRule
+	err := global.DB.Where("channel_id = ?", channelID).Order("id").Find(&rules).Error
+	if err == nil {
+		filterCache.Store(channelID, rules)
+	}
+	return rules, err
+}
+
+// GetChannelFilter returns the compiled filter of a provider channel
+func GetChannelFilter(channelID int) (*EntryFilter, error) {
+	if channelID <= 0 {
+		return nil, nil
+	}
+	rules, err := GetFilterRules(channelID)
+	if err != nil {
+		return nil, err
+	}
+	return NewEntryFilter(rules)
+}
+
+// SaveFilterRules replaces all filter rules of a channel
+func SaveFilterRules(channelID int, rules []model.FilterRule) error {
+	tx := global.DB.Begin()
+	if err := saveFilterRules(tx, channelID, rules); err != nil {
+		tx.Rollback()
+		return err
+	}
+	err := tx.Commit().Error
+	filterCache.Delete(channelID)
+	if err == nil {
+		global.NotifyChange(global.ScopeChannels)
+	}
+	return err
+}
+
+// replace the filter rules of a channel inside a transaction
+func saveFilterRules(tx *gorm.DB, channelID int, rules []model.FilterRule) error {
+	if _, err := NewEntryFilter(rules); err != nil {
+		return err
+	}
+	if err := tx.Delete(model.FilterRule{}, "channel_id = ?", channelID).Error; err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		rule.ID = 0
+		rule.ChannelID = channelID
+		rule.Action = strings.ToLower(rule.Action)
+		rule.Field = strings.ToLower(strings.TrimSpace(rule.Field))
+		if err := tx.Create(&rule).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteFilterRules removes all filter rules of a channel
+func DeleteFilterRules(channelID int) error {
+	filterCache.Delete(channelID)
+	return global.DB.Delete(model.FilterRule{}, "channel_id = ?", channelID).Error
+}
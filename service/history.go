@@ -0,0 +1,279 @@
+package service
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+)
+
+const (
+	statusHistoryDays      = 30   // events older than this are pruned
+	maxStatusEventsPerURL  = 1000 // so that a flapping channel can't fill the table
+	DefaultUptimeDays      = 7
+	uptimeSummaryCacheTime = time.Minute
+)
+
+// UptimeSummary tells how a channel did over a period
+type UptimeSummary struct {
+	Uptime   float64 `json:"uptime"`   // percentage of the known time the channel was up, -1 when nothing is known
+	MTTR     int64   `json:"mttr"`     // mean time to recovery in seconds
+	Failures int     `json:"failures"` // number of times the channel went down
+}
+
+type StatusEventInfo struct {
+	Time        time.Time `json:"time"`
+	Status      int       `json:"status"`
+	Category    string    `json:"category,omitempty"`
+	Message     string    `json:"message"`
+	ResolvedURL string    `json:"resolvedurl,omitempty"`
+	Plugin      string    `json:"plugin,omitempty"`
+}
+
+// ChannelHistory is the uptime summary and the recent status changes of a channel url
+type ChannelHistory struct {
+	URL    string            `json:"url"`
+	Days   int               `json:"days"`
+	Events []StatusEventInfo `json:"events"`
+	UptimeSummary
+}
+
+var (
+	uptimeLock      sync.Mutex
+	uptimeSummaries map[string]UptimeSummary
+	uptimeUpdated   time.Time
+)
+
+func urlHash(url string) string {
+	hash := md5.Sum([]byte(url))
+	return hex.EncodeToString(hash[:])
+}
+
+// sort a status message into a rough category so that failures can be counted by cause
+func errorCategory(status int, msg string) string {
+	if status == Ok || status == Unknown {
+		return ""
+	}
+	msg = strings.ToLower(msg)
+	switch {
+	case strings.Contains(msg, "timeout") || strings.Contains(msg, "deadline exceeded"):
+		return "timeout"
+	case strings.Contains(msg, "no such host") || strings.Contains(msg, "connection refused") ||
+		strings.Contains(msg, "connection reset") || strings.Contains(msg, "dial tcp") || strings.Contains(msg, "eof"):
+		return "network"
+	case strings.Contains(msg, "http "):
+		return "http"
+	case strings.Contains(msg, "not currently live"):
+		return "offline"
+	case strings.Contains(msg, "outdated"):
+		return "outdated"
+	case strings.Contains(msg, "unhealthy"):
+		return "unhealthy"
+	case strings.Contains(msg, "not a live stream"):
+		return "content"
+	case strings.Contains(msg, "plugin"):
+		return "plugin"
+	}
+	return "parse"
+}
+
+// channels serving a playlist, even a degraded one, are up
+func isUp(status int) bool {
+	return status == Ok || status == Warning
+}
+
+func recordStatusEvent(url string, status int, category string, msg string, resolved string, plugin string) {
+	if global.DB == nil {
+		return
+	}
+	event := model.StatusEvent{
+		URLHash:     urlHash(url),
+		URL:         url,
+		Time:        time.Now(),
+		Status:      status,
+		Category:    category,
+		Message:     msg,
+		ResolvedURL: resolved,
+		Plugin:      plugin,
+	}
+	if err := global.DB.Create(&event).Error; err != nil {
+		log.Println("failed to record status change:", err)
+	}
+}
+
+// PruneStatusHistory keeps the status history bounded by age and by number of events per url
+func PruneStatusHistory() {
+	cutoff := time.Now().AddDate(0, 0, -statusHistoryDays)
+	if err := global.DB.Delete(model.StatusEvent{}, "time < ?", cutoff).Error; err != nil {
+		log.Println("failed to prune status history:", err)
+		return
+	}
+	var crowded []struct {
+		URLHash string
+		Total   int
+	}
+	err := global.DB.Model(&model.StatusEvent{}).Select("url_hash, count(*) as total").
+		Group("url_hash").Having("count(*) > ?", maxStatusEventsPerURL).Scan(&crowded).Error
+	if err != nil {
+		log.Println("failed to prune status history:", err)
+		return
+	}
+	for _, c := range crowded {
+		var oldest model.StatusEvent
+		// the oldest event we keep
+		err = global.DB.Where("url_hash = ?", c.URLHash).Order("id desc").Offset(maxStatusEventsPerURL - 1).First(&oldest).Error
+		if err == nil {
+			err = global.DB.Delete(model.StatusEvent{}, "url_hash = ? AND id < ?", c.URLHash, oldest.ID).Error
+		}
+		if err != nil {
+			log.Println("failed to prune status history:", err)
+		}
+	}
+}
+
+// summarize a timeline of status changes, start is the state before the first event (nil when unknown)
+func summarize(start *model.StatusEvent, events []model.StatusEvent, since time.Time, until time.Time) UptimeSummary {
+	var up, down, recovery time.Duration
+	recoveries := 0
+	failures := 0
+	state := Unknown
+	var downSince time.Time
+	if start != nil {
+		state = start.Status
+		if !isUp(state) && state != Unknown {
+			downSince = start.Time
+		}
+	}
+	last := since
+	account := func(t time.Time) {
+		switch {
+		case state == Unknown:
+		case isUp(state):
+			up += t.Sub(last)
+		default:
+			down += t.Sub(last)
+		}
+		last = t
+	}
+	for _, e := range events {
+		account(e.Time)
+		wasDown := state != Unknown && !isUp(state)
+		nowDown := e.Status != Unknown && !isUp(e.Status)
+		if nowDown && !wasDown {
+			failures++
+			downSince = e.Time
+		}
+		if wasDown && !nowDown && !downSince.IsZero() {
+			recovery += e.Time.Sub(downSince)
+			recoveries++
+		}
+		state = e.Status
+	}
+	account(until)
+
+	summary := UptimeSummary{Uptime: -1, Failures: failures}
+	if total := up + down; total > 0 {
+		summary.Uptime = float64(up) * 100 / float64(total)
+	}
+	if recoveries > 0 {
+		summary.MTTR = int64((recovery / time.Duration(recoveries)).Seconds())
+	}
+	return summary
+}
+
+// GetChannelHistory returns the uptime and the status changes of a url over the last days
+func GetChannelHistory(url string, days int, limit int) (*ChannelHistory, error) {
+	if days <= 0 {
+		days = DefaultUptimeDays
+	}
+	now := time.Now()
+	since := now.AddDate(0, 0, -days)
+	hash := urlHash(url)
+	var start model.StatusEvent
+	var startPtr *model.StatusEvent
+	err := global.DB.Where("url_hash = ? AND time < ?", hash, since).Order("id desc").First(&start).Error
+	if err == nil {
+		startPtr = &start
+	} else if !gorm.IsRecordNotFoundError(err) {
+		return nil, err
+	}
+	var events []model.StatusEvent
+	if err = global.DB.Where("url_hash = ? AND time >= ?", hash, since).Order("id").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	history := &ChannelHistory{
+		URL:           url,
+		Days:          days,
+		Events:        []StatusEventInfo{},
+		UptimeSummary: summarize(startPtr, events, since, now),
+	}
+	// most recent first
+	for i := len(events) - 1; i >= 0 && len(history.Events) < limit; i-- {
+		e := events[i]
+		history.Events = append(history.Events, StatusEventInfo{
+			Time:        e.Time,
+			Status:      e.Status,
+			Category:    e.Category,
+			Message:     e.Message,
+			ResolvedURL: e.ResolvedURL,
+			Plugin:      e.Plugin,
+		})
+	}
+	return history, nil
+}
+
+// GetUptimeSummary returns the uptime of a url over the default period, summaries of all urls are computed at once and cached for a while
+func GetUptimeSummary(url string) (UptimeSummary, bool) {
+	uptimeLock.Lock()
+	defer uptimeLock.Unlock()
+	if uptimeSummaries == nil || time.Since(uptimeUpdated) > uptimeSummaryCacheTime {
+		summaries, err := computeUptimeSummaries(DefaultUptimeDays)
+		if err != nil {
+			log.Println("failed to compute uptime:", err)
+			return UptimeSummary{}, false
+		}
+		uptimeSummaries = summaries
+		uptimeUpdated = time.Now()
+	}
+	summary, ok := uptimeSummaries[urlHash(url)]
+	return summary, ok
+}
+
+func computeUptimeSummaries(days int) (map[string]UptimeSummary, error) {
+	now := time.Now()
+	since := now.AddDate(0, 0, -days)
+	// the last state of every url before the period
+	var starts []model.StatusEvent
+	sub := global.DB.Model(&model.StatusEvent{}).Select("max(id)").Where("time < ?", since).Group("url_hash").SubQuery()
+	if err := global.DB.Where("id IN ?", sub).Find(&starts).Error; err != nil {
+		return nil, err
+	}
+	var events []model.StatusEvent
+	if err := global.DB.Where("time >= ?", since).Order("id").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	startByURL := make(map[string]*model.StatusEvent, len(starts))
+	for i := range starts {
+		startByURL[starts[i].URLHash] = &starts[i]
+	}
+	eventsByURL := make(map[string][]model.StatusEvent)
+	for _, e := range events {
+		eventsByURL[e.URLHash] = append(eventsByURL[e.URLHash], e)
+	}
+	summaries := make(map[string]UptimeSummary, len(eventsByURL)+len(startByURL))
+	for hash, start := range startByURL {
+		summaries[hash] = summarize(start, eventsByURL[hash], since, now)
+	}
+	for hash, list := range eventsByURL {
+		if _, ok := summaries[hash]; !ok {
+			summaries[hash] = summarize(nil, list, since, now)
+		}
+	}
+	return summaries, nil
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/snowie2000/livetv/playlist/diyp"
+	"github.com/snowie2000/livetv/playlist/m3u"
+	"github.com/snowie2000/livetv/syncx"
+)
+
+// MaxPlaylistSize is the largest playlist that is read, uploaded or imported
+const MaxPlaylistSize = 10 * 1024 * 1024
+
+var (
+	errInvalidPlaylistName = errors.New("Invalid playlist name")
+	errInvalidPlaylist     = errors.New("Unsupported playlist format")
+	errPlaylistTooLarge    = errors.New("playlist too large")
+	errOutsidePlaylistDir  = errors.New("Local playlists have to be in the playlists folder of the data dir")
+	localFileStates        syncx.Map[string, string] // path => size and modification time of the last read
+)
+
+type PlaylistFile struct {
+	Name    string    `json:"name"`
+	URL     string    `json:"url"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+}
+
+// folder for uploaded playlists inside the data dir
+func PlaylistDir() string {
+	return filepath.Join(os.Getenv("LIVETV_DATADIR"), "playlists")
+}
+
+// LocalFilePath converts a file:// url into a local path, local tells whether it is a file:// url at all.
+// file:///abs/path is an absolute path, while file://playlists/name is relative to the data dir so that it survives moving the data dir.
+// Either way the file has to be in the playlists folder, editors must not be able to read the database or anything else on the host.
+func LocalFilePath(rawUrl string) (path string, local bool, err error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || !strings.EqualFold(u.Scheme, "file") {
+		return "", false, nil
+	}
+	dataDir, err := filepath.Abs(os.Getenv("LIVETV_DATADIR"))
+	if err != nil {
+		return "", true, err
+	}
+	if u.Host != "" {
+		path = filepath.Join(dataDir, u.Host, filepath.FromSlash(u.Path))
+	} else {
+		p := u.Path
+		// file:///C:/path on windows
+		if len(p) > 2 && p[0] == '/' && p[2] == ':' {
+			p = p[1:]
+		}
+		path = filepath.Clean(filepath.FromSlash(p))
+	}
+	root := filepath.Join(dataDir, "playlists")
+	if !insideDir(root, path) {
+		return "", true, errOutsidePlaylistDir
+	}
+	// a symlink in the folder must not lead out of it
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return "", true, err
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return "", true, err
+	}
+	if !insideDir(root, path) {
+		return "", true, errOutsidePlaylistDir
+	}
+	return path, true, nil
+}
+
+func insideDir(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// ReadLocalFile reads a local playlist and remembers its state for change detection
+func ReadLocalFile(path string) ([]byte, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > MaxPlaylistSize {
+		return nil, errPlaylistTooLarge
+	}
+	content, err := os.ReadFile(path)
+	if err == nil {
+		localFileStates.Store(path, fileState(fi))
+	}
+	return content, err
+}
+
+func fileState(fi os.FileInfo) string {
+	return fmt.Sprintf("%d|%d", fi.ModTime().UnixNano(), fi.Size())
+}
+
+func playlistFilePath(name string) (string, error) {
+	name = filepath.Base(strings.TrimSpace(name))
+	ext := strings.ToLower(filepath.Ext(name))
+	if name == "." || name == string(filepath.Separator) || strings.HasPrefix(name, ".") {
+		return "", errInvalidPlaylistName
+	}
+	if ext != ".m3u" && ext != ".m3u8" && ext != ".txt" {
+		return "", errInvalidPlaylistName
+	}
+	return filepath.Join(PlaylistDir(), name), nil
+}
+
+// SavePlaylistFile validates and stores an uploaded playlist, returns the file:// url to be used as a channel url
+func SavePlaylistFile(name string, content io.Reader) (string, error) {
+	path, err := playlistFilePath(name)
+	if err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(io.LimitReader(content, MaxPlaylistSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > MaxPlaylistSize {
+		return "", errPlaylistTooLarge
+	}
+	if _, err := m3u.ParseFromReader(bytes.NewReader(data)); err != nil {
+		if list, err := diyp.ParseChannelFromReader(bytes.NewReader(data)); err != nil || len(list.Groups) == 0 {
+			return "", errInvalidPlaylist
+		}
+	}
+	if err = os.MkdirAll(PlaylistDir(), os.ModePerm); err != nil {
+		return "", err
+	}
+	// write to a temp file first so that a running parse never sees a partial playlist
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return "", err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	go CheckLocalPlaylists()
+	return "file://playlists/" + filepath.Base(path), nil
+}
+
+func ListPlaylistFiles() ([]PlaylistFile, error) {
+	entries, err := os.ReadDir(PlaylistDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []PlaylistFile{}, nil
+		}
+		return nil, err
+	}
+	files := make([]PlaylistFile, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if _, err := playlistFilePath(entry.Name()); err != nil {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, PlaylistFile{
+			Name:    entry.Name(),
+			URL:     "file://playlists/" + entry.Name(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+	}
+	sort.Slice(files, func(a, b int) bool {
+		return files[a].Name < files[b].Name
+	})
+	return files, nil
+}
+
+func DeletePlaylistFile(name string) error {
+	path, err := playlistFilePath(name)
+	if err != nil {
+		return err
+	}
+	localFileStates.Delete(path)
+	return os.Remove(path)
+}
+
+// CheckLocalPlaylists reparses channels whose local playlist has changed since it was last read
+func CheckLocalPlaylists() {
+	channels, err := GetAllChannel()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, ch := range channels {
+		path, ok, err := LocalFilePath(ch.URL)
+		if !ok || err != nil {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if state, ok := localFileStates.Load(path); ok && state == fileState(fi) {
+			continue
+		}
+		log.Println(ch.URL, "has changed, reloading")
+		UpdateURLCacheSingle(ch, true)
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalFilePath(t *testing.T) {
+	dataDir := t.TempDir()
+	outside := t.TempDir()
+	t.Setenv("LIVETV_DATADIR", dataDir)
+	playlists := filepath.Join(dataDir, "playlists")
+	files := map[string]string{
+		filepath.Join(playlists, "tv.m3u"):   "#EXTM3U",
+		filepath.Join(dataDir, "livetv.db"):  "db",
+		filepath.Join(outside, "secret.m3u"): "secret",
+	}
+	if err := os.MkdirAll(playlists, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.m3u"), filepath.Join(playlists, "link.m3u")); err != nil {
+		t.Skip("no symlinks:", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(playlists, "linkdir")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		url   string
+		local bool
+		ok    bool
+	}{
+		{"relative to the data dir", "file://playlists/tv.m3u", true, true},
+		{"absolute", "file://" + filepath.ToSlash(filepath.Join(playlists, "tv.m3u")), true, true},
+		{"database", "file://livetv.db", true, false},
+		{"absolute database", "file://" + filepath.ToSlash(filepath.Join(dataDir, "livetv.db")), true, false},
+		{"dot dot", "file://playlists/../livetv.db", true, false},
+		{"outside", "file://" + filepath.ToSlash(filepath.Join(outside, "secret.m3u")), true, false},
+		{"symlink out of the folder", "file://playlists/link.m3u", true, false},
+		{"symlinked folder", "file://playlists/linkdir/secret.m3u", true, false},
+		{"missing", "file://playlists/none.m3u", true, false},
+		{"http", "http://example.com/tv.m3u", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, local, err := LocalFilePath(tt.url)
+			if local != tt.local || (err == nil && local) != tt.ok {
+				t.Fatalf("LocalFilePath(%s) = %q, %v, %v, want local = %v, ok = %v", tt.url, path, local, err, tt.local, tt.ok)
+			}
+			if tt.ok {
+				if content, err := os.ReadFile(path); err != nil || string(content) != "#EXTM3U" {
+					t.Errorf("%s reads %q, %v", path, content, err)
+				}
+			}
+		})
+	}
+}
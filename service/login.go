@@ -0,0 +1,89 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// failed logins tolerated before the client, or everybody, has to wait
+const (
+	clientFreeLogins = 3
+	globalFreeLogins = 20
+)
+
+const (
+	loginBackoff       = time.Second      // first wait, doubled with every further failure
+	clientLockoutLimit = 15 * time.Minute // longest wait of a client
+	globalLockoutLimit = 5 * time.Minute  // shorter, as a global lockout locks the admin out too
+	loginForget        = time.Hour        // failures are forgotten after this long without another one
+)
+
+type loginFailures struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+func (f *loginFailures) fail(now time.Time, free int, limit time.Duration) {
+	if now.Sub(f.last) > loginForget {
+		f.count = 0
+	}
+	f.count++
+	f.last = now
+	if f.count > free {
+		wait := limit
+		if shift := f.count - free - 1; shift < 30 {
+			wait = min(loginBackoff<<shift, limit)
+		}
+		f.until = now.Add(wait)
+	}
+}
+
+var (
+	loginLock    sync.Mutex
+	clientLogins = make(map[string]*loginFailures)
+	globalLogins loginFailures
+	loginPruned  time.Time
+)
+
+// LoginLocked returns how long a client has to wait before it may try to log in again, 0 when it may try now
+func LoginLocked(ip string) time.Duration {
+	loginLock.Lock()
+	defer loginLock.Unlock()
+	now := time.Now()
+	wait := globalLogins.until.Sub(now)
+	if f, ok := clientLogins[ip]; ok {
+		wait = max(wait, f.until.Sub(now))
+	}
+	return max(wait, 0)
+}
+
+// LoginFailed records a failed login of a client and locks it out for longer with every failure
+func LoginFailed(ip string) {
+	loginLock.Lock()
+	defer loginLock.Unlock()
+	now := time.Now()
+	if now.Sub(loginPruned) > loginForget {
+		for key, f := range clientLogins {
+			if now.Sub(f.last) > loginForget {
+				delete(clientLogins, key)
+			}
+		}
+		loginPruned = now
+	}
+	f, ok := clientLogins[ip]
+	if !ok {
+		f = &loginFailures{}
+		clientLogins[ip] = f
+	}
+	f.fail(now, clientFreeLogins, clientLockoutLimit)
+	globalLogins.fail(now, globalFreeLogins, globalLockoutLimit)
+}
+
+// LoginSucceeded forgets the failures of a client once it has logged in
+func LoginSucceeded(ip string) {
+	loginLock.Lock()
+	defer loginLock.Unlock()
+	delete(clientLogins, ip)
+	globalLogins = loginFailures{}
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -10,7 +11,20 @@ import (
 	"github.com/snowie2000/livetv/model"
 )
 
-func M3UGenerate() (string, error) {
+// playlist attributes of sub channels that are passed on to our own playlist
+var passthroughAttrs = []string{"tvg-id", "tvg-chno", "tvg-shift", "tvg-country", "tvg-language"}
+
+// LiveQuery is the query of a link to a channel, signed when signed links are enabled
+func LiveQuery(token string, channelID string, client global.LinkClient) string {
+	query := url.Values{}
+	query.Set("token", token)
+	query.Set("c", channelID)
+	global.SignLink("live.m3u8", query, client)
+	return query.Encode()
+}
+
+// M3UGenerate writes the playlist of all channels, or of the channels a subscriber may watch when sub isn't nil
+func M3UGenerate(sub *model.Subscriber, client global.LinkClient) (string, error) {
 	baseUrl, err := global.GetConfig("base_url")
 	if err != nil {
 		log.Println(err)
@@ -23,6 +37,12 @@ func M3UGenerate() (string, error) {
 	}
 	var m3u strings.Builder
 	writeChannel := func(ch *model.Channel) {
+		if sub != nil && !SubscriberAllows(sub, ch) {
+			return
+		}
+		if !PlaybackAllowed(client.IP, ch) {
+			return
+		}
 		logo := ""
 		category := "LiveTV"
 		if ch.Category != "" {
@@ -34,8 +54,18 @@ func M3UGenerate() (string, error) {
 		if ch.Logo != "" {
 			logo = ch.Logo
 		}
-		liveData := fmt.Sprintf("#EXTINF:-1, tvg-name=%s tvg-logo=%s group-title=%s, %s\n", strconv.Quote(ch.Name), strconv.Quote(logo), strconv.Quote(category), ch.Name)
-		composedUrl := fmt.Sprintf("%s/live.m3u8?token=%s&c=%s", baseUrl, ch.Token, ch.ChannelID)
+		var attrs strings.Builder
+		for _, name := range passthroughAttrs {
+			if value, ok := ch.Attrs[name]; ok && value != "" {
+				attrs.WriteString(fmt.Sprintf(" %s=%s", name, strconv.Quote(value)))
+			}
+		}
+		liveData := fmt.Sprintf("#EXTINF:-1,%s tvg-name=%s tvg-logo=%s group-title=%s, %s\n", attrs.String(), strconv.Quote(ch.Name), strconv.Quote(logo), strconv.Quote(category), ch.Name)
+		token := ch.Token
+		if sub != nil {
+			token = sub.Token
+		}
+		composedUrl := baseUrl + "/live.m3u8?" + LiveQuery(token, ch.ChannelID, client)
 		if ch.CustomQueryString != "" {
 			composedUrl = composedUrl + "&" + ch.CustomQueryString
 		}
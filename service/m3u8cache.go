@@ -35,7 +35,10 @@ func UpdateSubChannels(parentChannel *model.Channel, liveInfo *model.LiveInfo, P
 	// let's check if there are any sub channels
 	if p, err := GetPlugin(Parser); err == nil {
 		if provider, ok := p.(ChannalProvider); ok {
-			subchannels := provider.Channels(parentChannel, liveInfo)
+			subchannels := applyChannelOverrides(parentChannel, provider.Channels(parentChannel, liveInfo))
+			if parentChannel.ChannelID == parentChannel.ParentID {
+				checkEntryCount(parentChannel, len(subchannels))
+			}
 			canceled := false
 			if len(subchannels) > 0 {
 				// create a canceler
@@ -68,17 +71,19 @@ func UpdateURLCacheSingle(channel *model.Channel, bUpdateStatus bool) (*model.Li
 	liveInfo, err := RealLiveM3U8(channel)
 	if err != nil {
 		if errors.Is(err, RetryOutdated) {
-			UpdateStatus(channel.URL, Warning, err.Error())
+			UpdateChannelStatus(channel, liveInfo, Warning, err.Error())
 		} else {
 			global.URLCache.Delete(channel.URL)
-			UpdateStatus(channel.URL, Error, err.Error())
+			UpdateChannelStatus(channel, liveInfo, Error, err.Error())
 		}
 		log.Println("[LiveTV]", err)
+		publishParseFailure(channel, err)
 	} else {
 		// cache parsed result
 		global.URLCache.Store(channel.URL, liveInfo)
+		rememberParsed(channel)
 		if bUpdateStatus {
-			UpdateStatus(channel.URL, Ok, "Live!")
+			UpdateChannelStatus(channel, liveInfo, Ok, "Live!")
 		}
 		log.Println(channel.URL, "cached")
 
@@ -31,6 +31,7 @@ var errNoMatchFound error = errors.New("This channel is not currently live")
 
 func GetLiveM3U8(channel *model.Channel) (*model.LiveInfo, error) {
 	liveInfo, ok := global.URLCache.Load(channel.URL)
+	CountCacheLookup(URLCacheName, ok)
 	if ok {
 		return liveInfo, nil
 	} else {
@@ -76,11 +77,11 @@ func GetM3U8Content(c *gin.Context, Channel *model.Channel, liveInfo *model.Live
 			// this channel was previously running ok, we give it a chance to reparse itself
 			log.Println(Channel.URL, "is unhealthy, doing a reparse...")
 			if li, err := UpdateURLCacheSingle(Channel, false); err == nil {
-				UpdateStatus(Channel.URL, Warning, "Unhealthy")
+				UpdateChannelStatus(Channel, li, Warning, "Unhealthy")
 				bodyString, newUrl, err = GetM3U8Content(c, Channel, li, true)
 				if err == nil {
 					log.Println(Channel.URL, "is back online now")
-					UpdateStatus(Channel.URL, Ok, "Live!") // revert our temporary warning status to ok
+					UpdateChannelStatus(Channel, li, Ok, "Live!") // revert our temporary warning status to ok
 				} else {
 					log.Println(Channel.URL, "is still unhealthy, giving up, currently points to", li.LiveUrl)
 				}
@@ -105,7 +106,7 @@ func GetM3U8Content(c *gin.Context, Channel *model.Channel, liveInfo *model.Live
 	}
 	client := http.Client{
 		Timeout:   global.HttpClientTimeout,
-		Transport: global.TransportWithProxy(""),
+		Transport: global.UpstreamTransport(""),
 		Jar:       global.CookieJar,
 	}
 	req, err := http.NewRequest(http.MethodGet, liveInfo.LiveUrl, nil)
@@ -176,7 +177,7 @@ func GetM3U8Content(c *gin.Context, Channel *model.Channel, liveInfo *model.Live
 			}
 		}
 	} else {
-		UpdateStatus(Channel.URL, Warning, "Url is not a live stream")
+		UpdateChannelStatus(Channel, liveInfo, Warning, "Url is not a live stream")
 		duration, err := GetVideoDuration(Channel.URL)
 		if err == nil && duration > 0 {
 			log.Println(Channel.URL, "duration is", duration)
@@ -190,29 +191,41 @@ func GetM3U8Content(c *gin.Context, Channel *model.Channel, liveInfo *model.Live
 }
 
 func RealLiveM3U8(channel *model.Channel) (*model.LiveInfo, error) {
+	p, err := channelPlugin(channel)
+	if err != nil {
+		return nil, err
+	}
+	//if liveInfo, ok := global.URLCache.Load(channel.URL); ok {
+	//	//return p.Parse(channel.URL, channel.ProxyUrl, liveInfo.ExtraInfo)
+	//	return p.Parse(channel, liveInfo)
+	//}
+	start := time.Now()
+	liveInfo, err := p.Parse(channel, &model.LiveInfo{})
+	observeParse(channel.Parser, time.Since(start), err)
+	return liveInfo, err
+}
+
+// find the plugin that parses a channel, detectors hand the channel over to the plugin they detect
+func channelPlugin(channel *model.Channel) (Plugin, error) {
 	Parser := channel.Parser
 	if Parser == "" {
 		Parser = "youtube" // backward compatible with old database, use youtube parser by default
 	}
-	if p, err := GetPlugin(Parser); err == nil {
-		//if liveInfo, ok := global.URLCache.Load(channel.URL); ok {
-		//	//return p.Parse(channel.URL, channel.ProxyUrl, liveInfo.ExtraInfo)
-		//	return p.Parse(channel, liveInfo)
-		//}
-		if d, ok := p.(Detector); ok {
-			newPlugin, err := d.Detect(channel)
-			if err != nil {
-				return nil, err
-			}
-			if p, err = GetPlugin(newPlugin); err != nil {
-				return nil, err
-			}
-			channel.Parser = newPlugin
-		}
-		return p.Parse(channel, &model.LiveInfo{})
-	} else {
+	p, err := GetPlugin(Parser)
+	if err != nil {
 		return nil, err
 	}
+	if d, ok := p.(Detector); ok {
+		newPlugin, err := d.Detect(channel)
+		if err != nil {
+			return nil, err
+		}
+		if p, err = GetPlugin(newPlugin); err != nil {
+			return nil, err
+		}
+		channel.Parser = newPlugin
+	}
+	return p, nil
 }
 
 func init() {
@@ -3,6 +3,7 @@ package service
 import (
 	"bytes"
 	"fmt"
+	"net/url"
 	"strings"
 	"time"
 
@@ -37,7 +38,17 @@ func PlaceHolderHLS() string {
 	return fmt.Sprintf(tpl, placeholder, placeholder, placeholder)
 }
 
-func processMediaPlaylist(playlistUrl string, pl *m3u8.MediaPlaylist, prefixURL string, proxyToken string, proxy bool, channelNum int, fnTransform func(raw string, ts string) string) string {
+// query of a link to one of our proxies, signed when signed links are enabled
+func proxyQuery(path string, proxyToken string, client global.LinkClient, uri string, channelID string) string {
+	query := url.Values{}
+	query.Set("token", proxyToken)
+	query.Set("k", util.CompressString(uri))
+	query.Set("c", channelID)
+	global.SignLink(path, query, client)
+	return query.Encode()
+}
+
+func processMediaPlaylist(playlistUrl string, pl *m3u8.MediaPlaylist, prefixURL string, proxyToken string, client global.LinkClient, proxy bool, channelID string, fnTransform func(raw string, ts string) string) string {
 	baseUrl := global.GetBaseURL(playlistUrl)
 	handleUri := func(uri string) string {
 		if uri == "" {
@@ -47,7 +58,7 @@ func processMediaPlaylist(playlistUrl string, pl *m3u8.MediaPlaylist, prefixURL
 			uri = global.CleanUrl(global.MergeUrl(baseUrl, uri))
 		}
 		if proxy {
-			tsLink := global.MergeUrl(prefixURL, fmt.Sprintf("live.ts?token=%s&k=%s&c=%d", proxyToken, util.CompressString(uri), channelNum))
+			tsLink := global.MergeUrl(prefixURL, "live.ts?"+proxyQuery("live.ts", proxyToken, client, uri, channelID))
 			if fnTransform != nil {
 				tsLink = fnTransform(uri, tsLink)
 			}
@@ -70,7 +81,7 @@ func processMediaPlaylist(playlistUrl string, pl *m3u8.MediaPlaylist, prefixURL
 	return pl.Encode().String()
 }
 
-func processMasterPlaylist(playlistUrl string, pl *m3u8.MasterPlaylist, prefixURL string, proxyToken string, proxy bool, channelNum int, fnTransform func(raw string, ts string) string) string {
+func processMasterPlaylist(playlistUrl string, pl *m3u8.MasterPlaylist, prefixURL string, proxyToken string, client global.LinkClient, proxy bool, channelID string, fnTransform func(raw string, ts string) string) string {
 	baseUrl := global.GetBaseURL(playlistUrl)
 	handleUri := func(uri string) string {
 		if uri == "" {
@@ -80,7 +91,7 @@ func processMasterPlaylist(playlistUrl string, pl *m3u8.MasterPlaylist, prefixUR
 			uri = global.CleanUrl(global.MergeUrl(baseUrl, uri))
 		}
 		if proxy {
-			plLink := global.MergeUrl(prefixURL, fmt.Sprintf("playlist.m3u8?token=%s&k=%s&c=%d", proxyToken, util.CompressString(uri), channelNum))
+			plLink := global.MergeUrl(prefixURL, "playlist.m3u8?"+proxyQuery("playlist.m3u8", proxyToken, client, uri, channelID))
 			if fnTransform != nil {
 				plLink = fnTransform(uri, plLink)
 			}
@@ -100,14 +111,14 @@ func processMasterPlaylist(playlistUrl string, pl *m3u8.MasterPlaylist, prefixUR
 	return pl.Encode().String()
 }
 
-func M3U8Process(playlistUrl string, data string, prefixURL string, proxyToken string, proxy bool, channelNum int, fnTransform func(raw string, ts string) string) string {
+func M3U8Process(playlistUrl string, data string, prefixURL string, proxyToken string, client global.LinkClient, proxy bool, channelID string, fnTransform func(raw string, ts string) string) string {
 	p, listType, err := m3u8.DecodeFrom(bytes.NewBufferString(data), false)
 	if err == nil {
 		switch listType {
 		case m3u8.MASTER:
-			return processMasterPlaylist(playlistUrl, p.(*m3u8.MasterPlaylist), prefixURL, proxyToken, proxy, channelNum, fnTransform)
+			return processMasterPlaylist(playlistUrl, p.(*m3u8.MasterPlaylist), prefixURL, proxyToken, client, proxy, channelID, fnTransform)
 		case m3u8.MEDIA:
-			return processMediaPlaylist(playlistUrl, p.(*m3u8.MediaPlaylist), prefixURL, proxyToken, proxy, channelNum, fnTransform)
+			return processMediaPlaylist(playlistUrl, p.(*m3u8.MediaPlaylist), prefixURL, proxyToken, client, proxy, channelID, fnTransform)
 		}
 	}
 	return ""
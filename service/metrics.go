@@ -0,0 +1,65 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/snowie2000/livetv/metrics"
+)
+
+// cache names used in metrics
+const (
+	URLCacheName  = "url"
+	M3U8CacheName = "m3u8"
+)
+
+var (
+	parseDuration = metrics.NewHistogram("livetv_parse_duration_seconds",
+		"Time taken by plugins to parse a channel, by plugin and result.", metrics.DefBuckets, "plugin", "result")
+	cacheLookups = metrics.NewCounter("livetv_cache_lookups_total",
+		"Cache lookups by cache and result (hit or miss).", "cache", "result")
+)
+
+func observeParse(plugin string, duration time.Duration, err error) {
+	result := "ok"
+	if errors.Is(err, RetryOutdated) {
+		result = "outdated"
+	} else if err != nil {
+		result = errorCategory(Error, err.Error())
+	}
+	parseDuration.Observe(duration.Seconds(), plugin, result)
+}
+
+// CountCacheLookup records a hit or a miss of one of the caches
+func CountCacheLookup(cache string, hit bool) {
+	if hit {
+		cacheLookups.With(cache, "hit").Inc()
+	} else {
+		cacheLookups.With(cache, "miss").Inc()
+	}
+}
+
+func cacheHitRatios() []metrics.Sample {
+	var samples []metrics.Sample
+	for _, cache := range []string{M3U8CacheName, URLCacheName} {
+		hits := cacheLookups.With(cache, "hit").Get()
+		total := hits + cacheLookups.With(cache, "miss").Get()
+		if total > 0 {
+			samples = append(samples, metrics.Sample{Labels: []string{cache}, Value: hits / total})
+		}
+	}
+	return samples
+}
+
+func viewerSamples() []metrics.Sample {
+	var samples []metrics.Sample
+	for channel, count := range ViewerCounts() {
+		samples = append(samples, metrics.Sample{Labels: []string{channel}, Value: float64(count)})
+	}
+	return samples
+}
+
+func init() {
+	metrics.NewGaugeFunc("livetv_cache_hit_ratio", "Share of cache lookups that were hits since the start.", cacheHitRatios, "cache")
+	metrics.NewGaugeFunc("livetv_viewers", "Number of clients that watched a channel recently.", viewerSamples, "channel")
+}
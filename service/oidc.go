@@ -0,0 +1,485 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/util"
+	"golang.org/x/oauth2"
+)
+
+// single sign-on with any OpenID Connect provider, using the authorization code flow with PKCE
+
+const (
+	oidcFlowLifetime  = 10 * time.Minute
+	oidcMetadataTTL   = time.Hour
+	oidcKeysMinReload = time.Minute // unknown key ids reload the key set at most this often
+	oidcClockSkew     = time.Minute
+	oidcMaxResponse   = 1 << 20
+)
+
+var (
+	ErrOIDCDisabled   = errors.New("Single sign-on isn't configured")
+	ErrOIDCState      = errors.New("The login has expired or was started in another browser, please try again")
+	ErrOIDCNotAllowed = errors.New("Your account isn't allowed to manage LiveTV")
+	ErrOIDCNameTaken  = errors.New("A local user of the same name exists already")
+
+	oidcFlows    = cache.New(oidcFlowLifetime, time.Minute)
+	oidcLock     sync.Mutex
+	oidcProvider *oidcMetadata
+)
+
+// OIDCSettings is the single sign-on part of the config
+type OIDCSettings struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	Scopes        []string
+	GroupsClaim   string // claim with the groups of a user, a dotted path for nested claims, e.g. realm_access.roles
+	AllowedGroups []string
+	AllowedEmails []string
+	RoleMap       map[string]string // group to role
+	DefaultRole   string            // role of allowed users none of whose groups has a role, they are refused when empty
+}
+
+// OIDCLogin is a login started at the identity provider
+type OIDCLogin struct {
+	State string
+	URL   string
+}
+
+// what is kept of a login between its start and the return from the identity provider
+type oidcFlow struct {
+	nonce    string
+	verifier string
+}
+
+// the discovery document of a provider, along with its keys
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	fetched     time.Time
+	keys        []util.JWK
+	keysFetched time.Time
+}
+
+// ParseOIDCRoleMap reads group to role pairs like "tv-admins=owner,family=viewer"
+func ParseOIDCRoleMap(text string) (map[string]string, error) {
+	roles := make(map[string]string)
+	for _, pair := range splitList(text) {
+		group, role, ok := strings.Cut(pair, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !ok || group == "" {
+			return nil, fmt.Errorf("%q isn't a group=role pair", pair)
+		}
+		if roleRanks[role] == 0 {
+			return nil, fmt.Errorf("%q: %w", pair, ErrInvalidRole)
+		}
+		roles[group] = role
+	}
+	return roles, nil
+}
+
+// CheckOIDCRole tells whether a default role is valid, empty is
+func CheckOIDCRole(role string) error {
+	if role != "" && roleRanks[role] == 0 {
+		return ErrInvalidRole
+	}
+	return nil
+}
+
+func oidcConfig(key string) string {
+	value, _ := global.GetConfig(key)
+	return strings.TrimSpace(value)
+}
+
+// LoadOIDCSettings reads the single sign-on settings, ErrOIDCDisabled when it isn't configured
+func LoadOIDCSettings() (*OIDCSettings, error) {
+	settings := &OIDCSettings{
+		Issuer:        strings.TrimSuffix(oidcConfig("oidc_issuer"), "/"),
+		ClientID:      oidcConfig("oidc_client_id"),
+		ClientSecret:  oidcConfig("oidc_client_secret"),
+		Scopes:        strings.Fields(oidcConfig("oidc_scopes")),
+		GroupsClaim:   oidcConfig("oidc_groups_claim"),
+		AllowedGroups: splitList(oidcConfig("oidc_allowed_groups")),
+		AllowedEmails: splitList(strings.ToLower(oidcConfig("oidc_allowed_emails"))),
+		DefaultRole:   oidcConfig("oidc_default_role"),
+	}
+	if settings.Issuer == "" || settings.ClientID == "" {
+		return nil, ErrOIDCDisabled
+	}
+	if !containsString(settings.Scopes, "openid") {
+		settings.Scopes = append([]string{"openid"}, settings.Scopes...)
+	}
+	var err error
+	if settings.RoleMap, err = ParseOIDCRoleMap(oidcConfig("oidc_role_map")); err != nil {
+		return nil, err
+	}
+	if err = CheckOIDCRole(settings.DefaultRole); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// OIDCEnabled tells whether the login page should offer single sign-on
+func OIDCEnabled() bool {
+	_, err := LoadOIDCSettings()
+	return err == nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func oidcHTTPClient() *http.Client {
+	return &http.Client{Timeout: global.HttpClientTimeout}
+}
+
+func oidcGetJSON(ctx context.Context, url string, accessToken string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	resp, err := oidcHTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponse)).Decode(v)
+}
+
+// the discovery document of the issuer, fetched again every hour or when the issuer changes
+func discoverOIDC(ctx context.Context, issuer string) (*oidcMetadata, error) {
+	oidcLock.Lock()
+	defer oidcLock.Unlock()
+	if p := oidcProvider; p != nil && p.Issuer == issuer && time.Since(p.fetched) < oidcMetadataTTL {
+		return p, nil
+	}
+	var p oidcMetadata
+	if err := oidcGetJSON(ctx, issuer+"/.well-known/openid-configuration", "", &p); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovery: the provider calls itself %s instead of %s", p.Issuer, issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("discovery: the provider doesn't publish its endpoints")
+	}
+	p.Issuer = issuer
+	p.fetched = time.Now()
+	if old := oidcProvider; old != nil && old.Issuer == issuer && old.JWKSURI == p.JWKSURI {
+		p.keys, p.keysFetched = old.keys, old.keysFetched
+	}
+	oidcProvider = &p
+	return oidcProvider, nil
+}
+
+// the signing key of a token, the key set is loaded again when the key isn't known, as providers rotate their keys
+func (p *oidcMetadata) key(ctx context.Context, kid string, alg string) (crypto.PublicKey, error) {
+	oidcLock.Lock()
+	defer oidcLock.Unlock()
+	for attempt := 0; attempt < 2; attempt++ {
+		if attempt > 0 || p.keys == nil {
+			if time.Since(p.keysFetched) < oidcKeysMinReload && p.keys != nil {
+				break
+			}
+			var set util.JWKS
+			if err := oidcGetJSON(ctx, p.JWKSURI, "", &set); err != nil {
+				return nil, fmt.Errorf("keys: %w", err)
+			}
+			p.keys, p.keysFetched = set.Keys, time.Now()
+		}
+		for i := range p.keys {
+			k := &p.keys[i]
+			if (kid != "" && k.Kid != kid) || (k.Alg != "" && k.Alg != alg) || (k.Use != "" && k.Use != "sig") {
+				continue
+			}
+			if key, err := k.PublicKey(); err == nil {
+				return key, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no key %q of the provider signs %s tokens", kid, alg)
+}
+
+func (p *oidcMetadata) oauth2Config(settings *OIDCSettings, redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     settings.ClientID,
+		ClientSecret: settings.ClientSecret,
+		Endpoint:     oauth2.Endpoint{AuthURL: p.AuthorizationEndpoint, TokenURL: p.TokenEndpoint},
+		RedirectURL:  redirectURL,
+		Scopes:       settings.Scopes,
+	}
+}
+
+func randomHex(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// BeginOIDCLogin returns where to send the browser to log in at the identity provider.
+// redirectURL is the callback the provider sends it back to.
+func BeginOIDCLogin(ctx context.Context, redirectURL string) (*OIDCLogin, error) {
+	settings, err := LoadOIDCSettings()
+	if err != nil {
+		return nil, err
+	}
+	p, err := discoverOIDC(ctx, settings.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	state, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	flow := &oidcFlow{nonce: nonce, verifier: oauth2.GenerateVerifier()}
+	oidcFlows.SetDefault(state, flow)
+	url := p.oauth2Config(settings, redirectURL).AuthCodeURL(state,
+		oauth2.S256ChallengeOption(flow.verifier),
+		oauth2.SetAuthURLParam("nonce", nonce))
+	return &OIDCLogin{State: state, URL: url}, nil
+}
+
+// FinishOIDCLogin redeems the code the identity provider returned with and returns the user it logs in.
+// Users are created on their first login, their role follows their groups at every login.
+func FinishOIDCLogin(ctx context.Context, redirectURL string, state string, code string) (*model.User, error) {
+	value, ok := oidcFlows.Get(state)
+	if !ok || state == "" {
+		return nil, ErrOIDCState
+	}
+	oidcFlows.Delete(state) // a login can't be finished twice
+	flow := value.(*oidcFlow)
+	settings, err := LoadOIDCSettings()
+	if err != nil {
+		return nil, err
+	}
+	p, err := discoverOIDC(ctx, settings.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, oidcHTTPClient())
+	token, err := p.oauth2Config(settings, redirectURL).Exchange(ctx, code, oauth2.VerifierOption(flow.verifier))
+	if err != nil {
+		return nil, fmt.Errorf("token: %w", err)
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, errors.New("token: the provider didn't return an id token")
+	}
+	claims, err := p.verifyIDToken(ctx, settings, rawIDToken, flow.nonce)
+	if err != nil {
+		return nil, err
+	}
+	// some providers only tell the email and groups at the userinfo endpoint
+	if p.UserinfoEndpoint != "" && (claims["email"] == nil || claimValue(claims, settings.groupsClaim()) == nil) {
+		var info map[string]any
+		if err := oidcGetJSON(ctx, p.UserinfoEndpoint, token.AccessToken, &info); err != nil {
+			log.Println("oidc userinfo:", err)
+		} else if info["sub"] == claims["sub"] {
+			for key, value := range info {
+				if _, ok := claims[key]; !ok {
+					claims[key] = value
+				}
+			}
+		}
+	}
+	return oidcUser(settings, claims)
+}
+
+// the checks of OpenID Connect Core 3.1.3.7
+func (p *oidcMetadata) verifyIDToken(ctx context.Context, settings *OIDCSettings, raw string, nonce string) (map[string]any, error) {
+	header, claims, signed, signature, err := util.ParseJWT(raw)
+	if err != nil {
+		return nil, err
+	}
+	key, err := p.key(ctx, header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err = util.VerifyJWTSignature(header.Alg, key, signed, signature); err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != settings.Issuer {
+		return nil, errors.New("id token: wrong issuer")
+	}
+	var audiences []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+	if !containsString(audiences, settings.ClientID) {
+		return nil, errors.New("id token: issued to another client")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != settings.ClientID {
+		return nil, errors.New("id token: issued to another client")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || time.Unix(int64(exp), 0).Add(oidcClockSkew).Before(time.Now()) {
+		return nil, errors.New("id token: expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(time.Now().Add(oidcClockSkew)) {
+		return nil, errors.New("id token: issued in the future")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("id token: wrong nonce")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("id token: no subject")
+	}
+	return claims, nil
+}
+
+func (s *OIDCSettings) groupsClaim() string {
+	if s.GroupsClaim == "" {
+		return "groups"
+	}
+	return s.GroupsClaim
+}
+
+// a claim by its dotted path
+func claimValue(claims map[string]any, path string) any {
+	var value any = claims
+	for _, name := range strings.Split(path, ".") {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = m[name]
+	}
+	return value
+}
+
+func claimStrings(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return splitList(v)
+	case []any:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// the role of a user of the identity provider, empty when they aren't allowed in
+func oidcRole(settings *OIDCSettings, email string, groups []string) string {
+	if len(settings.AllowedGroups) > 0 || len(settings.AllowedEmails) > 0 {
+		allowed := email != "" && containsString(settings.AllowedEmails, email)
+		for _, group := range groups {
+			allowed = allowed || containsString(settings.AllowedGroups, group)
+		}
+		if !allowed {
+			return ""
+		}
+	}
+	role := ""
+	for _, group := range groups {
+		if r := settings.RoleMap[group]; roleRanks[r] > roleRanks[role] {
+			role = r
+		}
+	}
+	if role == "" {
+		role = settings.DefaultRole
+	}
+	return role
+}
+
+// find or create the user of verified claims
+func oidcUser(settings *OIDCSettings, claims map[string]any) (*model.User, error) {
+	sub, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	email = strings.ToLower(email)
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		email = "" // anybody could have claimed it, providers that don't say it is verified are no exception
+	}
+	groups := claimStrings(claimValue(claims, settings.groupsClaim()))
+	role := oidcRole(settings, email, groups)
+	if role == "" {
+		return nil, ErrOIDCNotAllowed
+	}
+	subject := settings.Issuer + "#" + sub
+	var user model.User
+	err := global.DB.Where("oidc_subject = ?", subject).First(&user).Error
+	if err == nil {
+		if user.Disabled {
+			return nil, ErrOIDCNotAllowed
+		}
+		if user.Role != role {
+			previous := user.Role
+			user.Role = role
+			if err := SaveUser(&user, ""); err != nil {
+				// e.g. the last owner, who keeps the role to manage settings and users
+				log.Printf("oidc: role of %s stays %s: %s\n", user.Name, previous, err)
+				user.Role = previous
+			}
+		}
+		return &user, nil
+	}
+	name := email
+	if name == "" {
+		name, _ = claims["preferred_username"].(string)
+	}
+	if name == "" {
+		name = sub
+	}
+	if _, err := getUserByName(name); err == nil {
+		return nil, ErrOIDCNameTaken
+	}
+	user = model.User{
+		Name:        name,
+		Role:        role,
+		OIDCSubject: subject,
+		Created:     time.Now(),
+	}
+	if err := global.DB.Create(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/syncx"
+)
+
+var (
+	overrideCache       syncx.Map[int, map[string]model.ChannelOverride] // parent id => identity => override
+	errNotSubChannel    = errors.New("Not a sub channel")
+	errNoStableIdentity = errors.New("Sub channel has no stable identity")
+)
+
+func loadOverrides(parentID int) map[string]model.ChannelOverride {
+	if overrides, ok := overrideCache.Load(parentID); ok {
+		return overrides
+	}
+	var rows []model.ChannelOverride
+	overrides := make(map[string]model.ChannelOverride)
+	if global.DB.Where("parent_id = ?", parentID).Find(&rows).Error == nil {
+		for _, row := range rows {
+			overrides[row.Identity] = row
+		}
+		overrideCache.Store(parentID, overrides)
+	}
+	return overrides
+}
+
+// apply the persisted overrides to the sub channels of a provider, hidden sub channels are dropped
+func applyChannelOverrides(parent *model.Channel, children []*model.Channel) []*model.Channel {
+	overrides := loadOverrides(parent.ID)
+	if len(overrides) == 0 {
+		return children
+	}
+	result := make([]*model.Channel, 0, len(children))
+	for _, child := range children {
+		o, ok := overrides[child.Identity]
+		if !ok {
+			result = append(result, child)
+			continue
+		}
+		if o.Hidden {
+			continue
+		}
+		// providers may share their channel structs, never modify them in place
+		ch := *child
+		if o.Name != "" {
+			ch.Name = o.Name
+		}
+		if o.Category != "" {
+			ch.Category = o.Category
+		}
+		if o.Logo != "" {
+			ch.Logo = o.Logo
+		}
+		result = append(result, &ch)
+	}
+	return result
+}
+
+// SubChannelHidden tells whether an override hides a sub channel of a parent channel
+func SubChannelHidden(parentID int, identity string) bool {
+	return identity != "" && loadOverrides(parentID)[identity].Hidden
+}
+
+// drop cached info of a parent channel so that its sub channels are rebuilt with the latest overrides
+func invalidateParentChannel(parentID int) {
+	if parent, ok := global.ChannelCache.Load(strconv.Itoa(parentID)); ok {
+		InvalidateChannelCache(&parent)
+	}
+}
+
+func GetChannelOverrides(parentID int) (overrides []model.ChannelOverride, err error) {
+	err = global.DB.Where("parent_id = ?", parentID).Find(&overrides).Error
+	return
+}
+
+// SaveChannelOverride creates or updates the override of a sub channel
+func SaveChannelOverride(sub *model.Channel, name string, category string, logo string, hidden bool) error {
+	if sub.ParentID == sub.ChannelID {
+		return errNotSubChannel
+	}
+	if sub.Identity == "" {
+		return errNoStableIdentity
+	}
+	parentID, _ := strconv.Atoi(sub.ParentID)
+	var o model.ChannelOverride
+	err := global.DB.Where("parent_id = ? AND identity = ?", parentID, sub.Identity).First(&o).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+	o.ParentID = parentID
+	o.Identity = sub.Identity
+	o.Name = name
+	o.Category = category
+	o.Logo = logo
+	o.Hidden = hidden
+	if err = global.DB.Save(&o).Error; err != nil {
+		return err
+	}
+	overrideCache.Delete(parentID)
+	invalidateParentChannel(parentID)
+	global.NotifyChange(global.ScopeChannels)
+	return nil
+}
+
+// HideSubChannel hides a sub channel while keeping its other overrides
+func HideSubChannel(sub *model.Channel) error {
+	parentID, _ := strconv.Atoi(sub.ParentID)
+	o := loadOverrides(parentID)[sub.Identity]
+	return SaveChannelOverride(sub, o.Name, o.Category, o.Logo, true)
+}
+
+// DeleteChannelOverride restores a sub channel to what its provider says
+func DeleteChannelOverride(parentID int, identity string) error {
+	err := global.DB.Delete(model.ChannelOverride{}, "parent_id = ? AND identity = ?", parentID, identity).Error
+	if err != nil {
+		return err
+	}
+	overrideCache.Delete(parentID)
+	invalidateParentChannel(parentID)
+	global.NotifyChange(global.ScopeChannels)
+	return nil
+}
+
+// DeleteChannelOverrides removes all overrides of a parent channel
+func DeleteChannelOverrides(parentID int) error {
+	overrideCache.Delete(parentID)
+	return global.DB.Delete(model.ChannelOverride{}, "parent_id = ?", parentID).Error
+}
+
+// PromoteSubChannel turns a sub channel into a standalone channel and hides the original entry
+func PromoteSubChannel(sub *model.Channel) (*model.Channel, error) {
+	if sub.ParentID == sub.ChannelID {
+		return nil, errNotSubChannel
+	}
+	ch := &model.Channel{
+		Name:     sub.Name,
+		Logo:     sub.Logo,
+		URL:      sub.URL,
+		Parser:   sub.Parser,
+		Proxy:    sub.Proxy,
+		TsProxy:  sub.TsProxy,
+		ProxyUrl: sub.ProxyUrl,
+		Category: sub.Category,
+		Extra:    sub.Extra,
+	}
+	ch.HasSubChannel = ProvidesSubChannels(ch.Parser)
+	if err := SaveChannel(ch); err != nil {
+		return nil, err
+	}
+	if sub.Identity != "" {
+		if err := HideSubChannel(sub); err != nil {
+			return ch, err
+		}
+	}
+	return ch, nil
+}
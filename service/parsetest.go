@@ -0,0 +1,299 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/grafov/m3u8"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+)
+
+const (
+	parseTestMaxHops     = 10
+	parseTestSampleLines = 30
+	parseTestMaxVariants = 20
+	parseTestMaxChildren = 20
+)
+
+var errTooManyHops = errors.New("Too many redirections")
+
+type ParseHop struct {
+	URL         string `json:"url"`
+	Status      int    `json:"status"`
+	ContentType string `json:"contenttype"`
+	Location    string `json:"location,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Duration    int64  `json:"ms"`
+}
+
+type ParseStep struct {
+	Name     string `json:"name"`
+	Duration int64  `json:"ms"`
+	Error    string `json:"error,omitempty"`
+}
+
+type PlaylistVariant struct {
+	URI        string `json:"uri"`
+	Bandwidth  uint32 `json:"bandwidth"`
+	Resolution string `json:"resolution,omitempty"`
+	Codecs     string `json:"codecs,omitempty"`
+}
+
+// PlaylistSample is the beginning of the first playlist of a parsed channel
+type PlaylistSample struct {
+	URL            string            `json:"url"`
+	Status         int               `json:"status"`
+	ContentType    string            `json:"contenttype"`
+	Type           string            `json:"type"` // master, media or unknown
+	Sample         string            `json:"sample"`
+	Variants       []PlaylistVariant `json:"variants,omitempty"`
+	Segments       int               `json:"segments,omitempty"`
+	TargetDuration float64           `json:"targetduration,omitempty"`
+}
+
+// ParseTestResult tells what parsing a channel would do
+type ParseTestResult struct {
+	URL         string          `json:"url"`
+	Parser      string          `json:"parser"`
+	Detected    string          `json:"detected"`
+	Hops        []ParseHop      `json:"hops"`
+	LiveInfo    *model.LiveInfo `json:"liveinfo"`
+	Source      *PlaylistSample `json:"source,omitempty"` // playlist at the end of the redirections, when it isn't the live url
+	Playlist    *PlaylistSample `json:"playlist,omitempty"`
+	SubChannels int             `json:"subchannels,omitempty"`
+	Children    []string        `json:"children,omitempty"` // names of the first sub channels
+	Steps       []ParseStep     `json:"steps"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// run a step and record its duration and error
+func (r *ParseTestResult) step(name string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	s := ParseStep{Name: name, Duration: time.Since(start).Milliseconds()}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	r.Steps = append(r.Steps, s)
+	return err
+}
+
+// ParseTest runs a channel through detection and parsing like RealLiveM3U8, without caching or saving anything.
+// The channel must not have an id so that providers don't persist sub channel numbers.
+func ParseTest(channel *model.Channel) *ParseTestResult {
+	ch := *channel
+	ch.ID = 0
+	result := &ParseTestResult{
+		URL:    ch.URL,
+		Parser: ch.Parser,
+		Hops:   []ParseHop{},
+		Steps:  []ParseStep{},
+	}
+	fail := func(err error) *ParseTestResult {
+		result.Error = err.Error()
+		return result
+	}
+
+	if u, err := url.Parse(ch.URL); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		result.step("probe", func() error {
+			return result.traceHops(&ch)
+		})
+	}
+
+	var p Plugin
+	err := result.step("detect", func() (err error) {
+		p, err = channelPlugin(&ch)
+		return
+	})
+	if err != nil {
+		return fail(err)
+	}
+	result.Detected = ch.Parser
+
+	var info *model.LiveInfo
+	err = result.step("parse", func() (err error) {
+		info, err = p.Parse(&ch, &model.LiveInfo{})
+		return
+	})
+	if err != nil {
+		return fail(err)
+	}
+	result.LiveInfo = info
+
+	if provider, ok := p.(ChannalProvider); ok {
+		children := provider.Channels(&ch, info)
+		result.SubChannels = len(children)
+		for i, child := range children {
+			if i >= parseTestMaxChildren {
+				break
+			}
+			result.Children = append(result.Children, child.Name)
+		}
+		return result
+	}
+
+	result.step("playlist", func() (err error) {
+		result.Playlist, err = samplePlaylist(p, info)
+		return
+	})
+	// parsers usually pick a variant, show what they picked from
+	if n := len(result.Hops); n > 0 {
+		last := result.Hops[n-1]
+		if last.Status == http.StatusOK && last.URL != info.LiveUrl && strings.Contains(strings.ToLower(last.ContentType), "mpegurl") {
+			result.step("source", func() (err error) {
+				result.Source, err = fetchPlaylistSample(last.URL, &model.LiveInfo{LiveUrl: last.URL}, nil)
+				return
+			})
+		}
+	}
+	return result
+}
+
+// follow the redirections of the channel url one by one
+func (r *ParseTestResult) traceHops(ch *model.Channel) error {
+	client := http.Client{
+		Timeout:   global.HttpClientTimeout,
+		Transport: global.UpstreamTransport(ch.ProxyUrl),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	var ui UrlInfo
+	json.Unmarshal([]byte(ch.Extra), &ui)
+	next := ch.URL
+	for i := 0; i < parseTestMaxHops; i++ {
+		hop := ParseHop{URL: next}
+		start := time.Now()
+		req, err := http.NewRequest(http.MethodGet, next, nil)
+		if err != nil {
+			hop.Error = err.Error()
+			r.Hops = append(r.Hops, hop)
+			return err
+		}
+		req.Header.Set("User-Agent", DefaultUserAgent)
+		for k, v := range ui.Headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		hop.Duration = time.Since(start).Milliseconds()
+		if err != nil {
+			hop.Error = err.Error()
+			r.Hops = append(r.Hops, hop)
+			return err
+		}
+		global.CloseBody(resp)
+		hop.Status = resp.StatusCode
+		hop.ContentType = resp.Header.Get("Content-Type")
+		hop.Location = resp.Header.Get("Location")
+		r.Hops = append(r.Hops, hop)
+		if hop.Location == "" {
+			return nil
+		}
+		if !global.IsValidURL(hop.Location) {
+			next = global.MergeUrl(global.GetBaseURL(next), hop.Location)
+		} else {
+			next = hop.Location
+		}
+	}
+	return errTooManyHops
+}
+
+// fetch the first playlist of a parsed channel the way GetM3U8Content does and describe it
+func samplePlaylist(p Plugin, info *model.LiveInfo) (*PlaylistSample, error) {
+	if forger, ok := p.(Forger); ok {
+		baseUrl, forged, err := forger.ForgeM3U8(info)
+		if err != nil {
+			return &PlaylistSample{URL: baseUrl, Type: "unknown"}, err
+		}
+		sample := &PlaylistSample{URL: baseUrl, Status: http.StatusOK}
+		return sample, sample.describe(forged)
+	}
+	transformer, _ := p.(Transformer)
+	return fetchPlaylistSample(info.LiveUrl, info, transformer)
+}
+
+func fetchPlaylistSample(playlistUrl string, info *model.LiveInfo, transformer Transformer) (*PlaylistSample, error) {
+	sample := &PlaylistSample{URL: playlistUrl, Type: "unknown"}
+	u, err := url.Parse(playlistUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		// rtmp and friends are handed to the player as they are
+		return sample, nil
+	}
+	client := http.Client{
+		Timeout:   global.HttpClientTimeout,
+		Transport: global.UpstreamTransport(""),
+	}
+	req, err := http.NewRequest(http.MethodGet, playlistUrl, nil)
+	if err != nil {
+		return sample, err
+	}
+	req.Header.Set("User-Agent", DefaultUserAgent)
+	if transformer != nil {
+		transformer.Transform(req, info)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return sample, err
+	}
+	defer global.CloseBody(resp)
+	sample.Status = resp.StatusCode
+	sample.ContentType = resp.Header.Get("Content-Type")
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return sample, err
+	}
+	if err = sample.describe(string(data)); err != nil {
+		return sample, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return sample, fmt.Errorf("Server response: HTTP %d", resp.StatusCode)
+	}
+	return sample, nil
+}
+
+// fill in the sample lines and the playlist details
+func (sample *PlaylistSample) describe(body string) error {
+	sample.Type = "unknown"
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for len(lines) < parseTestSampleLines && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	sample.Sample = strings.Join(lines, "\n")
+	if !isValidM3U(body) {
+		return nil
+	}
+	pl, listType, err := m3u8.DecodeFrom(strings.NewReader(body), false)
+	if err != nil {
+		return err
+	}
+	switch listType {
+	case m3u8.MASTER:
+		sample.Type = "master"
+		for _, v := range pl.(*m3u8.MasterPlaylist).Variants {
+			if len(sample.Variants) >= parseTestMaxVariants {
+				break
+			}
+			sample.Variants = append(sample.Variants, PlaylistVariant{
+				URI:        v.URI,
+				Bandwidth:  v.Bandwidth,
+				Resolution: v.Resolution,
+				Codecs:     v.Codecs,
+			})
+		}
+	case m3u8.MEDIA:
+		media := pl.(*m3u8.MediaPlaylist)
+		sample.Type = "media"
+		sample.Segments = int(media.Count())
+		sample.TargetDuration = media.TargetDuration
+	}
+	return nil
+}
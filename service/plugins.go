@@ -65,6 +65,11 @@ type ChannelParser interface {
 	ParseChannelUrl(chUrl string, mainChannelInfo *model.Channel) *model.Channel
 }
 
+// preview which playlist entries a provider keeps with a set of filter rules
+type FilterPreviewer interface {
+	PreviewFilter(channel *model.Channel, rules []model.FilterRule) (kept []*model.Channel, dropped []*model.Channel, err error)
+}
+
 type UrlInfo struct {
 	Headers         map[string]string `json:"headers"`
 	Logo            string            `json:"logo"`
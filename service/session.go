@@ -0,0 +1,252 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/model"
+)
+
+// a client that hasn't asked for a playlist or a segment for this long stopped watching
+const viewerTimeout = time.Minute
+
+var ErrSessionNotFound = errors.New("Session not found")
+
+// Session is a client playing a channel, every request of a client for a channel belongs to the same session
+type Session struct {
+	ID         string    `json:"id"`
+	Channel    string    `json:"channel"` // channel id as in live.m3u8?c=
+	Name       string    `json:"name"`
+	Plugin     string    `json:"plugin"`
+	ClientIP   string    `json:"ip"`
+	UserAgent  string    `json:"useragent"`
+	Token      string    `json:"token"`
+	User       string    `json:"user,omitempty"`
+	Subscriber int       `json:"subscriber,omitempty"`
+	Started    time.Time `json:"started"`
+	LastActive time.Time `json:"lastactive"`
+	BytesSent  int64     `json:"bytes"`
+	Streams    int       `json:"streams"` // requests being served right now
+	Killed     bool      `json:"killed,omitempty"`
+}
+
+// SessionReport lists the sessions and counts them by channel and by plugin
+type SessionReport struct {
+	Sessions  []Session      `json:"sessions"`
+	ByChannel map[string]int `json:"bychannel"`
+	ByPlugin  map[string]int `json:"byplugin"`
+}
+
+// playlists and segments of a channel are asked for with different tokens, so the token isn't part of the key
+type sessionKey struct {
+	channel   string
+	client    string
+	userAgent string
+}
+
+var (
+	sessionsLock sync.Mutex
+	sessions     = make(map[sessionKey]*Session)
+	sessionPrune time.Time
+	ErrKilled    = errors.New("Session has been killed")
+)
+
+// counts the bytes sent to a session and stops writing once it is killed
+type sessionWriter struct {
+	gin.ResponseWriter
+	session *Session
+}
+
+func (w *sessionWriter) Write(data []byte) (int, error) {
+	if w.session.killed() {
+		return 0, ErrKilled
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.session.addBytes(n)
+	return n, err
+}
+
+func (w *sessionWriter) WriteString(s string) (int, error) {
+	if w.session.killed() {
+		return 0, ErrKilled
+	}
+	n, err := w.ResponseWriter.WriteString(s)
+	w.session.addBytes(n)
+	return n, err
+}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Session) killed() bool {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+	return s.Killed
+}
+
+func (s *Session) addBytes(n int) {
+	sessionsLock.Lock()
+	s.BytesSent += int64(n)
+	s.LastActive = time.Now()
+	sessionsLock.Unlock()
+}
+
+// devices of a subscriber playing something, must be called with sessionsLock held
+func subscriberDevices(id int, except sessionKey) int {
+	devices := make(map[[2]string]bool)
+	for key, s := range sessions {
+		if s.Subscriber == id && !s.Killed && (key.client != except.client || key.userAgent != except.userAgent) {
+			devices[[2]string{key.client, key.userAgent}] = true
+		}
+	}
+	return len(devices)
+}
+
+// SubscriberDevices returns the number of devices a subscriber is playing on
+func SubscriberDevices(id int) int {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+	pruneSessions()
+	return subscriberDevices(id, sessionKey{})
+}
+
+// OpenSession attaches a playback request to the session of its client and counts what is sent to it.
+// sub is the subscriber the request was let in for, if any; a new device is refused once the subscriber plays on as many as allowed.
+// It fails with ErrKilled when the session has been killed, and ErrTooManyStreams. The returned function must be called once the request is served.
+func OpenSession(c *gin.Context, channel string, sub *model.Subscriber) (func(), error) {
+	if channel == "" {
+		return func() {}, nil
+	}
+	key := sessionKey{
+		channel:   channel,
+		client:    c.ClientIP(),
+		userAgent: c.Request.UserAgent(),
+	}
+	now := time.Now()
+	sessionsLock.Lock()
+	// forget clients that left even when nobody asks for the sessions
+	if now.Sub(sessionPrune) > viewerTimeout {
+		pruneSessions()
+		sessionPrune = now
+	}
+	s, ok := sessions[key]
+	if ok && s.Killed {
+		sessionsLock.Unlock()
+		return func() {}, ErrKilled
+	}
+	if sub != nil && sub.MaxStreams > 0 && (!ok || s.Subscriber != sub.ID) && subscriberDevices(sub.ID, key) >= sub.MaxStreams {
+		sessionsLock.Unlock()
+		return func() {}, ErrTooManyStreams
+	}
+	if !ok {
+		s = &Session{
+			ID:        newSessionID(),
+			Channel:   channel,
+			ClientIP:  key.client,
+			UserAgent: key.userAgent,
+			Token:     c.Query("token"),
+			Started:   now,
+		}
+		sessions[key] = s
+	}
+	if sub != nil {
+		s.User = sub.Name
+		s.Subscriber = sub.ID
+		s.Token = sub.Token
+	}
+	s.LastActive = now
+	s.Streams++
+	sessionsLock.Unlock()
+
+	if !ok {
+		number, sub := channelNumbers(channel)
+		if ch, err := GetChannel(number, sub); err == nil {
+			sessionsLock.Lock()
+			s.Name = ch.Name
+			s.Plugin = ch.Parser
+			sessionsLock.Unlock()
+		}
+	}
+	c.Writer = &sessionWriter{c.Writer, s}
+	return func() {
+		sessionsLock.Lock()
+		s.Streams--
+		s.LastActive = time.Now()
+		sessionsLock.Unlock()
+	}, nil
+}
+
+// split a channel id like 3 or 3-5 into its numbers, -1 when it isn't a sub channel
+func channelNumbers(id string) (int, int) {
+	first, sub, found := strings.Cut(id, "-")
+	number, _ := strconv.Atoi(first)
+	if !found {
+		return number, -1
+	}
+	subNumber, _ := strconv.Atoi(sub)
+	return number, subNumber
+}
+
+// must be called with sessionsLock held
+func pruneSessions() {
+	for key, s := range sessions {
+		if s.Streams <= 0 && time.Since(s.LastActive) > viewerTimeout {
+			delete(sessions, key)
+		}
+	}
+}
+
+// GetSessions returns the sessions that are still playing
+func GetSessions() *SessionReport {
+	report := &SessionReport{
+		Sessions:  []Session{},
+		ByChannel: make(map[string]int),
+		ByPlugin:  make(map[string]int),
+	}
+	sessionsLock.Lock()
+	pruneSessions()
+	for _, s := range sessions {
+		if s.Killed {
+			continue
+		}
+		report.Sessions = append(report.Sessions, *s)
+		report.ByChannel[s.Channel]++
+		if s.Plugin != "" {
+			report.ByPlugin[s.Plugin]++
+		}
+	}
+	sessionsLock.Unlock()
+	sort.Slice(report.Sessions, func(i, j int) bool {
+		return report.Sessions[i].Started.Before(report.Sessions[j].Started)
+	})
+	return report
+}
+
+// KillSession stops sending data to a session, its client is refused until it has been quiet for a while
+func KillSession(id string) error {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+	for _, s := range sessions {
+		if s.ID == id && !s.Killed {
+			s.Killed = true
+			s.LastActive = time.Now()
+			return nil
+		}
+	}
+	return ErrSessionNotFound
+}
+
+// ViewerCounts returns the number of clients watching each channel
+func ViewerCounts() map[string]int {
+	return GetSessions().ByChannel
+}
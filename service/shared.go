@@ -0,0 +1,67 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+)
+
+var (
+	parsedLock    sync.Mutex
+	parsedDigests = make(map[int]string) // channel id => digest of what its cached live info was parsed from
+)
+
+// digest of everything that affects the result of parsing a channel
+func parseDigest(ch *model.Channel) string {
+	rules, _ := GetFilterRules(ch.ID)
+	return fmt.Sprintf("%s|%s|%t|%s|%s|%s|%v", ch.URL, ch.Parser, ch.Proxy, ch.TsProxy, ch.ProxyUrl, ch.Extra, rules)
+}
+
+// remember what a primary channel has been parsed from
+func rememberParsed(ch *model.Channel) {
+	if ch.ID <= 0 || ch.ChannelID != ch.ParentID {
+		return
+	}
+	digest := parseDigest(ch)
+	parsedLock.Lock()
+	parsedDigests[ch.ID] = digest
+	parsedLock.Unlock()
+}
+
+// reload the channels after another instance sharing the database has changed them,
+// channels whose parse settings have changed are parsed again
+func reloadSharedChannels() {
+	overrideCache.Clear()
+	filterCache.Clear()
+	subChannelLock.Lock()
+	subChannelNumbers = make(map[int]map[string]int)
+	subChannelLock.Unlock()
+	InvalidateChannelCache()
+
+	var channels []*model.Channel
+	if err := global.DB.Find(&channels).Error; err != nil {
+		log.Println(err)
+		return
+	}
+	var stale []*model.Channel
+	parsedLock.Lock()
+	for _, ch := range channels {
+		digest := parseDigest(ch)
+		_, cached := global.URLCache.Load(ch.URL)
+		if !cached || parsedDigests[ch.ID] != digest {
+			stale = append(stale, ch)
+		}
+	}
+	parsedLock.Unlock()
+	for _, ch := range stale {
+		loadChannel(ch, false)
+		UpdateURLCacheSingle(ch, true)
+	}
+}
+
+func init() {
+	global.OnChange(global.ScopeChannels, reloadSharedChannels)
+}
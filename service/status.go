@@ -3,6 +3,7 @@ package service
 import (
 	"time"
 
+	"github.com/snowie2000/livetv/model"
 	"github.com/snowie2000/livetv/syncx"
 )
 
@@ -12,6 +13,9 @@ type StatusInfo struct {
 	RetryCount         int
 	Status             int
 	Msg                string
+	Category           string
+	ResolvedURL        string
+	Plugin             string
 }
 
 const (
@@ -27,10 +31,36 @@ const MaxRetryCount = 5
 var statusCache syncx.Map[any, *StatusInfo]
 
 func UpdateStatus(url any, status int, msg string) {
+	updateStatus(url, nil, nil, status, msg)
+}
+
+// UpdateChannelStatus updates the status of a channel url and remembers what it has been resolved to
+func UpdateChannelStatus(channel *model.Channel, liveInfo *model.LiveInfo, status int, msg string) {
+	updateStatus(channel.URL, channel, liveInfo, status, msg)
+}
+
+func updateStatus(url any, channel *model.Channel, liveInfo *model.LiveInfo, status int, msg string) {
+	resolved, plugin := "", ""
+	if liveInfo != nil {
+		resolved = liveInfo.LiveUrl
+	}
+	if channel != nil {
+		plugin = channel.Parser
+	}
+	previous := Unknown
+	category := errorCategory(status, msg)
 	if c, ok := statusCache.Load(url); ok {
+		previous = c.Status
 		c.Msg = msg
 		c.Status = status
+		c.Category = category
 		c.Time = time.Now()
+		if resolved != "" {
+			c.ResolvedURL = resolved
+		}
+		if plugin != "" {
+			c.Plugin = plugin
+		}
 		if status == Ok {
 			c.RetryCount = 0
 			c.CoolDownMultiplier = 1
@@ -41,11 +71,18 @@ func UpdateStatus(url any, status int, msg string) {
 		statusCache.Store(url, &StatusInfo{
 			Msg:                msg,
 			Status:             status,
+			Category:           category,
+			ResolvedURL:        resolved,
+			Plugin:             plugin,
 			RetryCount:         0,
 			CoolDownMultiplier: 1,
 			Time:               time.Now(),
 		})
 	}
+	if s, ok := url.(string); ok && status != previous {
+		recordStatusEvent(s, status, category, msg, resolved, plugin)
+		publishStatusChange(channel, s, previous, status, msg)
+	}
 }
 
 func GetStatus(url any) *StatusInfo {
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"log"
+	"sync"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+)
+
+// times numbering a playlist is tried before giving up
+const subChannelAttempts = 3
+
+var (
+	subChannelLock    sync.Mutex
+	subChannelNumbers = make(map[int]map[string]int) // parent id => identity => number
+)
+
+// load the persisted number mapping of a parent channel, caller must hold subChannelLock
+func loadSubChannelNumbers(parentID int) (map[string]int, error) {
+	if numbers, ok := subChannelNumbers[parentID]; ok {
+		return numbers, nil
+	}
+	var rows []model.SubChannel
+	if err := global.DB.Where("parent_id = ?", parentID).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	numbers := make(map[string]int, len(rows))
+	for _, row := range rows {
+		numbers[row.Identity] = row.Number
+	}
+	subChannelNumbers[parentID] = numbers
+	return numbers, nil
+}
+
+// AssignSubChannelNumbers returns a stable number for every identity of a provider playlist.
+// Known identities keep their numbers, new ones get fresh numbers and numbers of removed entries are never reused.
+// Instances sharing the database may number the same playlist at once, the loser of the race loads the
+// numbers of the winner and tries again.
+func AssignSubChannelNumbers(parentID int, identities []string) ([]int, error) {
+	result := make([]int, len(identities))
+	if parentID <= 0 {
+		// unsaved channels have nothing to persist, fall back to entry index
+		for i := range identities {
+			result[i] = i
+		}
+		return result, nil
+	}
+
+	subChannelLock.Lock()
+	defer subChannelLock.Unlock()
+	var err error
+	for attempt := 0; attempt < subChannelAttempts; attempt++ {
+		if err = assignSubChannelNumbers(parentID, identities, result); err == nil {
+			return result, nil
+		}
+		// start over from the database
+		delete(subChannelNumbers, parentID)
+	}
+	log.Println("failed to save sub channel numbers:", err)
+	return nil, err
+}
+
+// number the identities into result, caller must hold subChannelLock
+func assignSubChannelNumbers(parentID int, identities []string, result []int) error {
+	numbers, err := loadSubChannelNumbers(parentID)
+	if err != nil {
+		return err
+	}
+	for _, identity := range identities {
+		if _, ok := numbers[identity]; !ok {
+			// another instance sharing the database may have numbered it already
+			delete(subChannelNumbers, parentID)
+			if numbers, err = loadSubChannelNumbers(parentID); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	next := 0
+	for _, n := range numbers {
+		if n >= next {
+			next = n + 1
+		}
+	}
+	var created []model.SubChannel
+	assigned := make(map[string]int)
+	for i, identity := range identities {
+		n, ok := numbers[identity]
+		if !ok {
+			if n, ok = assigned[identity]; !ok {
+				n = next
+				next++
+				assigned[identity] = n
+				created = append(created, model.SubChannel{ParentID: parentID, Identity: identity, Number: n})
+			}
+		}
+		result[i] = n
+	}
+	if len(created) == 0 {
+		return nil
+	}
+	// the unique indexes on identity and on number make a concurrent save of either fail
+	tx := global.DB.Begin()
+	for i := range created {
+		if err = tx.Create(&created[i]).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err = tx.Commit().Error; err != nil {
+		return err
+	}
+	for identity, n := range assigned {
+		numbers[identity] = n
+	}
+	return nil
+}
+
+// DeleteSubChannelNumbers forgets all reserved sub channel numbers of a parent channel
+func DeleteSubChannelNumbers(parentID int) error {
+	subChannelLock.Lock()
+	defer subChannelLock.Unlock()
+	delete(subChannelNumbers, parentID)
+	return global.DB.Delete(model.SubChannel{}, "parent_id = ?", parentID).Error
+}
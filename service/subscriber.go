@@ -0,0 +1,127 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/syncx"
+)
+
+var (
+	ErrSubscriberNotFound = errors.New("Subscriber not found")
+	ErrTooManyStreams     = errors.New("Too many streams")
+	subscriberCache       syncx.Map[string, model.Subscriber] // token => subscriber
+)
+
+func newSubscriberToken() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func GetSubscribers() (subs []model.Subscriber, err error) {
+	subs = []model.Subscriber{}
+	err = global.DB.Order("id").Find(&subs).Error
+	return
+}
+
+func GetSubscriber(id int) (*model.Subscriber, error) {
+	var sub model.Subscriber
+	if err := global.DB.First(&sub, id).Error; err != nil {
+		return nil, ErrSubscriberNotFound
+	}
+	return &sub, nil
+}
+
+// SaveSubscriber creates or updates a subscriber, new subscribers get a token
+func SaveSubscriber(sub *model.Subscriber, resetToken bool) error {
+	sub.Name = strings.TrimSpace(sub.Name)
+	if sub.Name == "" {
+		return errors.New("Subscriber needs a name")
+	}
+	if sub.MaxStreams < 0 {
+		sub.MaxStreams = 0
+	}
+	if sub.Token == "" || resetToken {
+		token, err := newSubscriberToken()
+		if err != nil {
+			return err
+		}
+		sub.Token = token
+	}
+	if sub.ID == 0 {
+		sub.Created = time.Now()
+	}
+	if err := global.DB.Save(sub).Error; err != nil {
+		return err
+	}
+	invalidateSubscribers()
+	global.NotifyChange(global.ScopeSubscribers)
+	return nil
+}
+
+func DeleteSubscriber(id int) error {
+	res := global.DB.Delete(model.Subscriber{}, "id = ?", id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrSubscriberNotFound
+	}
+	invalidateSubscribers()
+	global.NotifyChange(global.ScopeSubscribers)
+	return nil
+}
+
+func invalidateSubscribers() {
+	subscriberCache.Clear()
+}
+
+// CheckSubscriber finds the subscriber of a token, disabled and expired subscribers are rejected
+func CheckSubscriber(token string) (*model.Subscriber, bool) {
+	if token == "" {
+		return nil, false
+	}
+	sub, ok := subscriberCache.Load(token)
+	if !ok {
+		if err := global.DB.Where("token = ?", token).First(&sub).Error; err != nil {
+			return nil, false
+		}
+		subscriberCache.Store(token, sub)
+	}
+	if sub.Disabled || (sub.Expires != nil && time.Now().After(*sub.Expires)) {
+		return nil, false
+	}
+	return &sub, true
+}
+
+// SubscriberAllows tells whether a subscriber may watch a channel
+func SubscriberAllows(sub *model.Subscriber, ch *model.Channel) bool {
+	categories := splitList(sub.Categories)
+	channels := splitList(sub.Channels)
+	if len(categories) == 0 && len(channels) == 0 {
+		return true
+	}
+	for _, category := range categories {
+		if strings.EqualFold(category, ch.Category) {
+			return true
+		}
+	}
+	for _, id := range channels {
+		if id == ch.ChannelID || id == ch.ParentID {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	global.OnChange(global.ScopeSubscribers, invalidateSubscribers)
+}
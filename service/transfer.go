@@ -0,0 +1,328 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/playlist/diyp"
+	"github.com/snowie2000/livetv/playlist/m3u"
+)
+
+const (
+	FormatJSON = "json"
+	FormatM3U  = "m3u"
+	FormatTXT  = "txt"
+
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictDuplicate = "duplicate"
+
+	// m3u attribute that keeps the parser of an exported channel
+	parserAttr = "livetv-parser"
+)
+
+var (
+	errUnknownFormat   = errors.New("Unknown import format")
+	errUnknownConflict = errors.New("Unknown conflict mode")
+)
+
+// ChannelExport is the portable form of a channel
+type ChannelExport struct {
+	Name     string             `json:"name"`
+	Logo     string             `json:"logo,omitempty"`
+	URL      string             `json:"url"`
+	Parser   string             `json:"parser"`
+	Proxy    bool               `json:"proxy"`
+	TsProxy  string             `json:"tsproxy,omitempty"`
+	ProxyUrl string             `json:"proxyurl,omitempty"`
+	Category string             `json:"category,omitempty"`
+	Extra    string             `json:"extra,omitempty"`
+	Filters  []model.FilterRule `json:"filters,omitempty"`
+}
+
+type ImportChange struct {
+	ID     int            `json:"id,omitempty"`
+	Before *ChannelExport `json:"before,omitempty"`
+	After  *ChannelExport `json:"after"`
+}
+
+// ImportResult describes what an import did, or would do in a dry run
+type ImportResult struct {
+	DryRun  bool           `json:"dryrun"`
+	Created []ImportChange `json:"created"`
+	Updated []ImportChange `json:"updated"`
+	Skipped []ImportChange `json:"skipped"`
+}
+
+func exportChannel(ch *model.Channel) *ChannelExport {
+	return &ChannelExport{
+		Name:     ch.Name,
+		Logo:     ch.Logo,
+		URL:      ch.URL,
+		Parser:   ch.Parser,
+		Proxy:    ch.Proxy,
+		TsProxy:  ch.TsProxy,
+		ProxyUrl: ch.ProxyUrl,
+		Category: ch.Category,
+		Extra:    ch.Extra,
+	}
+}
+
+// ExportChannels serializes all primary channels, sub channels are rebuilt by their providers after an import
+func ExportChannels(format string) (string, error) {
+	var channels []*model.Channel
+	if err := global.DB.Order("id").Find(&channels).Error; err != nil {
+		return "", err
+	}
+	switch format {
+	case FormatJSON:
+		list := make([]*ChannelExport, 0, len(channels))
+		for _, ch := range channels {
+			e := exportChannel(ch)
+			if ch.HasSubChannel {
+				e.Filters, _ = GetFilterRules(ch.ID)
+			}
+			list = append(list, e)
+		}
+		js, err := json.MarshalIndent(list, "", "  ")
+		return string(js), err
+	case FormatM3U:
+		playlist := m3u.Playlist{}
+		for _, ch := range channels {
+			track := m3u.Track{
+				Name:   ch.Name,
+				Length: -1,
+				URI:    ch.URL,
+			}
+			if ch.Logo != "" {
+				track.Tags = append(track.Tags, m3u.Tag{Name: "tvg-logo", Value: ch.Logo})
+			}
+			if ch.Category != "" {
+				track.Tags = append(track.Tags, m3u.Tag{Name: "group-title", Value: ch.Category})
+			}
+			track.Tags = append(track.Tags, m3u.Tag{Name: parserAttr, Value: ch.Parser})
+			playlist.Tracks = append(playlist.Tracks, track)
+		}
+		var buf bytes.Buffer
+		err := m3u.MarshallInto(playlist, bufio.NewWriter(&buf))
+		return buf.String(), err
+	case FormatTXT:
+		genres := make(map[string]*genre)
+		var genreList []string
+		for _, ch := range channels {
+			category := ch.Category
+			if category == "" {
+				category = "LiveTV"
+			}
+			g, ok := genres[category]
+			if !ok {
+				g = &genre{name: category, channels: make(map[string][]string)}
+				genres[category] = g
+				genreList = append(genreList, category)
+			}
+			g.addChannel(ch.Name, ch.URL)
+		}
+		var txt strings.Builder
+		for _, category := range genreList {
+			txt.WriteString(genres[category].String())
+			txt.WriteString("\n\n")
+		}
+		return txt.String(), nil
+	}
+	return "", errUnknownFormat
+}
+
+// guess the format of an import from its content
+func detectFormat(content []byte) string {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(content, []byte("\uFEFF")))
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("[")) || bytes.HasPrefix(trimmed, []byte("{")):
+		return FormatJSON
+	case bytes.HasPrefix(trimmed, []byte("#EXTM3U")):
+		return FormatM3U
+	default:
+		return FormatTXT
+	}
+}
+
+// decode an import file into channels, defaultParser is used for playlist formats that carry no parser
+func decodeImport(content []byte, format string, defaultParser string) ([]*ChannelExport, error) {
+	if format == "" {
+		format = detectFormat(content)
+	}
+	var list []*ChannelExport
+	switch format {
+	case FormatJSON:
+		if err := json.Unmarshal(content, &list); err != nil {
+			return nil, err
+		}
+	case FormatM3U:
+		playlist, err := m3u.ParseFromReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		for i := range playlist.Tracks {
+			track := &playlist.Tracks[i]
+			e := &ChannelExport{
+				Name:     track.Name,
+				URL:      track.URI,
+				Parser:   track.Tag(parserAttr),
+				Logo:     track.Tag("tvg-logo"),
+				Category: track.Tag("group-title"),
+			}
+			if e.Category == "" {
+				e.Category = track.Group
+			}
+			list = append(list, e)
+		}
+	case FormatTXT:
+		data, err := diyp.ParseChannelFromReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range data.Groups {
+			for _, ch := range group.Channels {
+				for _, source := range ch.Sources {
+					list = append(list, &ChannelExport{
+						Name:     ch.Name,
+						URL:      source.Url,
+						Category: group.Name,
+					})
+				}
+			}
+		}
+	default:
+		return nil, errUnknownFormat
+	}
+	for i, e := range list {
+		e.Name = strings.TrimSpace(e.Name)
+		e.URL = global.CleanString(e.URL)
+		e.Category = global.CleanString(e.Category)
+		if e.Parser == "" {
+			e.Parser = defaultParser
+		}
+		if e.Name == "" || e.URL == "" {
+			return nil, fmt.Errorf("entry %d: incomplete channel info", i+1)
+		}
+		if _, err := GetPlugin(e.Parser); err != nil {
+			return nil, fmt.Errorf("entry %d: %s: %w", i+1, e.Parser, err)
+		}
+		if _, err := NewEntryFilter(e.Filters); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i+1, err)
+		}
+	}
+	return list, nil
+}
+
+// ImportChannels creates channels from an export, existing channels with the same url are handled according to conflict.
+// In a dry run nothing is saved, the result shows what would change.
+func ImportChannels(content []byte, format string, defaultParser string, conflict string, dryRun bool) (*ImportResult, error) {
+	if conflict == "" {
+		conflict = ConflictSkip
+	}
+	if conflict != ConflictSkip && conflict != ConflictOverwrite && conflict != ConflictDuplicate {
+		return nil, errUnknownConflict
+	}
+	list, err := decodeImport(content, format, defaultParser)
+	if err != nil {
+		return nil, err
+	}
+	var existing []*model.Channel
+	if err = global.DB.Order("id").Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	byUrl := make(map[string]*model.Channel, len(existing))
+	for _, ch := range existing {
+		if _, ok := byUrl[ch.URL]; !ok {
+			byUrl[ch.URL] = ch
+		}
+	}
+
+	result := &ImportResult{
+		DryRun:  dryRun,
+		Created: []ImportChange{},
+		Updated: []ImportChange{},
+		Skipped: []ImportChange{},
+	}
+	// the import is written in one transaction so that a failing entry leaves the channels as they were
+	var tx *gorm.DB
+	if !dryRun {
+		if tx = global.DB.Begin(); tx.Error != nil {
+			return result, tx.Error
+		}
+	}
+	var changed []*model.Channel
+	for _, e := range list {
+		current, found := byUrl[e.URL]
+		if found && conflict == ConflictSkip {
+			result.Skipped = append(result.Skipped, ImportChange{ID: current.ID, Before: exportChannel(current), After: e})
+			continue
+		}
+		ch := &model.Channel{}
+		change := ImportChange{After: e}
+		if found && conflict == ConflictOverwrite {
+			ch = current
+			change.ID = current.ID
+			change.Before = exportChannel(current)
+		}
+		ch.Name = e.Name
+		ch.Logo = e.Logo
+		ch.URL = e.URL
+		ch.Parser = e.Parser
+		ch.Proxy = e.Proxy
+		ch.TsProxy = e.TsProxy
+		ch.ProxyUrl = e.ProxyUrl
+		ch.Category = e.Category
+		ch.Extra = e.Extra
+		ch.HasSubChannel = ProvidesSubChannels(ch.Parser)
+		if !dryRun {
+			if err = tx.Save(ch).Error; err != nil {
+				tx.Rollback()
+				return result, err
+			}
+			if e.Filters != nil {
+				if err = saveFilterRules(tx, ch.ID, e.Filters); err != nil {
+					tx.Rollback()
+					return result, err
+				}
+			}
+			change.ID = ch.ID
+			changed = append(changed, ch)
+		}
+		if change.Before != nil {
+			result.Updated = append(result.Updated, change)
+		} else {
+			result.Created = append(result.Created, change)
+			byUrl[e.URL] = ch
+		}
+	}
+	if dryRun {
+		return result, nil
+	}
+	if err = tx.Commit().Error; err != nil {
+		return result, err
+	}
+	global.InvalidateChannelServers()
+	for _, ch := range changed {
+		forgetChannel(ch.ID)
+		filterCache.Delete(ch.ID)
+	}
+	if len(changed) > 0 {
+		global.NotifyChange(global.ScopeChannels)
+		log.Println(len(changed), "channels imported")
+		go func() {
+			for _, ch := range changed {
+				UpdateURLCacheSingle(ch, true)
+			}
+		}()
+	}
+	return result, nil
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/util"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrTwoFactorNotStarted = errors.New("Two-factor enrolment hasn't been started")
+	ErrTwoFactorDisabled   = errors.New("Two-factor authentication isn't enabled")
+	ErrInvalidOTP          = errors.New("Invalid two-factor code")
+	twoFactorLock          sync.Mutex
+)
+
+// TwoFactorStatus tells whether logins of a user need a second factor and how many recovery codes are left
+type TwoFactorStatus struct {
+	Enabled       bool `json:"enabled"`
+	Pending       bool `json:"pending"`
+	RecoveryCodes int  `json:"recoverycodes"`
+}
+
+// TwoFactorEnrolment is what an authenticator app needs to be set up
+type TwoFactorEnrolment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth uri, to be shown as a qr code
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
+
+func recoveryHashes(user *model.User) []string {
+	if user.RecoveryCodes == "" {
+		return nil
+	}
+	return strings.Split(user.RecoveryCodes, ",")
+}
+
+// new recovery codes of a user, only their hashes are stored
+func newRecoveryCodes(user *model.User) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		text := hex.EncodeToString(buf)
+		codes[i] = text[:5] + "-" + text[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	user.RecoveryCodes = strings.Join(hashes, ",")
+	return codes, nil
+}
+
+// reload a user so that codes used by concurrent requests are seen, must be called with twoFactorLock held
+func reloadUser(user *model.User) error {
+	return global.DB.First(user, user.ID).Error
+}
+
+func GetTwoFactorStatus(user *model.User) TwoFactorStatus {
+	return TwoFactorStatus{
+		Enabled:       user.TOTPSecret != "",
+		Pending:       user.TOTPPending != "",
+		RecoveryCodes: len(recoveryHashes(user)),
+	}
+}
+
+// BeginTwoFactor creates a secret for an authenticator app, it is used once a code made with it has been confirmed
+func BeginTwoFactor(user *model.User) (*TwoFactorEnrolment, error) {
+	secret, err := util.NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := global.DB.Model(user).Update("totp_pending", secret).Error; err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", "LiveTV")
+	query.Set("digits", strconv.Itoa(util.TOTPDigits))
+	query.Set("period", strconv.Itoa(util.TOTPPeriod))
+	uri := url.URL{Scheme: "otpauth", Host: "totp", Path: "/LiveTV:" + user.Name, RawQuery: query.Encode()}
+	return &TwoFactorEnrolment{Secret: secret, URI: uri.String()}, nil
+}
+
+// ConfirmTwoFactor enables the pending secret once code proves the app has it, and returns the recovery codes
+func ConfirmTwoFactor(user *model.User, code string) ([]string, error) {
+	twoFactorLock.Lock()
+	defer twoFactorLock.Unlock()
+	if err := reloadUser(user); err != nil {
+		return nil, err
+	}
+	if user.TOTPPending == "" {
+		return nil, ErrTwoFactorNotStarted
+	}
+	step, ok := util.CheckTOTP(user.TOTPPending, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidOTP
+	}
+	codes, err := newRecoveryCodes(user)
+	if err != nil {
+		return nil, err
+	}
+	user.TOTPSecret = user.TOTPPending
+	user.TOTPPending = ""
+	user.TOTPLast = step
+	if err := global.DB.Save(user).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CheckTwoFactor verifies a code of the authenticator app of a user, or uses up a recovery code.
+// A code of the app works only once.
+func CheckTwoFactor(user *model.User, code string) bool {
+	twoFactorLock.Lock()
+	defer twoFactorLock.Unlock()
+	if err := reloadUser(user); err != nil || user.TOTPSecret == "" {
+		return false
+	}
+	if step, ok := util.CheckTOTP(user.TOTPSecret, code, time.Now()); ok {
+		if step <= user.TOTPLast {
+			return false // replayed
+		}
+		user.TOTPLast = step
+		return global.DB.Model(user).Update("totp_last", step).Error == nil
+	}
+	hash := hashRecoveryCode(code)
+	hashes := recoveryHashes(user)
+	for i, h := range hashes {
+		if h == hash {
+			hashes = append(hashes[:i], hashes[i+1:]...)
+			user.RecoveryCodes = strings.Join(hashes, ",")
+			return global.DB.Model(user).Update("recovery_codes", user.RecoveryCodes).Error == nil
+		}
+	}
+	return false
+}
+
+// NewRecoveryCodes replaces the recovery codes of a user
+func NewRecoveryCodes(user *model.User) ([]string, error) {
+	twoFactorLock.Lock()
+	defer twoFactorLock.Unlock()
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorDisabled
+	}
+	codes, err := newRecoveryCodes(user)
+	if err != nil {
+		return nil, err
+	}
+	if err := global.DB.Model(user).Update("recovery_codes", user.RecoveryCodes).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor removes the second factor of a user, logins need the password only
+func DisableTwoFactor(user *model.User) error {
+	twoFactorLock.Lock()
+	defer twoFactorLock.Unlock()
+	user.TOTPSecret = ""
+	user.TOTPPending = ""
+	user.TOTPLast = 0
+	user.RecoveryCodes = ""
+	return global.DB.Save(user).Error
+}
+
+// ResetTwoFactor turns off two-factor authentication of a user by name. It is meant for the command line.
+func ResetTwoFactor(name string) error {
+	user, err := getUserByName(name)
+	if err != nil {
+		return err
+	}
+	return DisableTwoFactor(user)
+}
@@ -1,7 +1,6 @@
 package service
 
 import (
-	"fmt"
 	"log"
 	"strings"
 
@@ -37,7 +36,8 @@ func (g *genre) String() string {
 	return strings.Join(channels, "\n")
 }
 
-func TXTGenerate() (string, error) {
+// TXTGenerate writes the playlist of all channels, or of the channels a subscriber may watch when sub isn't nil
+func TXTGenerate(sub *model.Subscriber, client global.LinkClient) (string, error) {
 	baseUrl, err := global.GetConfig("base_url")
 	if err != nil {
 		log.Println(err)
@@ -51,11 +51,21 @@ func TXTGenerate() (string, error) {
 	genres := make(map[string]*genre)
 	var genreList []string
 	writeChannel := func(ch *model.Channel) {
+		if sub != nil && !SubscriberAllows(sub, ch) {
+			return
+		}
+		if !PlaybackAllowed(client.IP, ch) {
+			return
+		}
 		category := "LiveTV"
 		if ch.Category != "" {
 			category = ch.Category
 		}
-		composedUrl := fmt.Sprintf("%s/live.m3u8?token=%s&c=%s", baseUrl, ch.Token, ch.ChannelID)
+		token := ch.Token
+		if sub != nil {
+			token = sub.Token
+		}
+		composedUrl := baseUrl + "/live.m3u8?" + LiveQuery(token, ch.ChannelID, client)
 		if ch.CustomQueryString != "" {
 			composedUrl = composedUrl + "&" + ch.CustomQueryString
 		}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+)
+
+var (
+	ErrUserNotFound = errors.New("User not found")
+	ErrUserExists   = errors.New("A user with this name exists already")
+	ErrLastOwner    = errors.New("At least one enabled owner is needed")
+	ErrInvalidRole  = errors.New("Role must be viewer, editor or owner")
+)
+
+var roleRanks = map[string]int{
+	model.RoleViewer: 1,
+	model.RoleEditor: 2,
+	model.RoleOwner:  3,
+}
+
+// RoleAllows tells whether a user with role may do what needs the role required
+func RoleAllows(role string, required string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[required]
+}
+
+func GetUsers() (users []model.User, err error) {
+	users = []model.User{}
+	err = global.DB.Order("id").Find(&users).Error
+	return
+}
+
+func GetUser(id int) (*model.User, error) {
+	var user model.User
+	if err := global.DB.First(&user, id).Error; err != nil {
+		return nil, ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func getUserByName(name string) (*model.User, error) {
+	var user model.User
+	if err := global.DB.Where("name = ?", name).First(&user).Error; err != nil {
+		return nil, ErrUserNotFound
+	}
+	return &user, nil
+}
+
+// owners that can still log in, not counting the user with id except
+func otherOwners(except int) (int, error) {
+	var count int
+	err := global.DB.Model(&model.User{}).Where("role = ? AND disabled = ? AND id <> ?", model.RoleOwner, false, except).Count(&count).Error
+	return count, err
+}
+
+// SaveUser creates or updates a user, the password is changed when it isn't empty. New users need a password.
+func SaveUser(user *model.User, password string) error {
+	user.Name = strings.TrimSpace(user.Name)
+	if user.Name == "" {
+		return errors.New("User needs a name")
+	}
+	if roleRanks[user.Role] == 0 {
+		return ErrInvalidRole
+	}
+	if other, err := getUserByName(user.Name); err == nil && other.ID != user.ID {
+		return ErrUserExists
+	}
+	if user.ID == 0 {
+		if password == "" {
+			return errors.New("New users need a password")
+		}
+		user.Created = time.Now()
+	} else if user.Role != model.RoleOwner || user.Disabled {
+		// an owner must stay to manage settings and users
+		count, err := otherOwners(user.ID)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrLastOwner
+		}
+	}
+	if password != "" {
+		hash, err := global.HashPassword(password)
+		if err != nil {
+			return err
+		}
+		user.Password = hash
+	}
+	return global.DB.Save(user).Error
+}
+
+func DeleteUser(id int) error {
+	count, err := otherOwners(id)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrLastOwner
+	}
+	res := global.DB.Delete(model.User{}, "id = ?", id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetUserPassword changes the password of a user
+func SetUserPassword(user *model.User, password string) error {
+	if password == "" {
+		return errors.New("Empty password")
+	}
+	hash, err := global.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	user.Password = hash
+	return global.DB.Model(user).Update("password", hash).Error
+}
+
+// ResetPassword sets the password of a user, an owner is created when there is no user of that name. It is meant for the command line.
+func ResetPassword(name string, password string) error {
+	user, err := getUserByName(name)
+	if err != nil {
+		return SaveUser(&model.User{Name: name, Role: model.RoleOwner}, password)
+	}
+	if user.Disabled {
+		if err := global.DB.Model(user).Update("disabled", false).Error; err != nil {
+			return err
+		}
+	}
+	return SetUserPassword(user, password)
+}
+
+// Authenticate finds the user of a name and password, disabled users can't log in
+func Authenticate(name string, password string) (*model.User, bool, error) {
+	user, err := getUserByName(name)
+	if err != nil {
+		// spend the same time as for a real user, so names can't be probed
+		global.HashPassword(password)
+		return nil, false, nil
+	}
+	if user.Password == "" {
+		return nil, false, nil // single sign-on only
+	}
+	ok, err := global.VerifyPassword(password, user.Password)
+	if err != nil || !ok || user.Disabled {
+		return nil, false, err
+	}
+	return user, true, nil
+}
+
+// UserLoggedIn records the time of a login
+func UserLoggedIn(user *model.User) {
+	now := time.Now()
+	user.LastLogin = &now
+	global.DB.Model(user).Update("last_login", now)
+}
@@ -0,0 +1,336 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+)
+
+const (
+	WebhookJSON     = "json"
+	WebhookTelegram = "telegram"
+	WebhookNtfy     = "ntfy"
+	WebhookGotify   = "gotify"
+
+	defaultWebhookRate = 20 // notifications per minute
+	telegramAPI        = "https://api.telegram.org"
+)
+
+var (
+	webhookRetries  = []time.Duration{2 * time.Second, 10 * time.Second, 30 * time.Second}
+	webhookClient   = &http.Client{Timeout: 10 * time.Second, Transport: global.TransportWithProxy("")}
+	webhookLock     sync.Mutex
+	webhookCache    []model.Webhook // enabled webhooks, nil when they need to be loaded
+	webhookWindows  = make(map[int]*rateWindow)
+	errWebhookKind  = errors.New("Unknown webhook kind")
+	errWebhookURL   = errors.New("Invalid webhook url")
+	errWebhookToken = errors.New("Webhook needs a token")
+	errWebhookBody  = errors.New("Webhook template didn't produce valid json")
+
+	// values are put in json templates with {{json .Name}}, which quotes and escapes them
+	webhookFuncs = template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+)
+
+// counts notifications of a webhook in the current minute
+type rateWindow struct {
+	start   time.Time
+	sent    int
+	dropped int
+}
+
+// ValidateWebhook checks a webhook before it is saved
+func ValidateWebhook(hook *model.Webhook) error {
+	switch hook.Kind {
+	case WebhookJSON, WebhookNtfy, WebhookGotify:
+		u, err := url.Parse(hook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return errWebhookURL
+		}
+	case WebhookTelegram:
+		if hook.Token == "" || hook.ChatID == "" {
+			return errWebhookToken
+		}
+	default:
+		return errWebhookKind
+	}
+	if hook.Kind == WebhookGotify && hook.Token == "" {
+		return errWebhookToken
+	}
+	if hook.Template != "" {
+		if _, err := template.New("webhook").Funcs(webhookFuncs).Parse(hook.Template); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetWebhooks() (hooks []model.Webhook, err error) {
+	hooks = []model.Webhook{}
+	err = global.DB.Order("id").Find(&hooks).Error
+	return
+}
+
+func SaveWebhook(hook *model.Webhook) error {
+	if err := ValidateWebhook(hook); err != nil {
+		return err
+	}
+	if err := global.DB.Save(hook).Error; err != nil {
+		return err
+	}
+	invalidateWebhooks()
+	global.NotifyChange(global.ScopeWebhooks)
+	return nil
+}
+
+func DeleteWebhook(id int) error {
+	if err := global.DB.Delete(model.Webhook{}, "id = ?", id).Error; err != nil {
+		return err
+	}
+	invalidateWebhooks()
+	global.NotifyChange(global.ScopeWebhooks)
+	return nil
+}
+
+func invalidateWebhooks() {
+	webhookLock.Lock()
+	webhookCache = nil
+	webhookLock.Unlock()
+}
+
+func enabledWebhooks() []model.Webhook {
+	webhookLock.Lock()
+	defer webhookLock.Unlock()
+	if webhookCache == nil {
+		var hooks []model.Webhook
+		if err := global.DB.Where("enabled = ?", true).Find(&hooks).Error; err != nil {
+			log.Println("failed to load webhooks:", err)
+			return nil
+		}
+		webhookCache = append([]model.Webhook{}, hooks...)
+	}
+	return webhookCache
+}
+
+func splitList(list string) []string {
+	var result []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// whether a webhook is interested in an event
+func webhookWants(hook *model.Webhook, e *Event) bool {
+	if events := splitList(hook.Events); len(events) > 0 {
+		found := false
+		for _, t := range events {
+			if t == e.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if channels := splitList(hook.Channels); len(channels) > 0 {
+		if e.ChannelID == "" {
+			return false
+		}
+		parent, _, _ := strings.Cut(e.ChannelID, "-")
+		for _, id := range channels {
+			if id == e.ChannelID || id == parent {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// take a slot of the rate limit of a webhook
+func webhookAllowed(hook *model.Webhook) bool {
+	limit := hook.RateLimit
+	if limit <= 0 {
+		limit = defaultWebhookRate
+	}
+	webhookLock.Lock()
+	defer webhookLock.Unlock()
+	w, ok := webhookWindows[hook.ID]
+	if !ok || time.Since(w.start) > time.Minute {
+		if ok && w.dropped > 0 {
+			log.Printf("webhook %s: %d notifications dropped by the rate limit\n", hook.Name, w.dropped)
+		}
+		w = &rateWindow{start: time.Now()}
+		webhookWindows[hook.ID] = w
+	}
+	if w.sent >= limit {
+		w.dropped++
+		return false
+	}
+	w.sent++
+	return true
+}
+
+// build the request of a webhook for an event
+func webhookRequest(hook *model.Webhook, e *Event) (*http.Request, error) {
+	var req *http.Request
+	var err error
+	switch hook.Kind {
+	case WebhookJSON:
+		var body []byte
+		if hook.Template != "" {
+			var buf bytes.Buffer
+			tpl, err := template.New("webhook").Funcs(webhookFuncs).Parse(hook.Template)
+			if err != nil {
+				return nil, err
+			}
+			if err = tpl.Execute(&buf, e); err != nil {
+				return nil, err
+			}
+			body = buf.Bytes()
+			// a value that isn't passed through json can break the body or add fields to it
+			if !json.Valid(body) {
+				return nil, errWebhookBody
+			}
+		} else {
+			body, _ = json.Marshal(e)
+		}
+		req, err = http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+			if hook.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+hook.Token)
+			}
+		}
+	case WebhookTelegram:
+		api := strings.TrimSuffix(hook.URL, "/")
+		if api == "" {
+			api = telegramAPI
+		}
+		body, _ := json.Marshal(map[string]string{
+			"chat_id": hook.ChatID,
+			"text":    e.Title + "\n" + e.Message,
+		})
+		req, err = http.NewRequest(http.MethodPost, api+"/bot"+hook.Token+"/sendMessage", bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	case WebhookNtfy:
+		req, err = http.NewRequest(http.MethodPost, hook.URL, strings.NewReader(e.Message))
+		if err == nil {
+			req.Header.Set("Title", e.Title)
+			req.Header.Set("Tags", e.Type)
+			if e.Status == StatusNames[Error] || e.Type == EventLoginFailed {
+				req.Header.Set("Priority", "high")
+			}
+			if hook.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+hook.Token)
+			}
+		}
+	case WebhookGotify:
+		priority := 5
+		if e.Status == StatusNames[Error] || e.Type == EventLoginFailed {
+			priority = 8
+		}
+		body, _ := json.Marshal(map[string]any{
+			"title":    e.Title,
+			"message":  e.Message,
+			"priority": priority,
+		})
+		req, err = http.NewRequest(http.MethodPost, strings.TrimSuffix(hook.URL, "/")+"/message?token="+url.QueryEscape(hook.Token), bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	default:
+		return nil, errWebhookKind
+	}
+	return req, err
+}
+
+// errors of a request carry its url, and with it the token of telegram and gotify hooks
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// deliver an event to a webhook, retrying server errors
+func deliverWebhook(hook model.Webhook, e Event) error {
+	var lastErr error
+	for attempt := 0; attempt <= len(webhookRetries); attempt++ {
+		if attempt > 0 {
+			time.Sleep(webhookRetries[attempt-1])
+		}
+		req, err := webhookRequest(&hook, &e)
+		if err != nil {
+			return withoutURL(err)
+		}
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			lastErr = withoutURL(err)
+			continue
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("Server response: HTTP %d", resp.StatusCode)
+		// a bad request won't get any better
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			break
+		}
+	}
+	return lastErr
+}
+
+func notifyWebhooks(e Event) {
+	for _, hook := range enabledWebhooks() {
+		if !webhookWants(&hook, &e) || !webhookAllowed(&hook) {
+			continue
+		}
+		go func(hook model.Webhook) {
+			if err := deliverWebhook(hook, e); err != nil {
+				log.Printf("webhook %s (%s): %s\n", hook.Name, hook.Kind, err)
+			}
+		}(hook)
+	}
+}
+
+// TestWebhook sends a test event to a webhook right away
+func TestWebhook(hook *model.Webhook) error {
+	e := Event{
+		Type:    "test",
+		Time:    time.Now(),
+		Title:   "LiveTV: test notification",
+		Message: "Webhook " + hook.Name + " works",
+	}
+	return deliverWebhook(*hook, e)
+}
+
+func init() {
+	Subscribe(notifyWebhooks)
+	global.OnChange(global.ScopeWebhooks, invalidateWebhooks)
+}
@@ -0,0 +1,228 @@
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+var b64 = base64.RawURLEncoding
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testECKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// a token with the claims signed by key with alg, the way identity providers make them
+func testJWT(t *testing.T, alg string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": "k1", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	hash := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}[alg[2:]]
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	var sig []byte
+	var err error
+	switch alg[:2] {
+	case "RS":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), hash, digest)
+	case "PS":
+		sig, err = rsa.SignPSS(rand.Reader, key.(*rsa.PrivateKey), hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES":
+		// r and s padded to the size of the curve, not asn.1
+		priv := key.(*ecdsa.PrivateKey)
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, priv, digest)
+		size := (priv.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func rsaJWK(key *rsa.PublicKey) JWK {
+	return JWK{Kty: "RSA", N: b64.EncodeToString(key.N.Bytes()), E: b64.EncodeToString(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func ecJWK(key *ecdsa.PublicKey) JWK {
+	return JWK{Kty: "EC", Crv: key.Curve.Params().Name, X: b64.EncodeToString(key.X.Bytes()), Y: b64.EncodeToString(key.Y.Bytes())}
+}
+
+func TestParseJWT(t *testing.T) {
+	header := b64.EncodeToString([]byte(`{"alg":"RS256","kid":"k1"}`))
+	claims := b64.EncodeToString([]byte(`{"sub":"123","email":"a@example.com"}`))
+	sig := b64.EncodeToString([]byte("signature"))
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", header + "." + claims + "." + sig, true},
+		{"padded segments", header + "==." + claims + "." + sig, true},
+		{"two parts", header + "." + claims, false},
+		{"four parts", header + "." + claims + "." + sig + "." + sig, false},
+		{"empty", "", false},
+		{"header not base64", "!!." + claims + "." + sig, false},
+		{"header not json", b64.EncodeToString([]byte("alg")) + "." + claims + "." + sig, false},
+		{"claims not base64", header + ".!!." + sig, false},
+		{"claims not an object", header + "." + b64.EncodeToString([]byte(`["sub"]`)) + "." + sig, false},
+		{"signature not base64", header + "." + claims + ".!!", false},
+		{"standard base64", header + "." + claims + "." + base64.StdEncoding.EncodeToString([]byte("sig+/sig?")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, c, signed, signature, err := ParseJWT(tt.token)
+			if (err == nil) != tt.ok {
+				t.Fatalf("ParseJWT error %v, want ok = %v", err, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+			if h.Alg != "RS256" || h.Kid != "k1" || c["sub"] != "123" || string(signature) != "signature" {
+				t.Errorf("ParseJWT = %+v, %v, %q", h, c, signature)
+			}
+			if want := strings.Join(strings.Split(tt.token, ".")[:2], "."); signed != want {
+				t.Errorf("signed part %q, want %q", signed, want)
+			}
+		})
+	}
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	rsaKey := testRSAKey(t)
+	ecKey := testECKey(t, elliptic.P256())
+	offCurve := ecJWK(&ecKey.PublicKey)
+	offCurve.Y = b64.EncodeToString(new(big.Int).Add(ecKey.Y, big.NewInt(1)).Bytes())
+	tests := []struct {
+		name string
+		jwk  JWK
+		ok   bool
+	}{
+		{"rsa", rsaJWK(&rsaKey.PublicKey), true},
+		{"ec", ecJWK(&ecKey.PublicKey), true},
+		{"rsa exponent 1", JWK{Kty: "RSA", N: rsaJWK(&rsaKey.PublicKey).N, E: "AQ"}, false},
+		{"rsa huge exponent", JWK{Kty: "RSA", N: rsaJWK(&rsaKey.PublicKey).N, E: b64.EncodeToString(make([]byte, 9))}, false},
+		{"rsa without modulus", JWK{Kty: "RSA", E: "AQAB"}, false},
+		{"ec off its curve", offCurve, false},
+		{"ec unknown curve", JWK{Kty: "EC", Crv: "P-224", X: offCurve.X, Y: offCurve.Y}, false},
+		{"ec without y", JWK{Kty: "EC", Crv: "P-256", X: offCurve.X}, false},
+		{"symmetric key", JWK{Kty: "oct"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.jwk.PublicKey(); (err == nil) != tt.ok {
+				t.Errorf("PublicKey error %v, want ok = %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestVerifyJWTSignature(t *testing.T) {
+	rsaKey := testRSAKey(t)
+	otherRSA := testRSAKey(t)
+	p256 := testECKey(t, elliptic.P256())
+	p384 := testECKey(t, elliptic.P384())
+	claims := map[string]any{"sub": "123", "email": "a@example.com"}
+
+	// the public key as a provider publishes it
+	public := func(jwk JWK) crypto.PublicKey {
+		key, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	rsaPub := public(rsaJWK(&rsaKey.PublicKey))
+	p256Pub := public(ecJWK(&p256.PublicKey))
+	p384Pub := public(ecJWK(&p384.PublicKey))
+
+	tamper := func(token string) string {
+		parts := strings.Split(token, ".")
+		parts[1] = b64.EncodeToString([]byte(`{"sub":"123","email":"admin@example.com"}`))
+		return strings.Join(parts, ".")
+	}
+	realg := func(token string, alg string) string {
+		parts := strings.Split(token, ".")
+		parts[0] = b64.EncodeToString([]byte(`{"alg":"` + alg + `","kid":"k1"}`))
+		return strings.Join(parts, ".")
+	}
+	rs256 := testJWT(t, "RS256", rsaKey, claims)
+	es256 := testJWT(t, "ES256", p256, claims)
+
+	tests := []struct {
+		name  string
+		token string
+		alg   string // the alg the token is checked with, its header's when empty
+		key   crypto.PublicKey
+		ok    bool
+	}{
+		{"RS256", rs256, "", rsaPub, true},
+		{"RS384", testJWT(t, "RS384", rsaKey, claims), "", rsaPub, true},
+		{"RS512", testJWT(t, "RS512", rsaKey, claims), "", rsaPub, true},
+		{"PS256", testJWT(t, "PS256", rsaKey, claims), "", rsaPub, true},
+		{"ES256", es256, "", p256Pub, true},
+		{"ES384", testJWT(t, "ES384", p384, claims), "", p384Pub, true},
+		{"tampered claims", tamper(rs256), "", rsaPub, false},
+		{"tampered ec claims", tamper(es256), "", p256Pub, false},
+		{"other rsa key", rs256, "", public(rsaJWK(&otherRSA.PublicKey)), false},
+		{"other curve", es256, "", p384Pub, false},
+		{"rsa key for ec alg", es256, "", rsaPub, false},
+		{"ec key for rsa alg", rs256, "", p256Pub, false},
+		{"RS256 checked as PS256", rs256, "PS256", rsaPub, false},
+		{"RS256 checked as RS512", rs256, "RS512", rsaPub, false},
+		{"alg none", realg(rs256, "none"), "", rsaPub, false},
+		{"alg HS256", realg(rs256, "HS256"), "", rsaPub, false},
+		{"alg lower case", realg(rs256, "rs256"), "", rsaPub, false},
+		{"alg RS1", realg(rs256, "RS1"), "", rsaPub, false},
+		{"no key", rs256, "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, _, signed, signature, err := ParseJWT(tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			alg := tt.alg
+			if alg == "" {
+				alg = header.Alg
+			}
+			if err = VerifyJWTSignature(alg, tt.key, signed, signature); (err == nil) != tt.ok {
+				t.Errorf("VerifyJWTSignature error %v, want ok = %v", err, tt.ok)
+			}
+		})
+	}
+
+	// ecdsa signatures in asn.1 or with a byte missing are refused rather than read somehow
+	_, _, signed, signature, _ := ParseJWT(es256)
+	for _, sig := range [][]byte{signature[:len(signature)-1], append(signature, 0), {}} {
+		if err := VerifyJWTSignature("ES256", p256Pub, signed, sig); err == nil {
+			t.Errorf("signature of %d bytes accepted", len(sig))
+		}
+	}
+}
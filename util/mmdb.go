@@ -0,0 +1,255 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/big"
+	"net"
+	"os"
+)
+
+// a reader of MaxMind DB files (https://maxmind.github.io/MaxMind-DB/), the format of the GeoLite2
+// and DB-IP databases, enough to look up the record of an address
+
+var ErrMMDBFormat = errors.New("invalid maxmind db file")
+
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// records nest maps and arrays, and pointers may point back, so decoding stops at some depth
+const mmdbMaxDepth = 32
+
+// MMDB is a MaxMind DB file read into memory
+type MMDB struct {
+	DatabaseType string
+	BuildEpoch   uint64
+
+	buf        []byte
+	data       []byte // the data section, which pointers are relative to
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint // the node of ::/96, where ipv4 addresses start in an ipv6 tree
+}
+
+// OpenMMDB reads a MaxMind DB file
+func OpenMMDB(path string) (*MMDB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewMMDB(buf)
+}
+
+// NewMMDB reads a MaxMind DB from its content
+func NewMMDB(buf []byte) (*MMDB, error) {
+	end := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if end < 0 {
+		return nil, ErrMMDBFormat
+	}
+	meta, _, err := decodeMMDB(buf[end+len(mmdbMetadataMarker):], 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := meta.(map[string]any)
+	if !ok {
+		return nil, ErrMMDBFormat
+	}
+	db := &MMDB{buf: buf}
+	db.DatabaseType, _ = fields["database_type"].(string)
+	db.BuildEpoch, _ = fields["build_epoch"].(uint64)
+	nodeCount, _ := fields["node_count"].(uint64)
+	recordSize, _ := fields["record_size"].(uint64)
+	ipVersion, _ := fields["ip_version"].(uint64)
+	db.nodeCount, db.recordSize, db.ipVersion = uint(nodeCount), uint(recordSize), uint(ipVersion)
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, ErrMMDBFormat
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, ErrMMDBFormat
+	}
+	// the search tree is followed by 16 zero bytes and the data section
+	treeSize := nodeCount * recordSize / 4
+	if treeSize+16 > uint64(end) {
+		return nil, ErrMMDBFormat
+	}
+	db.data = buf[treeSize+16 : end]
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// the left (0) or right (1) record of a node of the search tree
+func (db *MMDB) record(node uint, bit byte) uint {
+	b := db.buf[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		if bit == 1 {
+			b = b[3:]
+		}
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		// the middle byte holds the high nibbles of both records
+		if bit == 1 {
+			return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+		}
+		return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	}
+	if bit == 1 {
+		b = b[4:]
+	}
+	return uint(binary.BigEndian.Uint32(b))
+}
+
+// Lookup returns the record of the network an address is in, nil when there is none
+func (db *MMDB) Lookup(ip net.IP) (any, error) {
+	node, bits := uint(0), 128
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits = v4, 32
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if ip = ip.To16(); ip == nil {
+		return nil, errors.New("invalid address")
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+	for i := 0; i < bits && node < db.nodeCount; i++ {
+		node = db.record(node, (ip[i/8]>>(7-uint(i%8)))&1)
+	}
+	switch {
+	case node == db.nodeCount:
+		return nil, nil
+	case node < db.nodeCount+16:
+		return nil, ErrMMDBFormat
+	}
+	record, _, err := decodeMMDB(db.data, node-db.nodeCount-16, 0)
+	return record, err
+}
+
+// decode the value at offset of a section, returns it and the offset of the next one
+func decodeMMDB(section []byte, offset uint, depth int) (any, uint, error) {
+	if depth > mmdbMaxDepth || offset >= uint(len(section)) {
+		return nil, 0, ErrMMDBFormat
+	}
+	ctrl := section[offset]
+	offset++
+	kind := uint(ctrl >> 5)
+	if kind == 1 {
+		// a pointer to a value elsewhere in the section, its size bits tell how long it is
+		size := uint(ctrl>>3) & 3
+		if offset+size+1 > uint(len(section)) {
+			return nil, 0, ErrMMDBFormat
+		}
+		p := uint(0)
+		if size < 3 {
+			p = uint(ctrl & 7)
+		}
+		for _, b := range section[offset : offset+size+1] {
+			p = p<<8 | uint(b)
+		}
+		p += [4]uint{0, 2048, 526336, 0}[size]
+		value, _, err := decodeMMDB(section, p, depth+1)
+		return value, offset + size + 1, err
+	}
+	if kind == 0 {
+		// extended types are in the next byte
+		if offset >= uint(len(section)) {
+			return nil, 0, ErrMMDBFormat
+		}
+		kind = 7 + uint(section[offset])
+		offset++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(section)) {
+			return nil, 0, ErrMMDBFormat
+		}
+		size = 0
+		for _, b := range section[offset : offset+n] {
+			size = size<<8 | uint(b)
+		}
+		size += [4]uint{0, 29, 285, 65821}[n]
+		offset += n
+	}
+	switch kind {
+	case 7: // map
+		m := make(map[string]any)
+		for i := uint(0); i < size; i++ {
+			key, next, err := decodeMMDB(section, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, ErrMMDBFormat
+			}
+			if m[name], offset, err = decodeMMDB(section, next, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case 11: // array
+		var list []any
+		for i := uint(0); i < size; i++ {
+			value, next, err := decodeMMDB(section, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			list, offset = append(list, value), next
+		}
+		return list, offset, nil
+	case 14: // boolean, the size is the value
+		return size != 0, offset, nil
+	}
+	if offset+size > uint(len(section)) {
+		return nil, 0, ErrMMDBFormat
+	}
+	b := section[offset : offset+size]
+	offset += size
+	switch kind {
+	case 2: // utf-8 string
+		return string(b), offset, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, ErrMMDBFormat
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 4: // bytes
+		return append([]byte{}, b...), offset, nil
+	case 5, 6, 9: // unsigned integers of up to 16, 32 and 64 bits
+		if size > 8 {
+			return nil, 0, ErrMMDBFormat
+		}
+		v := uint64(0)
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	case 8: // int32
+		if size > 4 {
+			return nil, 0, ErrMMDBFormat
+		}
+		v := uint32(0)
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		if size == 4 {
+			return int64(int32(v)), offset, nil
+		}
+		return int64(v), offset, nil
+	case 10: // uint128
+		return new(big.Int).SetBytes(b), offset, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, ErrMMDBFormat
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	}
+	return nil, 0, ErrMMDBFormat
+}
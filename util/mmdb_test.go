@@ -0,0 +1,418 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/big"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// a pointer to a value at an offset of the data section
+type mmdbPointer uint
+
+// encode a value in the data section format
+func encodeMMDB(buf []byte, v any) []byte {
+	header := func(kind int, size int) {
+		ctrl := byte(0)
+		if kind <= 7 {
+			ctrl = byte(kind << 5)
+		}
+		var sizeBytes []byte
+		switch {
+		case size < 29:
+			ctrl |= byte(size)
+		case size < 285:
+			ctrl |= 29
+			sizeBytes = []byte{byte(size - 29)}
+		case size < 65821:
+			ctrl |= 30
+			sizeBytes = binary.BigEndian.AppendUint16(nil, uint16(size-285))
+		default:
+			ctrl |= 31
+			sizeBytes = binary.BigEndian.AppendUint32(nil, uint32(size-65821))[1:]
+		}
+		buf = append(buf, ctrl)
+		if kind > 7 {
+			buf = append(buf, byte(kind-7))
+		}
+		buf = append(buf, sizeBytes...)
+	}
+	unsigned := func(kind int, value uint64) {
+		b := bytes.TrimLeft(binary.BigEndian.AppendUint64(nil, value), "\x00")
+		header(kind, len(b))
+		buf = append(buf, b...)
+	}
+	switch v := v.(type) {
+	case mmdbPointer:
+		if v >= 2048 {
+			panic("only small pointers are written")
+		}
+		buf = append(buf, byte(1<<5|v>>8), byte(v))
+	case string:
+		header(2, len(v))
+		buf = append(buf, v...)
+	case float64:
+		header(3, 8)
+		buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(v))
+	case []byte:
+		header(4, len(v))
+		buf = append(buf, v...)
+	case uint16:
+		unsigned(5, uint64(v))
+	case uint32:
+		unsigned(6, uint64(v))
+	case map[string]any:
+		header(7, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			buf = encodeMMDB(buf, k)
+			buf = encodeMMDB(buf, v[k])
+		}
+	case int32:
+		header(8, 4)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(v))
+	case uint64:
+		unsigned(9, v)
+	case *big.Int:
+		header(10, len(v.Bytes()))
+		buf = append(buf, v.Bytes()...)
+	case []any:
+		header(11, len(v))
+		for _, item := range v {
+			buf = encodeMMDB(buf, item)
+		}
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		header(14, size)
+	case float32:
+		header(15, 4)
+		buf = binary.BigEndian.AppendUint32(buf, math.Float32bits(v))
+	default:
+		panic("can't encode " + reflect.TypeOf(v).String())
+	}
+	return buf
+}
+
+type mmdbTestNode struct {
+	next [2]*mmdbTestNode
+	data [2]int // offset+1 of the record in the data section, 0 when there is none
+}
+
+type mmdbNetwork struct {
+	cidr   string
+	record any
+}
+
+// the data section of test databases starts with this country, records can point to it with mmdbPointer(0)
+var mmdbGermany = map[string]any{"iso_code": "DE", "geoname_id": uint32(2921044), "names": map[string]any{"en": "Germany"}}
+
+// build a database with the networks, which mustn't overlap
+func buildMMDB(t *testing.T, ipVersion int, recordSize int, networks []mmdbNetwork) []byte {
+	t.Helper()
+	data := encodeMMDB(nil, mmdbGermany)
+	root := &mmdbTestNode{}
+	for _, n := range networks {
+		_, network, err := net.ParseCIDR(n.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip := []byte(network.IP)
+		ones, _ := network.Mask.Size()
+		if len(ip) == 4 && ipVersion == 6 {
+			ip, ones = append(make([]byte, 12), ip...), ones+96
+		}
+		offset := len(data)
+		data = encodeMMDB(data, n.record)
+		node := root
+		for i := 0; i < ones-1; i++ {
+			bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+			if node.next[bit] == nil {
+				node.next[bit] = &mmdbTestNode{}
+			}
+			node = node.next[bit]
+		}
+		node.data[(ip[(ones-1)/8]>>(7-uint((ones-1)%8)))&1] = offset + 1
+	}
+
+	// nodes are numbered breadth first, the root is 0
+	nodes := []*mmdbTestNode{root}
+	index := map[*mmdbTestNode]int{root: 0}
+	for i := 0; i < len(nodes); i++ {
+		for _, next := range nodes[i].next {
+			if next != nil {
+				index[next] = len(nodes)
+				nodes = append(nodes, next)
+			}
+		}
+	}
+	count := len(nodes)
+	value := func(node *mmdbTestNode, bit int) uint32 {
+		switch {
+		case node.next[bit] != nil:
+			return uint32(index[node.next[bit]])
+		case node.data[bit] != 0:
+			return uint32(count + 16 + node.data[bit] - 1)
+		}
+		return uint32(count)
+	}
+	var tree []byte
+	for _, node := range nodes {
+		left, right := value(node, 0), value(node, 1)
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte(left>>24<<4|right>>24&0x0f),
+				byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			tree = binary.BigEndian.AppendUint32(tree, left)
+			tree = binary.BigEndian.AppendUint32(tree, right)
+		}
+	}
+
+	file := append(tree, make([]byte, 16)...)
+	file = append(file, data...)
+	file = append(file, mmdbMetadataMarker...)
+	return encodeMMDB(file, map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"database_type":               "Test-Country",
+		"description":                 map[string]any{"en": "test database"},
+		"ip_version":                  uint16(ipVersion),
+		"languages":                   []any{"en"},
+		"node_count":                  uint32(count),
+		"record_size":                 uint16(recordSize),
+	})
+}
+
+var mmdbTestNetworks = []mmdbNetwork{
+	{"81.2.69.0/24", map[string]any{"country": mmdbPointer(0)}},
+	{"10.0.0.0/8", map[string]any{"country": map[string]any{"iso_code": "US"}, "registered_country": mmdbPointer(0)}},
+	{"2001:db8::/32", map[string]any{"country": map[string]any{"iso_code": "FR"}}},
+	{"192.0.2.128/25", map[string]any{"registered_country": map[string]any{"iso_code": "JP"}}},
+}
+
+// the iso code of the country of a record
+func mmdbCountry(record any) string {
+	fields, _ := record.(map[string]any)
+	for _, key := range []string{"country", "registered_country"} {
+		if country, ok := fields[key].(map[string]any); ok {
+			code, _ := country["iso_code"].(string)
+			return code
+		}
+	}
+	return ""
+}
+
+func TestMMDBLookup(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"81.2.69.1", "DE"},
+		{"81.2.69.255", "DE"},
+		{"81.2.70.1", ""},
+		{"10.200.3.4", "US"},
+		{"11.0.0.1", ""},
+		{"192.0.2.200", "JP"},
+		{"192.0.2.100", ""},
+		{"::ffff:81.2.69.1", "DE"},
+		{"2001:db8:1::1", "FR"},
+		{"2001:db9::1", ""},
+		{"::1", ""},
+	}
+	for _, ipVersion := range []int{4, 6} {
+		for _, recordSize := range []int{24, 28, 32} {
+			networks := mmdbTestNetworks
+			if ipVersion == 4 {
+				networks = networks[:2]
+			}
+			db, err := NewMMDB(buildMMDB(t, ipVersion, recordSize, networks))
+			if err != nil {
+				t.Fatalf("ipv%d with %d bit records: %v", ipVersion, recordSize, err)
+			}
+			if db.DatabaseType != "Test-Country" || db.BuildEpoch != 1700000000 {
+				t.Errorf("metadata %q %d", db.DatabaseType, db.BuildEpoch)
+			}
+			for _, tt := range tests {
+				want := tt.want
+				if ipVersion == 4 && (want == "FR" || want == "JP") {
+					want = ""
+				}
+				record, err := db.Lookup(net.ParseIP(tt.ip))
+				if err != nil {
+					t.Errorf("ipv%d/%d: Lookup(%s): %v", ipVersion, recordSize, tt.ip, err)
+					continue
+				}
+				if got := mmdbCountry(record); got != want {
+					t.Errorf("ipv%d/%d: Lookup(%s) = %v, want %q", ipVersion, recordSize, tt.ip, record, want)
+				}
+				if want == "" && record != nil {
+					t.Errorf("ipv%d/%d: Lookup(%s) = %v, want no record", ipVersion, recordSize, tt.ip, record)
+				}
+			}
+		}
+	}
+}
+
+func TestMMDBRecord(t *testing.T) {
+	// the second node of each tree, with records that use every bit of their size
+	tests := []struct {
+		recordSize  uint
+		node        []byte
+		left, right uint
+	}{
+		{24, []byte{0xab, 0xcd, 0xef, 0x12, 0x34, 0x56}, 0xabcdef, 0x123456},
+		// the middle byte holds the high nibble of the left record, then the one of the right record
+		{28, []byte{0xbc, 0xde, 0xf1, 0xa2, 0x34, 0x56, 0x78}, 0xabcdef1, 0x2345678},
+		{32, []byte{0xfe, 0xdc, 0xba, 0x98, 0x01, 0x23, 0x45, 0x67}, 0xfedcba98, 0x01234567},
+	}
+	for _, tt := range tests {
+		buf := append(make([]byte, len(tt.node)), tt.node...)
+		db := &MMDB{buf: buf, recordSize: tt.recordSize}
+		if left, right := db.record(1, 0), db.record(1, 1); left != tt.left || right != tt.right {
+			t.Errorf("%d bit records = %#x, %#x, want %#x, %#x", tt.recordSize, left, right, tt.left, tt.right)
+		}
+		if left, right := db.record(0, 0), db.record(0, 1); left != 0 || right != 0 {
+			t.Errorf("%d bit records of the first node = %#x, %#x, want 0, 0", tt.recordSize, left, right)
+		}
+	}
+}
+
+func TestDecodeMMDB(t *testing.T) {
+	long := string(bytes.Repeat([]byte("x"), 300))
+	huge := string(bytes.Repeat([]byte("y"), 70000))
+	tests := []struct {
+		name  string
+		value any
+		want  any
+	}{
+		{"string", "Deutschland", "Deutschland"},
+		{"empty string", "", ""},
+		{"string of 29", long[:29], long[:29]},
+		{"string of 300", long, long},
+		{"string of 70000", huge, huge},
+		{"double", 3.25, 3.25},
+		{"float", float32(-1.5), float64(-1.5)},
+		{"bytes", []byte{0, 1, 2}, []byte{0, 1, 2}},
+		{"uint16", uint16(443), uint64(443)},
+		{"uint32 zero", uint32(0), uint64(0)},
+		{"uint64", uint64(1) << 60, uint64(1) << 60},
+		{"int32", int32(-7), int64(-7)},
+		{"uint128", new(big.Int).Lsh(big.NewInt(1), 100), new(big.Int).Lsh(big.NewInt(1), 100)},
+		{"true", true, true},
+		{"false", false, false},
+		{"array", []any{"a", uint32(1)}, []any{"a", uint64(1)}},
+		{"nested map", map[string]any{"names": map[string]any{"en": "France"}}, map[string]any{"names": map[string]any{"en": "France"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := encodeMMDB(nil, tt.value)
+			got, next, err := decodeMMDB(buf, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decoded %#v, want %#v", got, tt.want)
+			}
+			if next != uint(len(buf)) {
+				t.Errorf("next value at %d, want %d", next, len(buf))
+			}
+		})
+	}
+
+	// a pointer is followed, and decoding goes on after the pointer rather than after what it points to
+	section := encodeMMDB(nil, "target")
+	start := len(section)
+	section = encodeMMDB(section, []any{mmdbPointer(0), "after"})
+	if got, _, err := decodeMMDB(section, uint(start), 0); err != nil || !reflect.DeepEqual(got, []any{"target", "after"}) {
+		t.Errorf("pointer decoded as %v, %v", got, err)
+	}
+}
+
+func TestDecodeMMDBCorrupt(t *testing.T) {
+	loop := encodeMMDB(nil, mmdbPointer(0))
+	tests := []struct {
+		name    string
+		section []byte
+	}{
+		{"empty", nil},
+		{"truncated string", encodeMMDB(nil, "Deutschland")[:5]},
+		{"truncated size", []byte{2<<5 | 30, 1}},
+		{"truncated pointer", []byte{1 << 5}},
+		{"truncated extended type", []byte{0}},
+		{"pointer loop", loop},
+		{"pointer outside", encodeMMDB(nil, mmdbPointer(100))},
+		{"map key not a string", encodeMMDB(append([]byte{7<<5 | 1}, encodeMMDB(nil, uint32(1))...), "v")},
+		{"map without value", encodeMMDB([]byte{7<<5 | 1}, "k")},
+		{"array too short", encodeMMDB([]byte{0<<5 | 2, 4}, "a")},
+		{"double of 4 bytes", []byte{3<<5 | 4, 0, 0, 0, 0}},
+		{"float of 8 bytes", append([]byte{0<<5 | 8, 8}, make([]byte, 8)...)},
+		{"uint of 9 bytes", append([]byte{0<<5 | 9, 2}, make([]byte, 9)...)},
+		{"int32 of 5 bytes", append([]byte{0<<5 | 5, 1}, make([]byte, 5)...)},
+		{"unknown type", []byte{0, 12}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _, err := decodeMMDB(tt.section, 0, 0); !errors.Is(err, ErrMMDBFormat) {
+				t.Errorf("decoded %v, %v, want %v", got, err, ErrMMDBFormat)
+			}
+		})
+	}
+}
+
+func TestNewMMDBCorrupt(t *testing.T) {
+	valid := buildMMDB(t, 6, 24, mmdbTestNetworks)
+	marker := bytes.LastIndex(valid, mmdbMetadataMarker)
+	withMetadata := func(change map[string]any) []byte {
+		meta := map[string]any{"database_type": "Test", "ip_version": uint16(6), "node_count": uint32(1), "record_size": uint16(24)}
+		for k, v := range change {
+			meta[k] = v
+		}
+		file := append([]byte{}, valid[:marker]...)
+		file = append(file, mmdbMetadataMarker...)
+		return encodeMMDB(file, meta)
+	}
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"empty", nil},
+		{"no metadata", valid[:marker]},
+		{"metadata not a map", encodeMMDB(append([]byte{}, mmdbMetadataMarker...), "meta")},
+		{"record size 16", withMetadata(map[string]any{"record_size": uint16(16)})},
+		{"ip version 5", withMetadata(map[string]any{"ip_version": uint16(5)})},
+		{"tree larger than the file", withMetadata(map[string]any{"node_count": uint32(1 << 20)})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewMMDB(tt.file); err == nil {
+				t.Error("corrupt database opened")
+			}
+		})
+	}
+
+	// a record pointing into the 16 bytes between the tree and the data
+	file := buildMMDB(t, 4, 32, []mmdbNetwork{{"0.0.0.0/1", "x"}})
+	db, err := NewMMDB(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.BigEndian.PutUint32(file[0:], uint32(db.nodeCount+3))
+	if record, err := db.Lookup(net.ParseIP("1.2.3.4")); !errors.Is(err, ErrMMDBFormat) {
+		t.Errorf("Lookup = %v, %v, want %v", record, err, ErrMMDBFormat)
+	}
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// the key of the test vectors of RFC 4226 and RFC 6238, and its base32 form
+var (
+	rfcKey    = []byte("12345678901234567890")
+	rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+)
+
+func TestHOTP(t *testing.T) {
+	// RFC 4226 appendix D
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		if got := HOTP(rfcKey, int64(counter)); got != code {
+			t.Errorf("HOTP(%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestTOTPVectors(t *testing.T) {
+	// RFC 6238 appendix B with sha1, the last six of the eight digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		at := time.Unix(tt.unix, 0)
+		if got := HOTP(rfcKey, TOTPCounter(at)); got != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+		step, ok := CheckTOTP(rfcSecret, tt.code, at)
+		if !ok || step != TOTPCounter(at) {
+			t.Errorf("CheckTOTP at %d = %d, %v, want %d, true", tt.unix, step, ok, TOTPCounter(at))
+		}
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := TOTPCounter(now)
+	code := func(offset int64) string { return HOTP(rfcKey, step+offset) }
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		want     bool
+	}{
+		{"current", rfcSecret, code(0), step, true},
+		{"previous step", rfcSecret, code(-1), step - 1, true},
+		{"next step", rfcSecret, code(1), step + 1, true},
+		{"two steps late", rfcSecret, code(-2), 0, false},
+		{"two steps early", rfcSecret, code(2), 0, false},
+		{"spaces", rfcSecret, " " + code(0)[:3] + " " + code(0)[3:] + " ", step, true},
+		{"lower case secret", strings.ToLower(rfcSecret), code(0), step, true},
+		{"padded secret", rfcSecret + "====", code(0), step, true},
+		{"short code", rfcSecret, code(0)[:5], 0, false},
+		{"long code", rfcSecret, code(0) + "0", 0, false},
+		{"empty code", rfcSecret, "", 0, false},
+		{"wrong code", rfcSecret, "000000", 0, false},
+		{"bad secret", "not base32!", code(0), 0, false},
+		{"other secret", "JBSWY3DPEHPK3PXP", code(0), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := CheckTOTP(tt.secret, tt.code, now)
+			if ok != tt.want || got != tt.wantStep {
+				t.Errorf("CheckTOTP = %d, %v, want %d, %v", got, ok, tt.wantStep, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTOTPSecret(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("secret %q decodes to %d bytes, %v", secret, len(key), err)
+	}
+	if other, _ := NewTOTPSecret(); other == secret {
+		t.Error("two secrets are the same")
+	}
+	if _, ok := CheckTOTP(secret, HOTP(key, TOTPCounter(time.Now())), time.Now()); !ok {
+		t.Error("a code of a new secret isn't accepted")
+	}
+}